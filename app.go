@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"maps"
 	"os"
+	"sync"
 	_ "embed"
+
+	"cg-file-backup/libs/zstdpatch"
 	"github.com/wailsapp/wails/v2/pkg/menu"
 	"github.com/wailsapp/wails/v2/pkg/menu/keys"
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -17,6 +21,19 @@ type App struct {
 	ctx        context.Context
 	cfg        *AppConfig
 	configPath string
+	cfgMu      sync.Mutex // cfg の読み書き (ジョブ・監視・Wails の呼び出しから同時に使われる)
+	sweptRoots sync.Map // 一時ファイルを片付けたバックアップルート (prepareBackupRoot)
+	watchMu    sync.Mutex
+	watchers   map[string]*fileWatcher // 保存を監視中の作業ファイル (StartWatch)
+	scheduleMu sync.Mutex
+	schedules  map[string]BackupSchedule // 作業ファイルごとのスケジュール (nil なら未読み込み)
+	jobMu      sync.Mutex
+	jobs       map[string]*job // 待っている・実行中のジョブ (ID ごと)
+	jobQueue   []*job          // 実行待ちのジョブ (先頭から実行)
+	jobCurrent *job            // 実行中のジョブ
+	jobWorking bool            // runJobs が動いている
+	jobSeq     int
+	rootLocks  sync.Map // バックアップルートごとの排他 (lockRoot)
 }
 
 func NewApp() *App {
@@ -34,13 +51,32 @@ func NewApp() *App {
 func (a *App) startup(Ctx context.Context) {
 	a.ctx = Ctx
 	runtime.WindowSetAlwaysOnTop(a.ctx, a.GetAlwaysOnTop()) 
+	// 前回中断された書き込みの残骸を片付ける
+	go a.cleanupSessionRoots()
+	// スケジュールされたバックアップを実行する
+	go a.runScheduler()
 }
 
 func (a *App) GetConfig() *AppConfig {
-	return a.cfg
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	// 返した後で Wails が JSON にする間も設定は変わりうるので、書き換えられるマップは複製して渡す
+	cfg := *a.cfg
+	cfg.RotationPolicies = maps.Clone(a.cfg.RotationPolicies)
+	cfg.RetentionPolicies = maps.Clone(a.cfg.RetentionPolicies)
+	return &cfg
+}
+
+// configSnapshot は設定の値を cfgMu を取って読み出します (マップは共有なので、読むときは cfgMu を取ること)
+func (a *App) configSnapshot() AppConfig {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	return *a.cfg
 }
 
 func (a *App) SaveConfig(config AppConfig) error {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
 	a.cfg.AlwaysOnTop = config.AlwaysOnTop
 	a.cfg.Language = config.Language
 	a.cfg.RestorePreviousState = config.RestorePreviousState
@@ -52,7 +88,7 @@ func (a *App) SaveConfig(config AppConfig) error {
 }
 
 func (a *App) GetLanguageText(Key string) string {
-	Lang := a.cfg.Language
+	Lang := a.configSnapshot().Language
 	if Lang == "" {
 		Lang = "ja"
 	}
@@ -63,7 +99,7 @@ func (a *App) GetLanguageText(Key string) string {
 }
 
 func (a *App) GetI18N() map[string]string {
-	Lang := a.cfg.Language
+	Lang := a.configSnapshot().Language
 	if Lang == "" {
 		Lang = "ja"
 	}
@@ -71,6 +107,8 @@ func (a *App) GetI18N() map[string]string {
 }
 
 func (a *App) SetLanguage(Lang string) error {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
 	a.cfg.Language = Lang
 	data, err := json.MarshalIndent(a.cfg, "", "  ")
 	if err != nil {
@@ -78,9 +116,11 @@ func (a *App) SetLanguage(Lang string) error {
 	}
 	return os.WriteFile(a.configPath, data, 0644)
 }
-func (a *App) GetAlwaysOnTop() bool { return a.cfg.AlwaysOnTop }
+func (a *App) GetAlwaysOnTop() bool { return a.configSnapshot().AlwaysOnTop }
 
 func (a *App) SetAlwaysOnTop(Flag bool) error {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
 	a.cfg.AlwaysOnTop = Flag
 	data, err := json.MarshalIndent(a.cfg, "", "  ")
 	if err != nil {
@@ -88,11 +128,36 @@ func (a *App) SetAlwaysOnTop(Flag bool) error {
 	}
 	return os.WriteFile(a.configPath, data, 0644)
 }
-func (a *App) GetRestorePreviousState() bool { return a.cfg.RestorePreviousState }
-func (a *App) GetBsdiffMaxFileSize() int64 {return a.cfg.BsdiffMaxFileSize}
-func (a *App) GetAutoBaseGenerationThreshold() float64 {return a.cfg.AutoBaseGenerationThreshold}
+func (a *App) GetRestorePreviousState() bool { return a.configSnapshot().RestorePreviousState }
+func (a *App) GetBsdiffMaxFileSize() int64 {return a.configSnapshot().BsdiffMaxFileSize}
+func (a *App) GetAutoBaseGenerationThreshold() float64 {return a.configSnapshot().AutoBaseGenerationThreshold}
+
+// GetZstdPatchLevel は zstd 差分の圧縮レベル (1〜19) を返します。未設定 (0) や範囲外なら既定の 3 です
+func (a *App) GetZstdPatchLevel() int {
+	level := a.configSnapshot().ZstdPatchLevel
+	if level < 1 || level > zstdpatch.MaxLevel {
+		return zstdpatch.DefaultLevel
+	}
+	return level
+}
+
+// GetVerifyDiffAfterWrite は差分の作成直後に試験復元して確認するかを返します
+func (a *App) GetVerifyDiffAfterWrite() bool { return a.configSnapshot().VerifyDiffAfterWrite }
+
+func (a *App) SetVerifyDiffAfterWrite(Flag bool) error {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	a.cfg.VerifyDiffAfterWrite = Flag
+	data, err := json.MarshalIndent(a.cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.configPath, data, 0644)
+}
 
 func (a *App) SetRestorePreviousState(Flag bool) error {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
 	a.cfg.RestorePreviousState = Flag
 	data, err := json.MarshalIndent(a.cfg, "", "  ")
 	if err != nil {
@@ -113,6 +178,11 @@ func (a *App) setupMenu() *menu.Menu {
 		newValue := !a.GetRestorePreviousState()
 		_ = a.SetRestorePreviousState(newValue)
 	})
+
+	verifyDiffItem := menu.Checkbox(a.GetLanguageText("verifyDiffAfterWrite"), a.GetVerifyDiffAfterWrite(), nil, func(_ *menu.CallbackData) {
+		newValue := !a.GetVerifyDiffAfterWrite()
+		_ = a.SetVerifyDiffAfterWrite(newValue)
+	})
       // スコープ内でフラグを保持（初期値は通常モードなのでfalse）
 compactModeFlag := false
 
@@ -147,6 +217,7 @@ compactModeItem := menu.Checkbox(
 	settingsMenu.Items = []*menu.MenuItem{
 		alwaysOnTopItem,
 		restoreStateItem,
+		verifyDiffItem,
 		compactModeItem,
 		menu.Separator(),
 		englishItem,
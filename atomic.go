@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ----------------- 書き込みの原子性 (一時ファイル + fsync + rename) -----------------
+//
+// バックアップのファイルはすべて、同じフォルダの一時ファイル (.cgb-tmp-<名前>-*) に書き、
+// fsync してから正式な名前へ rename します。途中で止まっても書きかけのファイルが正式な名前で残ることはなく、
+// 残った一時ファイルはバックアップルートを最初に使うとき (prepareBackupRoot) に片付けます。
+
+// atomicTempPrefix は書き込み途中の一時ファイルの接頭辞です (先頭が "." なので一覧・検証の対象外)
+const atomicTempPrefix = ".cgb-tmp-"
+
+// staleTempAge より古い一時ファイルを中断された書き込みの残骸とみなします
+// (別のプロセスが書き込み中のものを消さないよう、新しいものは残します)
+const staleTempAge = time.Hour
+
+// writeFileAtomic は write が f に書いた内容で path を置き換えます。失敗したときは path に触れません
+func writeFileAtomic(path string, write func(f *os.File) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), atomicTempPrefix+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	// CreateTemp は 0600 で作るので、os.Create と同じ権限にそろえる
+	err = tmp.Chmod(0644)
+	if err == nil {
+		err = write(tmp)
+	}
+	if syncErr := tmp.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// writeFileViaTemp は data で path を置き換えます
+func writeFileViaTemp(path string, data []byte) error {
+	return writeFileAtomic(path, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// syncDir は rename した結果をディスクに確定させるためにフォルダを fsync します (Windows では不要)
+func syncDir(dir string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// isAtomicTempName は書き込み途中の一時ファイルの名前かどうかを返します
+// (以前の版が使っていた ".<名前>.tmp*" と、BackupOrDiff の作業用の差分を含む)
+func isAtomicTempName(name string) bool {
+	if strings.HasPrefix(name, atomicTempPrefix) {
+		return true
+	}
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp")
+}
+
+// prepareBackupRoot はバックアップルートを使う前の後片付けです。呼び出し側が lockRoot を持っていること
+// (持たずに行うと、別の処理が組み立て中の圧縮を途中で止まったものとして消してしまいます)。
+// 途中で止まった世代の圧縮は毎回確かめ、古い一時ファイルの削除はプロセスごとに 1 度だけ行います
+func (a *App) prepareBackupRoot(root string) error {
+	if err := recoverCompactions(root); err != nil {
+		return err
+	}
+	if _, done := a.sweptRoots.LoadOrStore(filepath.Clean(root), true); !done {
+		removeStaleTemps(root)
+	}
+	return nil
+}
+
+// prepareBackupRootIfIdle は読むだけの処理 (一覧・整理のプレビュー・起動時) から呼び、
+// ルートの排他をすぐ取れたときだけ prepareBackupRoot を行います。使用中なら何もしません
+func (a *App) prepareBackupRootIfIdle(root string) error {
+	unlock, ok := a.tryLockRoot(root)
+	if !ok {
+		return nil
+	}
+	defer unlock()
+	return a.prepareBackupRoot(root)
+}
+
+// removeStaleTemps は root 以下に残った古い一時ファイルを削除します
+func removeStaleTemps(root string) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// 世代の圧縮の作業用フォルダは recoverCompactions に任せる
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isAtomicTempName(d.Name()) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > staleTempAge {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// cleanupSessionRoots は前回のセッションで開いていたタブのバックアップルートを片付けます (起動時)
+func (a *App) cleanupSessionRoots() {
+	data, err := os.ReadFile(filepath.Join(a.GetConfigDir(), "session.json"))
+	if err != nil {
+		return
+	}
+	var session struct {
+		Tabs []struct {
+			WorkFile  string `json:"workFile"`
+			BackupDir string `json:"backupDir"`
+		} `json:"tabs"`
+	}
+	if json.Unmarshal(data, &session) != nil {
+		return
+	}
+	for _, tab := range session.Tabs {
+		root := tab.BackupDir
+		if root == "" && tab.WorkFile != "" {
+			root = DefaultBackupDir(tab.WorkFile)
+		}
+		if root != "" {
+			a.prepareBackupRootIfIdle(root)
+		}
+	}
+}
@@ -1,14 +1,61 @@
 package main
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"time"
 )
-// GetLatestGeneration 最新の baseN フォルダを特定する
-func (m *GenerationManager) GetLatestGeneration() (*BackupGenInfo, error) {
+
+// ----------------- 世代管理 (GenerationManager) -----------------
+//
+// 世代フォルダ (baseN_タイムスタンプ) の一覧・作成・切り替え判定・検証はすべてここで行います。
+// BackupOrDiff / GetBackupList / 復元処理はフォルダ名を自分で解析せず、GenerationManager を使います。
+
+// generationDirPattern は世代フォルダ名の規則です (base1_20260101_120000。古い "base1" も世代として扱う)
+var generationDirPattern = regexp.MustCompile(`^base(\d+)(?:_.*)?$`)
+
+// generationTimePattern は世代フォルダ名のタイムスタンプ部分です
+var generationTimePattern = regexp.MustCompile(`^base\d+_(\d{8}_\d{6})$`)
+
+// rotateMinWorkSize より小さいファイルはサイズ比による世代交代をしません
+const rotateMinWorkSize = 100 * 1024
+
+// defaultRotateThreshold は設定 (autoBaseGenerationThreshold) が無いときの閾値です
+const defaultRotateThreshold = 0.8
+
+// NewGenerationManager はバックアップルート root の GenerationManager を作成します
+func NewGenerationManager(root string, threshold float64) *GenerationManager {
+	if threshold <= 0 {
+		threshold = defaultRotateThreshold
+	}
+	return &GenerationManager{BackupRoot: root, Threshold: threshold}
+}
+
+// generationManager は設定の閾値を使った GenerationManager を返します
+func (a *App) generationManager(root string) *GenerationManager {
+	return NewGenerationManager(root, a.GetAutoBaseGenerationThreshold())
+}
+
+// ParseGenerationDir はフォルダ名が世代フォルダなら世代番号を返します
+func ParseGenerationDir(name string) (int, bool) {
+	matches := generationDirPattern.FindStringSubmatch(name)
+	if matches == nil {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, false
+	}
+	return idx, true
+}
+
+// ListGenerations は世代フォルダを世代番号の昇順で返します (ルートが無ければ空)
+func (m *GenerationManager) ListGenerations() ([]BackupGenInfo, error) {
 	entries, err := os.ReadDir(m.BackupRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -17,110 +64,260 @@ func (m *GenerationManager) GetLatestGeneration() (*BackupGenInfo, error) {
 		return nil, err
 	}
 
-	// base_連番_タイムスタンプ 形式にマッチする正規表現
-	re := regexp.MustCompile(`^base(\d+)_(\d+)$`)
-	var latestIdx = -1
-	var latestDir string
-
-	for _, entry := range entries {
-		if entry.IsDir() {
-			matches := re.FindStringSubmatch(entry.Name())
-			if len(matches) == 3 {
-				idx, _ := strconv.Atoi(matches[1])
-				if idx > latestIdx {
-					latestIdx = idx
-					latestDir = entry.Name()
-				}
-			}
+	var gens []BackupGenInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if idx, ok := ParseGenerationDir(e.Name()); ok {
+			gens = append(gens, BackupGenInfo{DirPath: filepath.Join(m.BackupRoot, e.Name()), BaseIdx: idx})
 		}
 	}
+	// 同じ番号が複数ある場合は名前 (タイムスタンプ) の順
+	sort.Slice(gens, func(i, j int) bool {
+		if gens[i].BaseIdx != gens[j].BaseIdx {
+			return gens[i].BaseIdx < gens[j].BaseIdx
+		}
+		return gens[i].DirPath < gens[j].DirPath
+	})
+	return gens, nil
+}
+
+// GetLatestGeneration 最新の baseN フォルダを特定する (無ければ nil)
+func (m *GenerationManager) GetLatestGeneration() (*BackupGenInfo, error) {
+	gens, err := m.ListGenerations()
+	if err != nil || len(gens) == 0 {
+		return nil, err
+	}
+	latest := gens[len(gens)-1]
+	return &latest, nil
+}
+
+// CreateGeneration は世代番号 idx のフォルダを作成し、workFile を .base としてコピーする
+func (m *GenerationManager) CreateGeneration(idx int, workFile string) (*BackupGenInfo, error) {
+	ts := time.Now().Format("20060102_150405")
+	newDir := filepath.Join(m.BackupRoot, fmt.Sprintf("base%d_%s", idx, ts))
+	if err := os.MkdirAll(newDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := CopyFile(workFile, m.BasePath(newDir, workFile)); err != nil {
+		return nil, err
+	}
+	return &BackupGenInfo{DirPath: newDir, BaseIdx: idx}, nil
+}
 
-	if latestIdx == -1 {
-		return nil, nil
+// NextGeneration は最新の次の番号で新しい世代を作成する
+func (m *GenerationManager) NextGeneration(workFile string) (*BackupGenInfo, error) {
+	latest, err := m.GetLatestGeneration()
+	if err != nil {
+		return nil, err
+	}
+	idx := 1
+	if latest != nil {
+		idx = latest.BaseIdx + 1
+	}
+	return m.CreateGeneration(idx, workFile)
+}
+
+// ResolveGeneration は最新の世代を返す。世代が一つもなければ base1 を作成する
+func (m *GenerationManager) ResolveGeneration(workFile string) (*BackupGenInfo, error) {
+	latest, err := m.GetLatestGeneration()
+	if err != nil {
+		return nil, err
+	}
+	if latest == nil {
+		return m.CreateGeneration(1, workFile)
 	}
+	return latest, nil
+}
 
-	return &BackupGenInfo{
-		DirPath: filepath.Join(m.BackupRoot, latestDir),
-		BaseIdx: latestIdx,
-	}, nil
+// BasePath は世代フォルダ genDir での workFile の .base のパスを返す
+func (m *GenerationManager) BasePath(genDir, workFile string) string {
+	return filepath.Join(genDir, filepath.Base(workFile)+".base")
 }
 
-// ShouldRotate 新しい世代に切り替えるべきか判定する
-func (m *GenerationManager) ShouldRotate(basePath, diffPath string) bool {
-	baseStat, err := os.Stat(basePath)
+// ValidateGeneration は genDir が workFile の差分を追加できる世代かを確かめる。
+// 世代フォルダ名であること、.base があること、checksum.json に記録があればサイズが一致することを見る
+// (ハッシュまでは計算しない。中身の検証は VerifyBackups で行う)
+func (m *GenerationManager) ValidateGeneration(genDir, workFile string) error {
+	if _, ok := ParseGenerationDir(filepath.Base(genDir)); !ok {
+		return newAppError(ErrNotGenerationDir, genDir, nil)
+	}
+	basePath := m.BasePath(genDir, workFile)
+	info, err := os.Stat(basePath)
 	if err != nil {
-		return false
+		return newAppError(ErrBaseMissing, filepath.Base(basePath), nil)
 	}
-	diffStat, err := os.Stat(diffPath)
+	sums, err := loadChecksums(genDir)
 	if err != nil {
-		return false
+		return err
+	}
+	if entry, ok := sums.Files[filepath.Base(basePath)]; ok && entry.Size != info.Size() {
+		return newAppError(ErrBaseChanged, filepath.Base(basePath), nil)
 	}
+	return nil
+}
 
-	// 差分サイズがベースサイズの Threshold 倍を超えているか
-	return float64(diffStat.Size()) > float64(baseStat.Size())*m.Threshold
+// ShouldRotate 新しい世代に切り替えるべきか判定する
+func (m *GenerationManager) ShouldRotate(genDir, workFile, diffPath string) (bool, error) {
+	reason, err := m.RotationReason(genDir, workFile, diffPath)
+	return reason != "", err
 }
 
+// RotationReason は Policy に照らして世代 genDir を交代すべき理由を返す (交代不要なら "")。
+// diffPath (これから追加する差分) が空なら、差分を作る前に分かる条件 (差分数・日数) だけを見る
+func (m *GenerationManager) RotationReason(genDir, workFile, diffPath string) (string, error) {
+	p := m.Policy
+	diffs, diffTotal, err := m.generationDiffs(genDir)
+	if err != nil {
+		return "", err
+	}
 
-// 最新の世代フォルダ(baseN_...)を探す関数
-func (a *App) FindLatestBaseDir(root string) (string, int) {
-	entries, _ := os.ReadDir(root)
-	re := regexp.MustCompile(`^base(\d+)_`)
-	maxIdx := 0
-	latestPath := ""
+	if p.MaxDiffs > 0 && len(diffs) >= p.MaxDiffs {
+		return fmt.Sprintf("差分数が上限 (%d) に達しました", p.MaxDiffs), nil
+	}
+	if p.MaxAgeDays > 0 {
+		if created, ok := m.generationTime(genDir); ok && time.Since(created) > time.Duration(p.MaxAgeDays)*24*time.Hour {
+			return fmt.Sprintf("世代の作成から %d 日を超えました", p.MaxAgeDays), nil
+		}
+	}
+	if diffPath == "" {
+		return "", nil
+	}
+
+	diffStat, err := os.Stat(diffPath)
+	if err != nil {
+		return "", err
+	}
+
+	// 1回の差分が作業ファイルに比べて大きすぎる
+	ratio := p.SizeRatio
+	if ratio <= 0 {
+		ratio = m.Threshold
+	}
+	minSize := p.MinWorkSize
+	if minSize <= 0 {
+		minSize = rotateMinWorkSize
+	}
+	if workStat, err := os.Stat(workFile); err == nil && workStat.Size() > minSize &&
+		float64(diffStat.Size()) > float64(workStat.Size())*ratio {
+		return fmt.Sprintf("差分が作業ファイルの %.0f%% を超えました", ratio*100), nil
+	}
+
+	// 世代内の差分の合計が .base に比べて大きすぎる
+	if p.CumulativeRatio > 0 {
+		if baseStat, err := os.Stat(m.BasePath(genDir, workFile)); err == nil &&
+			float64(diffTotal+diffStat.Size()) > float64(baseStat.Size())*p.CumulativeRatio {
+			return fmt.Sprintf("世代内の差分の合計が .base の %.0f%% を超えました", p.CumulativeRatio*100), nil
+		}
+	}
+	return "", nil
+}
 
+// generationDiffs は世代フォルダ内の差分ファイルとその合計サイズを返す
+func (m *GenerationManager) generationDiffs(genDir string) ([]string, int64, error) {
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var names []string
+	var total int64
 	for _, e := range entries {
-		if e.IsDir() {
-			matches := re.FindStringSubmatch(e.Name())
-			if len(matches) == 2 {
-				idx, _ := strconv.Atoi(matches[1])
-				if idx >= maxIdx {
-					maxIdx = idx
-					latestPath = filepath.Join(root, e.Name())
-				}
-			}
+		if e.IsDir() || filepath.Ext(e.Name()) != ".diff" || !isBackupArtifactName(e.Name()) {
+			continue
 		}
+		if info, err := e.Info(); err == nil {
+			names = append(names, e.Name())
+			total += info.Size()
+		}
+	}
+	return names, total, nil
+}
+
+// generationTime は世代の作成日時を返す (フォルダ名のタイムスタンプ。無ければフォルダの更新日時)
+func (m *GenerationManager) generationTime(genDir string) (time.Time, bool) {
+	if matches := generationTimePattern.FindStringSubmatch(filepath.Base(genDir)); matches != nil {
+		if t, err := time.ParseInLocation("20060102_150405", matches[1], time.Local); err == nil {
+			return t, true
+		}
+	}
+	info, err := os.Stat(genDir)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// --- 以前からの App API (GenerationManager への窓口) ---
+
+// 最新の世代フォルダ(baseN_...)を探す関数 (無ければ "", 0)
+func (a *App) FindLatestBaseDir(root string) (string, int) {
+	latest, err := a.generationManager(root).GetLatestGeneration()
+	if err != nil || latest == nil {
+		return "", 0
 	}
-	return latestPath, maxIdx
+	return latest.DirPath, latest.BaseIdx
 }
+
 // 最新の世代フォルダを取得（なければ作成）
 func (a *App) ResolveGenerationDir(root, workFile string) (string, int, error) {
-	entries, _ := os.ReadDir(root)
-	re := regexp.MustCompile(`^base(\d+)_`)
-	maxIdx := 0
-	var latestDir string
-
-	for _, e := range entries {
-		if e.IsDir() {
-			matches := re.FindStringSubmatch(e.Name())
-			if len(matches) == 2 {
-				idx, _ := strconv.Atoi(matches[1])
-				if idx >= maxIdx {
-					maxIdx = idx
-					latestDir = filepath.Join(root, e.Name())
-				}
-			}
-		}
-	}
-
-	// 世代が一つもない場合は初回作成
-	if latestDir == "" {
-		newPath, err := a.CreateNewGeneration(root, 1, workFile)
-		return newPath, 1, err
+	gen, err := a.generationManager(root).ResolveGeneration(workFile)
+	if err != nil {
+		return "", 0, err
 	}
-	return latestDir, maxIdx, nil
+	return gen.DirPath, gen.BaseIdx, nil
 }
 
 // 新しい世代フォルダを作成し、.base をコピーする
 func (a *App) CreateNewGeneration(root string, idx int, workFile string) (string, error) {
-	ts := time.Now().Format("20060102_150405")
-	newDir := filepath.Join(root, fmt.Sprintf("base%d_%s", idx, ts))
-	if err := os.MkdirAll(newDir, 0755); err != nil {
-		return "", err
-	}
-	
-	basePath := filepath.Join(newDir, filepath.Base(workFile)+".base")
-	if err := CopyFile(workFile, basePath); err != nil {
+	gen, err := a.generationManager(root).CreateGeneration(idx, workFile)
+	if err != nil {
 		return "", err
 	}
-	return newDir, nil
+	return gen.DirPath, nil
+}
+
+// GetRotationPolicy は作業ファイルの世代交代ルールを返します (未設定ならすべて既定)
+func (a *App) GetRotationPolicy(workFile string) RotationPolicy {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	return a.cfg.RotationPolicies[workFile]
+}
+
+// SetRotationPolicy は作業ファイルの世代交代ルールを保存します
+func (a *App) SetRotationPolicy(workFile string, policy RotationPolicy) error {
+	if policy.SizeRatio < 0 || policy.MinWorkSize < 0 || policy.MaxDiffs < 0 || policy.MaxAgeDays < 0 || policy.CumulativeRatio < 0 {
+		return newAppError(ErrInvalidPolicy, "", nil)
+	}
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	if a.cfg.RotationPolicies == nil {
+		a.cfg.RotationPolicies = map[string]RotationPolicy{}
+	}
+	if policy == (RotationPolicy{}) {
+		delete(a.cfg.RotationPolicies, workFile)
+	} else {
+		a.cfg.RotationPolicies[workFile] = policy
+	}
+	data, err := json.MarshalIndent(a.cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.configPath, data, 0644)
+}
+
+// StartNewGeneration は次の差分を待たずに、今の作業ファイルを .base にした新しい世代を作ります。
+// backupDir が世代フォルダならその親に作ります。作成したフォルダを返します
+func (a *App) StartNewGeneration(workFile, backupDir string) (string, error) {
+	root := backupRootOf(workFile, backupDir)
+	unlock, err := a.lockRoot(context.Background(), root)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	gen, err := a.generationManager(root).NextGeneration(workFile)
+	if err != nil {
+		return "", err
+	}
+	return gen.DirPath, nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"time"
 
 	"github.com/kr/binarydist"
@@ -48,46 +50,38 @@ func (a *App) CreateBsdiff(OldFile, NewFile, DiffFile string) error {
 	if err != nil { return err }
 	defer newF.Close()
 
-	diffF, err := os.Create(DiffFile)
-	if err != nil { return err }
-	defer diffF.Close()
-
-	return binarydist.Diff(oldF, newF, diffF)
+	// 新ファイルをブロックに分けて並列に差分化する (マルチブロック形式)。
+	// 各ブロックはウィンドウ単位なので、メモリ使用量はファイルサイズではなくワーカー数に比例する
+	return writeFileAtomic(DiffFile, func(diffF *os.File) error {
+		return binarydist.DiffParallel(oldF, newF, diffF, bsdiffWorkers())
+	})
 }
 
-
+// bsdiffWorkers は並列に差分化するブロック数です。
+// 1 ブロックあたり 200MB 程度のメモリを使うため、コア数が多くても 4 までに抑えます
+func bsdiffWorkers() int {
+	n := runtime.NumCPU()
+	if n > 4 {
+		n = 4
+	}
+	return n
+}
 
 // ApplyBsdiff は新旧のファイル名規則に対応し、ベースファイルを特定します
 func (a *App) ApplyBsdiff(workFile, diffFile string) error {
-	backupDir := filepath.Dir(diffFile)
-	baseName := filepath.Base(diffFile)
-
-	var guessedBaseName string
-
-	// --- ベースファイル名の推測 ---
-	// 新仕様: filename.20260101_120000.bsdiff.diff (セグメントが多い)
-	if strings.Count(baseName, ".") >= 3 && (strings.Contains(baseName, ".bsdiff.") || strings.Contains(baseName, ".hdiff.")) {
-		parts := strings.Split(baseName, ".")
-		// 後ろから3つ (.timestamp.algo.diff) を除いたものが元のファイル名
-		guessedBaseName = strings.Join(parts[:len(parts)-3], ".") + ".base"
-	} else {
-		// 旧仕様: filename.20240101_120000.diff 
-		// ".20" (日付の始まり) で分割してベース名を取得
-		guessedBaseName = strings.Split(baseName, ".20")[0] + ".base"
-	}
-
-	baseFull := filepath.Join(backupDir, guessedBaseName)
-
-	// 推測したベースが見つからない場合、現在開いているファイル名.base を最終確認
-	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
-		baseFull = filepath.Join(backupDir, filepath.Base(workFile)+".base")
-	}
+	return a.applyBsdiffTo(workFile, diffFile, autoOutputPath(workFile))
+}
 
-	// ファイルの存在確認
-	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
-		return fmt.Errorf("ベースファイル (.base) が見つかりません: %s", guessedBaseName)
-	}
+// applyBsdiffTo は ApplyBsdiff の本体です。復元先 outPath は呼び出し側が決めます
+func (a *App) applyBsdiffTo(workFile, diffFile, outPath string) error {
+	baseFull, err := a.resolveBaseFile(workFile, diffFile)
+	if err != nil { return err }
+	if err := a.patchBsdiff(baseFull, diffFile, outPath); err != nil { return err }
+	return a.verifyRestored(context.Background(), diffFile, outPath)
+}
 
+// patchBsdiff は baseFull に diffFile を適用して outPath に書き出します
+func (a *App) patchBsdiff(baseFull, diffFile, outPath string) error {
 	// --- 実際のパッチ処理 ---
 	oldF, err := os.Open(baseFull)
 	if err != nil { return err }
@@ -97,13 +91,26 @@ func (a *App) ApplyBsdiff(workFile, diffFile string) error {
 	if err != nil { return err }
 	defer patchF.Close()
 
-	outPath := autoOutputPath(workFile)
+	// 形式はファイル先頭のマジックで判別する。
+	// 旧来の BSDIFF40 と並列用のマルチブロック形式 (BSDIFFMB) のどちらも Patch が適用できる
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(patchF, head); err != nil || !binarydist.IsPatch(head) {
+		return newAppError(ErrPatchCorrupt, filepath.Base(diffFile), fmt.Errorf("bsdiff 形式ではありません"))
+	}
+	if _, err := patchF.Seek(0, io.SeekStart); err != nil { return err }
+
 	outF, err := os.Create(outPath)
 	if err != nil { return err }
-	defer outF.Close()
 
-	// binarydist (Bsdiff) によるパッチ
-	return binarydist.Patch(oldF, outF, patchF)
+	// binarydist (Bsdiff) によるパッチ (マルチブロック形式は出力ファイルへ並列に書き込まれる)
+	err = binarydist.Patch(oldF, outF, patchF)
+	if closeErr := outF.Close(); err == nil { err = closeErr }
+	if err != nil {
+		// 途中まで書かれた復元ファイルは残さない
+		os.Remove(outPath)
+		return newAppError(ErrPatchCorrupt, filepath.Base(diffFile), err)
+	}
+	return nil
 }
 
 // ApplyMultiBsdiff はリストを受け取って順次適用します
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------- 整合性マニフェスト (checksum.json) -----------------
+//
+// バックアップを書き出したフォルダ (バックアップルート / 世代フォルダ) ごとに checksum.json を置き、
+// 作成したファイルとその元ファイル・.base の SHA-256 を記録します。
+// VerifyBackups はこれを読み直して、消えたファイル・壊れたファイル・記録にないファイルを報告します。
+
+const (
+	checksumFileName = "checksum.json"
+	checksumVersion  = 1
+)
+
+// バックアップの種類 (ChecksumEntry.Kind)
+const (
+	checksumKindBase    = "base"
+	checksumKindDiff    = "diff"
+	checksumKindCopy    = "copy"
+	checksumKindArchive = "archive"
+	checksumKindDedup   = "dedup"
+)
+
+// ChecksumManifest は checksum.json の中身です。Files のキーはフォルダ内のファイル名です
+type ChecksumManifest struct {
+	Version int                      `json:"version"`
+	Files   map[string]ChecksumEntry `json:"files"`
+}
+
+// ChecksumEntry はバックアップファイル 1 つ分の記録です
+type ChecksumEntry struct {
+	Kind         string `json:"kind"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	Source       string `json:"source,omitempty"`       // バックアップ元のファイル
+	SourceSHA256 string `json:"sourceSha256,omitempty"` // 作成時点の元ファイルのハッシュ
+	Base         string `json:"base,omitempty"`         // 差分が参照する .base (同じフォルダ内のファイル名)
+	BaseSHA256   string `json:"baseSha256,omitempty"`
+	Created      string `json:"created"`
+}
+
+// hashFile はファイルの SHA-256 (16 進) とサイズを返します
+func hashFile(path string) (string, int64, error) {
+	return hashFileContext(context.Background(), path)
+}
+
+// hashFileContext は hashFile と同じですが、読んだ量をジョブの進捗に足し、中止されたら止めます
+func hashFileContext(ctx context.Context, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, newProgressReader(ctx, f))
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// snapshotFile は src を dir 内の一時ファイルへ写し、そのパスと写した内容の SHA-256 を返します。
+// 写しながらハッシュするので、途中で src が保存し直されても、返すハッシュは写しの内容と必ず一致します。
+// 一時ファイルは呼び出し側が消します (失敗したときはここで消します)
+func snapshotFile(ctx context.Context, src, dir string) (string, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", "", err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(dir, atomicTempPrefix+filepath.Base(src)+"-*")
+	if err != nil {
+		return "", "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), newProgressReader(ctx, in))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", "", err
+	}
+	return out.Name(), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadChecksums は dir の checksum.json を読み込みます。無ければ空のマニフェストを返します
+func loadChecksums(dir string) (*ChecksumManifest, error) {
+	m := &ChecksumManifest{Version: checksumVersion, Files: map[string]ChecksumEntry{}}
+	data, err := os.ReadFile(filepath.Join(dir, checksumFileName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, newAppError(ErrChecksumCorrupt, filepath.Join(dir, checksumFileName), err)
+	}
+	if m.Files == nil {
+		m.Files = map[string]ChecksumEntry{}
+	}
+	return m, nil
+}
+
+func saveChecksums(dir string, m *ChecksumManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileViaTemp(filepath.Join(dir, checksumFileName), data)
+}
+
+// recordChecksum は作成したバックアップ artifact を同じフォルダの checksum.json に記録します。
+// source はバックアップ元、sourceSum はバックアップした内容の SHA-256 (checkUnchanged・snapshotFile の結果) です。
+// 書き出した後で source を読み直すと、その間に保存された別の内容を記録してしまうので、ここではハッシュしません。
+// base は差分が参照する .base です (無ければ空)。.base がまだ記録されていなければ一緒に記録します
+func (a *App) recordChecksum(artifact, kind, source, sourceSum, base string) error {
+	dir := filepath.Dir(artifact)
+	m, err := loadChecksums(dir)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Format(time.RFC3339)
+
+	entry := ChecksumEntry{Kind: kind, Created: now}
+	if entry.SHA256, entry.Size, err = hashFile(artifact); err != nil {
+		return err
+	}
+	entry.Source, entry.SourceSHA256 = source, sourceSum
+	if base != "" {
+		entry.Base = filepath.Base(base)
+		if entry.BaseSHA256, _, err = hashFile(base); err != nil {
+			return err
+		}
+		if _, ok := m.Files[entry.Base]; !ok && filepath.Dir(base) == dir {
+			info, err := os.Stat(base)
+			if err != nil {
+				return err
+			}
+			m.Files[entry.Base] = ChecksumEntry{Kind: checksumKindBase, Size: info.Size(), SHA256: entry.BaseSHA256, Created: now}
+		}
+	}
+	m.Files[filepath.Base(artifact)] = entry
+
+	if err := saveChecksums(dir, m); err != nil {
+		return newAppError(ErrWriteFailed, filepath.Join(dir, checksumFileName), err)
+	}
+	return nil
+}
+
+// checkUnchanged は src の SHA-256 を求め、最新のバックアップから内容が変わっていないかを調べます。
+// 同じ内容のバックアップを何度も作らないよう、各バックアップ処理の最初に呼びます
+func (a *App) checkUnchanged(ctx context.Context, src, backupDir string) (BackupResult, error) {
+	setJobPhase(ctx, phaseHash, fileSize(src))
+	sum, _, err := hashFileContext(ctx, src)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	return BackupResult{SHA256: sum, Unchanged: a.matchesLastBackup(src, backupDir, sum)}, nil
+}
+
+// matchesLastBackup は、作業ファイルの最新のバックアップ (手動のものも含む) が内容 sum のときに取ったものかを、
+// バックアップルートと各世代の checksum.json に記録された元ファイルの SHA-256 で確かめます。
+// 同じ時刻の記録が複数あれば、どれか 1 つが一致すればよいとします
+func (a *App) matchesLastBackup(workFile, backupDir, sum string) bool {
+	// 世代フォルダが指定されていればルートから探す
+	root := backupRootOf(workFile, backupDir)
+	dirs := []string{root}
+	if gens, err := a.generationManager(root).ListGenerations(); err == nil {
+		for _, gen := range gens {
+			dirs = append(dirs, gen.DirPath)
+		}
+	}
+
+	// Created は記録したときの時差付きなので、文字列ではなく時刻として比べる (夏時間・タイムゾーンの変更後も正しい順になる)
+	var latest time.Time
+	match := false
+	for _, dir := range dirs {
+		m, err := loadChecksums(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range m.Files {
+			if e.Kind == checksumKindBase || filepath.Clean(e.Source) != filepath.Clean(workFile) {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, e.Created)
+			if err != nil {
+				continue
+			}
+			switch {
+			case created.After(latest):
+				latest, match = created, e.SourceSHA256 == sum
+			case created.Equal(latest):
+				match = match || e.SourceSHA256 == sum
+			}
+		}
+	}
+	return match
+}
+
+// verifyRestored は復元結果 outPath を、バックアップ作成時に記録した元ファイルのハッシュと比べます。
+// 一致しなければ outPath を削除してエラーを返します (古い・別の .base から誤った内容が復元された場合など)。
+// 記録が無い (checksum.json 導入前の) バックアップは比べられないのでそのまま通します
+func (a *App) verifyRestored(ctx context.Context, backupPath, outPath string) error {
+	m, err := loadChecksums(filepath.Dir(backupPath))
+	if err != nil {
+		return err
+	}
+	entry, ok := m.Files[filepath.Base(backupPath)]
+	if !ok || entry.SourceSHA256 == "" {
+		return nil
+	}
+	setJobPhase(ctx, phaseVerify, fileSize(outPath))
+	sum, _, err := hashFileContext(ctx, outPath)
+	if err != nil {
+		return err
+	}
+	if sum != entry.SourceSHA256 {
+		os.Remove(outPath)
+		return newAppError(ErrChecksumMismatch, filepath.Base(backupPath),
+			fmt.Errorf("sha256 %s (記録: %s)", sum, entry.SourceSHA256))
+	}
+	return nil
+}
+
+// ----------------- 検証 -----------------
+
+// 検証で見つかった問題の種類 (VerifyIssue.Kind)
+const (
+	verifyMissing   = "missing"   // 記録にあるのにファイルが無い
+	verifyCorrupted = "corrupted" // ハッシュまたはサイズが記録と違う
+	verifyOrphaned  = "orphaned"  // ファイルがあるのに記録が無い
+)
+
+// VerifyReport は VerifyBackups の結果です
+type VerifyReport struct {
+	Root    string        `json:"root"`
+	Checked int           `json:"checked"` // 検査したファイル数
+	Issues  []VerifyIssue `json:"issues"`
+}
+
+// VerifyIssue は問題のあったファイル 1 つ分です
+type VerifyIssue struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// OK は問題が 1 つも無いかどうかを返します
+func (r *VerifyReport) OK() bool { return len(r.Issues) == 0 }
+
+// HasDamage は消えたファイル・壊れたファイルがあるかを返します (記録の無いファイルは含めません)
+func (r *VerifyReport) HasDamage() bool {
+	for _, is := range r.Issues {
+		if is.Kind != verifyOrphaned {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyBackups はバックアップルート (またはその中の世代フォルダ 1 つ) の checksum.json を読み、
+// 記録されたファイルをすべてハッシュし直して、消えたもの・壊れたもの・記録の無いものを報告します
+func (a *App) VerifyBackups(root string) (*VerifyReport, error) {
+	return a.verifyBackups(context.Background(), root)
+}
+
+// verifyBackups は VerifyBackups の本体です (ジョブから中止できます)
+func (a *App) verifyBackups(ctx context.Context, root string) (*VerifyReport, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, newAppError(ErrNotDirectory, root, nil)
+	}
+
+	report := &VerifyReport{Root: root, Issues: []VerifyIssue{}}
+	dirs := []string{root}
+	if _, ok := ParseGenerationDir(filepath.Base(root)); !ok {
+		gens, err := a.generationManager(root).ListGenerations()
+		if err != nil {
+			return nil, err
+		}
+		for _, gen := range gens {
+			dirs = append(dirs, gen.DirPath)
+		}
+	}
+	setJobPhase(ctx, phaseVerify, 0)
+	for _, dir := range dirs {
+		if err := a.verifyDir(ctx, dir, report); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// verifyDir はフォルダ 1 つ分の checksum.json を検証します
+func (a *App) verifyDir(ctx context.Context, dir string, report *VerifyReport) error {
+	m, err := loadChecksums(dir)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(m.Files))
+	for name := range m.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := m.Files[name]
+		path := filepath.Join(dir, name)
+		report.Checked++
+
+		sum, size, err := hashFileContext(ctx, path)
+		if os.IsNotExist(err) {
+			report.Issues = append(report.Issues, VerifyIssue{Kind: verifyMissing, Path: path})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if sum != entry.SHA256 || size != entry.Size {
+			report.Issues = append(report.Issues, VerifyIssue{Kind: verifyCorrupted, Path: path,
+				Detail: fmt.Sprintf("sha256 %s (記録: %s)", sum, entry.SHA256)})
+			continue
+		}
+		// 差分は参照する .base も作成時と同じでなければ復元できない
+		if entry.Base != "" {
+			basePath := filepath.Join(dir, entry.Base)
+			baseSum, _, err := hashFileContext(ctx, basePath)
+			if os.IsNotExist(err) {
+				if _, recorded := m.Files[entry.Base]; !recorded {
+					report.Issues = append(report.Issues, VerifyIssue{Kind: verifyMissing, Path: basePath,
+						Detail: "参照元: " + name})
+				}
+			} else if err != nil {
+				return err
+			} else if baseSum != entry.BaseSHA256 {
+				report.Issues = append(report.Issues, VerifyIssue{Kind: verifyCorrupted, Path: path,
+					Detail: "参照する " + entry.Base + " が作成時と異なります"})
+			}
+		}
+		// 重複排除バックアップはチャンクも確かめる
+		if entry.Kind == checksumKindDedup {
+			if err := a.verifyManifestChunks(ctx, path); err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				report.Issues = append(report.Issues, VerifyIssue{Kind: verifyCorrupted, Path: path, Detail: err.Error()})
+			}
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !isBackupArtifactName(name) {
+			continue
+		}
+		if _, ok := m.Files[name]; !ok {
+			report.Issues = append(report.Issues, VerifyIssue{Kind: verifyOrphaned, Path: filepath.Join(dir, name)})
+		}
+	}
+	return nil
+}
+
+// isBackupArtifactName はフォルダ内のファイルがバックアップ本体かどうかを返します
+// (checksum.json 自身・メモ (.note)・書き込み途中の一時ファイル (先頭が ".") は対象外)
+func isBackupArtifactName(name string) bool {
+	return name != checksumFileName && !strings.HasSuffix(name, ".note") && !strings.HasPrefix(name, ".")
+}
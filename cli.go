@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ----------------- ヘッドレス CLI モード -----------------
+//
+// cg-file-backup <command> [options] で起動された場合は webview を立ち上げず、
+// App と同じバックアップ/復元ロジックを実行して結果を JSON で標準出力へ書き出します。
+
+// CLI の終了コード
+const (
+	exitOK      = 0 // 成功
+	exitFailed  = 1 // 処理そのものが失敗した
+	exitUsage   = 2 // 引数・オプションの誤り
+	exitInvalid = 3 // verify で復元できないバックアップが見つかった
+
+	// 原因の分かっている失敗 (AppError) は exitFailed の代わりに以下を返す
+	exitMissing     = 4 // 復元に必要な .base・チャンクが無い
+	exitCorrupt     = 5 // バックアップが壊れている・ハッシュが一致しない
+	exitUnsupported = 6 // 未対応の形式・アルゴリズム
+	exitToolMissing = 7 // 必要な外部ツールが無い
+	exitLocked      = 8 // 別のアプリがバックアップフォルダを使用中 (しばらくして再実行できる)
+)
+
+// cliExitCodes はエラーの種類ごとの終了コードです (無いものは exitFailed)
+var cliExitCodes = map[ErrorCode]int{
+	ErrBaseMissing.Code:          exitMissing,
+	ErrChunkMissing.Code:         exitMissing,
+	ErrBaseChanged.Code:          exitCorrupt,
+	ErrPatchCorrupt.Code:         exitCorrupt,
+	ErrManifestCorrupt.Code:      exitCorrupt,
+	ErrChecksumMismatch.Code:     exitCorrupt,
+	ErrRoundTripFailed.Code:      exitCorrupt,
+	ErrUnsupportedArchive.Code:   exitUnsupported,
+	ErrUnsupportedAlgorithm.Code: exitUnsupported,
+	ErrExternalToolMissing.Code:  exitToolMissing,
+	ErrNotGenerationDir.Code:     exitUsage,
+	ErrNotDiffFile.Code:          exitUsage,
+	ErrInvalidPolicy.Code:        exitUsage,
+	ErrPolicyNotSet.Code:         exitUsage,
+	ErrInvalidSchedule.Code:      exitUsage,
+	ErrInvalidPath.Code:          exitUsage,
+	ErrRootLocked.Code:           exitLocked,
+	ErrUnsupportedMode.Code:      exitUsage,
+	ErrNotDirectory.Code:         exitUsage,
+	ErrJobNotFound.Code:          exitUsage,
+	ErrChecksumCorrupt.Code:      exitCorrupt,
+}
+
+// cliCommands は CLI モードとして扱うサブコマンド名です
+var cliCommands = map[string]func(a *App, args []string, stdout, stderr io.Writer) int{
+	"backup":  cliBackup,
+	"compact": cliCompact,
+	"list":    cliList,
+	"newgen":  cliNewGeneration,
+	"prune":   cliPrune,
+	"restore": cliRestore,
+	"verify":  cliVerify,
+	"help":    cliHelp,
+}
+
+// CLIResult は各コマンドが標準出力へ書き出す JSON です
+type CLIResult struct {
+	OK        bool              `json:"ok"`
+	Command   string            `json:"command"`
+	Error     string            `json:"error,omitempty"`
+	ErrorCode ErrorCode         `json:"errorCode,omitempty"` // エラーの種類 (errors.go)
+	WorkFile  string            `json:"workFile,omitempty"`
+	Output    string            `json:"output,omitempty"`
+	Unchanged bool              `json:"unchanged,omitempty"` // backup: 前回から変わっていないので作らなかった
+	Items     []BackupItem      `json:"items,omitempty"`
+	Verify    []CLIVerifyResult `json:"verify,omitempty"`
+	Integrity *VerifyReport     `json:"integrity,omitempty"`
+	Prune     *PrunePlan        `json:"prune,omitempty"`
+	Compact   *CompactResult    `json:"compact,omitempty"`
+}
+
+// CLIVerifyResult は verify コマンドでの1ファイル分の検査結果です
+type CLIVerifyResult struct {
+	FilePath  string    `json:"filePath"`
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	ErrorCode ErrorCode `json:"errorCode,omitempty"`
+}
+
+// isCLICommand は起動引数の先頭が CLI サブコマンドかどうかを判定します
+func isCLICommand(arg string) bool {
+	_, ok := cliCommands[arg]
+	return ok
+}
+
+// runCLI はサブコマンドを実行し、終了コードを返します
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		return cliHelp(nil, nil, stdout, stderr)
+	}
+	if args[0] == "help" {
+		cmd(nil, nil, stdout, stdout)
+		return exitOK
+	}
+	return cmd(NewApp(), args[1:], stdout, stderr)
+}
+
+func cliHelp(_ *App, _ []string, _, stderr io.Writer) int {
+	fmt.Fprint(stderr, `usage: cg-file-backup <command> [options]
+
+commands:
+  backup  [--mode diff|copy|zip|tar|dedup] [--algo hdiff|bsdiff|vcdiff|zstd] [--dir DIR] [--password PW] <workFile>
+  compact <diffFile>               make diffFile's version the .base of its generation and
+                                   re-create the later diffs against it (earlier diffs are deleted)
+  list    [--dir DIR] <workFile>
+  newgen  [--dir DIR] <workFile>   start a new diff generation now
+  prune   [--dir DIR] [--keep-last N] [--keep-daily N] [--keep-weekly N] [--keep-monthly N] [--max-mb N] [--apply] <workFile>
+          delete old backups (dry run unless --apply; without rules the saved ones are used)
+  restore [--out FILE] <workFile> <backupFile>
+  verify  [--dir DIR] <workFile>
+
+results are written to stdout as JSON.
+exit codes: 0 ok, 1 failed, 2 usage error, 3 verify found broken backups,
+            4 .base or chunk missing, 5 corrupt backup or checksum mismatch,
+            6 unsupported format or algorithm, 7 external tool missing,
+            8 backup folder locked by another instance for too long
+`)
+	return exitUsage
+}
+
+// writeCLIResult は結果を JSON で出力し、対応する終了コードを返します
+// (failCode が exitFailed のときは、エラーの種類に応じた終了コードに置き換えます)
+func writeCLIResult(w io.Writer, res CLIResult, err error, failCode int) int {
+	code := exitOK
+	res.OK = err == nil
+	if err != nil {
+		res.Error = err.Error()
+		res.ErrorCode = errorCode(err)
+		code = failCode
+		if c, ok := cliExitCodes[res.ErrorCode]; ok && failCode == exitFailed {
+			code = c
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if encErr := enc.Encode(res); encErr != nil {
+		return exitFailed
+	}
+	return code
+}
+
+// newCLIFlagSet はサブコマンド用の FlagSet を作成します (エラー時は usage を stderr へ)
+func newCLIFlagSet(name string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+// parseCLIArgs はオプションを解析し、位置引数が nArgs 個であることを確認します
+func parseCLIArgs(fs *flag.FlagSet, args []string, nArgs int) bool {
+	if err := fs.Parse(args); err != nil {
+		return false
+	}
+	if fs.NArg() != nArgs {
+		fmt.Fprintf(fs.Output(), "%s: expected %d argument(s), got %d\n", fs.Name(), nArgs, fs.NArg())
+		fs.Usage()
+		return false
+	}
+	return true
+}
+
+func cliBackup(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("backup", stderr)
+	mode := fs.String("mode", "diff", "backup mode: diff, copy, zip, tar or dedup")
+	algo := fs.String("algo", "hdiff", "diff algorithm for --mode diff: "+strings.Join(a.GetDiffAlgorithms(), ", "))
+	dir := fs.String("dir", "", "backup directory (default: cg_backup_<name> next to the work file)")
+	password := fs.String("password", "", "password for --mode zip")
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	res := CLIResult{Command: "backup", WorkFile: workFile}
+	if _, err := os.Stat(workFile); err != nil {
+		return writeCLIResult(stdout, res, err, exitFailed)
+	}
+
+	if !slices.Contains(backupModes, *mode) {
+		fmt.Fprintf(stderr, "unknown mode: %s\n", *mode)
+		return exitUsage
+	}
+	backup, err := a.runBackup(context.Background(), workFile, *dir, *mode, *algo, *password)
+	res.Output, res.Unchanged = backup.Path, backup.Unchanged
+	return writeCLIResult(stdout, res, err, exitFailed)
+}
+
+func cliList(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("list", stderr)
+	dir := fs.String("dir", "", "backup directory")
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	items, err := a.GetBackupList(workFile, *dir)
+	return writeCLIResult(stdout, CLIResult{Command: "list", WorkFile: workFile, Items: items}, err, exitFailed)
+}
+
+func cliNewGeneration(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("newgen", stderr)
+	dir := fs.String("dir", "", "backup directory")
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	res := CLIResult{Command: "newgen", WorkFile: workFile}
+	res.Output, err = a.StartNewGeneration(workFile, *dir)
+	return writeCLIResult(stdout, res, err, exitFailed)
+}
+
+// cliPrune は保持ルールで古いバックアップを整理します (--apply が無ければ削除予定を表示するだけ)
+func cliPrune(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("prune", stderr)
+	dir := fs.String("dir", "", "backup directory")
+	keepLast := fs.Int("keep-last", 0, "keep the N newest backups")
+	keepDaily := fs.Int("keep-daily", 0, "keep the newest backup of each of the last N days")
+	keepWeekly := fs.Int("keep-weekly", 0, "keep the newest backup of each of the last N weeks")
+	keepMonthly := fs.Int("keep-monthly", 0, "keep the newest backup of each of the last N months")
+	maxMB := fs.Int64("max-mb", 0, "keep the backups (with the .base files and chunks they need) under N MB in total")
+	apply := fs.Bool("apply", false, "delete the backups instead of only listing them")
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	policy := RetentionPolicy{KeepLast: *keepLast, KeepDaily: *keepDaily, KeepWeekly: *keepWeekly,
+		KeepMonthly: *keepMonthly, MaxTotalSize: *maxMB << 20}
+	if policy == (RetentionPolicy{}) {
+		policy = a.GetRetentionPolicy(workFile)
+	}
+	res := CLIResult{Command: "prune", WorkFile: workFile}
+	if *apply {
+		res.Prune, err = a.ApplyPrune(workFile, *dir, policy)
+	} else {
+		res.Prune, err = a.PreviewPrune(workFile, *dir, policy)
+	}
+	return writeCLIResult(stdout, res, err, exitFailed)
+}
+
+// cliCompact は差分ファイルのある世代を、その差分の時点から作り直します
+func cliCompact(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("compact", stderr)
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	diffFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	res := CLIResult{Command: "compact"}
+	res.Compact, err = a.CompactGeneration(filepath.Dir(diffFile), diffFile)
+	if res.Compact != nil {
+		res.Output = res.Compact.GenDir
+	}
+	return writeCLIResult(stdout, res, err, exitFailed)
+}
+
+func cliRestore(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("restore", stderr)
+	out := fs.String("out", "", "output file (default: <name>_restored_<timestamp> next to the work file)")
+	if !parseCLIArgs(fs, args, 2) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+	backupFile, err := filepath.Abs(fs.Arg(1))
+	if err != nil {
+		return exitUsage
+	}
+
+	outPath := *out
+	if outPath == "" {
+		outPath = autoOutputPath(workFile)
+	}
+	res := CLIResult{Command: "restore", WorkFile: workFile, Output: outPath}
+	return writeCLIResult(stdout, res, a.restoreBackupTo(context.Background(), backupFile, workFile, outPath), exitFailed)
+}
+
+// cliVerify は一覧にある全バックアップを一時フォルダへ試験復元し、復元できるかを確認します
+func cliVerify(a *App, args []string, stdout, stderr io.Writer) int {
+	fs := newCLIFlagSet("verify", stderr)
+	dir := fs.String("dir", "", "backup directory")
+	if !parseCLIArgs(fs, args, 1) {
+		return exitUsage
+	}
+	workFile, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		return exitUsage
+	}
+
+	res := CLIResult{Command: "verify", WorkFile: workFile}
+	items, err := a.GetBackupList(workFile, *dir)
+	if err != nil {
+		return writeCLIResult(stdout, res, err, exitFailed)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "cg-file-backup-verify-")
+	if err != nil {
+		return writeCLIResult(stdout, res, err, exitFailed)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// checksum.json による整合性チェック (記録の無いファイルは報告のみで失敗扱いにしない)
+	root := *dir
+	if root == "" {
+		root = DefaultBackupDir(workFile)
+	}
+	if a.DirExists(root) {
+		if res.Integrity, err = a.VerifyBackups(root); err != nil {
+			return writeCLIResult(stdout, res, err, exitFailed)
+		}
+	}
+
+	var broken []string
+	for _, item := range items {
+		outPath := filepath.Join(tmpDir, "restored"+filepath.Ext(workFile))
+		vr := CLIVerifyResult{FilePath: item.FilePath, OK: true}
+		if err := a.restoreBackupTo(context.Background(), item.FilePath, workFile, outPath); err != nil {
+			vr.OK = false
+			vr.Error = err.Error()
+			vr.ErrorCode = errorCode(err)
+			broken = append(broken, item.FileName)
+		}
+		os.Remove(outPath)
+		res.Verify = append(res.Verify, vr)
+	}
+
+	if len(broken) > 0 {
+		err = fmt.Errorf("%d backup(s) could not be restored: %s", len(broken), strings.Join(broken, ", "))
+	} else if res.Integrity != nil && res.Integrity.HasDamage() {
+		err = fmt.Errorf("checksum verification found missing or corrupted files")
+	}
+	return writeCLIResult(stdout, res, err, exitInvalid)
+}
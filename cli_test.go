@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCLIUsage(t *testing.T) {
+	newTestApp(t)
+	work := filepath.Join(t.TempDir(), "work.bin")
+	writeTestFile(t, work, []byte("work"))
+
+	cases := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"help"}, exitOK},
+		{"missing argument", []string{"backup"}, exitUsage},
+		{"too many arguments", []string{"list", work, work}, exitUsage},
+		{"unknown flag", []string{"list", "--bogus", work}, exitUsage},
+		{"unknown mode", []string{"backup", "--mode", "bogus", work}, exitUsage},
+		{"bad policy value", []string{"prune", "--keep-last", "x", work}, exitUsage},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if got := runCLI(c.args, &stdout, &stderr); got != c.want {
+				t.Errorf("runCLI(%q) = %d, want %d (stderr: %s)", c.args, got, c.want, stderr.String())
+			}
+			if c.want == exitUsage && stdout.Len() != 0 {
+				t.Errorf("usage error wrote to stdout: %s", stdout.String())
+			}
+		})
+	}
+	if !isCLICommand("backup") || isCLICommand("--help") || isCLICommand("work.bin") {
+		t.Error("isCLICommand does not match the subcommand table")
+	}
+}
+
+func TestRunCLIBackupAndList(t *testing.T) {
+	newTestApp(t)
+	work := filepath.Join(t.TempDir(), "work.bin")
+	writeTestFile(t, work, []byte("work"))
+
+	var stdout, stderr bytes.Buffer
+	if got := runCLI([]string{"backup", "--mode", "zip", work}, &stdout, &stderr); got != exitOK {
+		t.Fatalf("backup = %d, stderr: %s", got, stderr.String())
+	}
+	var res CLIResult
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if !res.OK || res.Command != "backup" || res.Output == "" {
+		t.Errorf("backup result = %+v", res)
+	}
+
+	stdout.Reset()
+	if got := runCLI([]string{"list", work}, &stdout, &stderr); got != exitOK {
+		t.Fatalf("list = %d, stderr: %s", got, stderr.String())
+	}
+	res = CLIResult{}
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Items) != 1 {
+		t.Errorf("list items = %+v, want 1", res.Items)
+	}
+}
+
+func TestWriteCLIResultExitCodes(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		failCode int
+		want     int
+	}{
+		{"ok", nil, exitFailed, exitOK},
+		{"plain error", errors.New("boom"), exitFailed, exitFailed},
+		{"base missing", newAppError(ErrBaseMissing, "a.base", nil), exitFailed, exitMissing},
+		{"checksum mismatch", newAppError(ErrChecksumMismatch, "a.diff", nil), exitFailed, exitCorrupt},
+		{"unsupported", newAppError(ErrUnsupportedAlgorithm, "x", nil), exitFailed, exitUnsupported},
+		{"tool missing", newAppError(ErrExternalToolMissing, "7z", nil), exitFailed, exitToolMissing},
+		{"locked", newAppError(ErrRootLocked, "root", nil), exitFailed, exitLocked},
+		{"wrapped", fmt.Errorf("restore: %w", newAppError(ErrPatchCorrupt, "a.diff", nil)), exitFailed, exitCorrupt},
+		// exitFailed 以外を指定したときはエラーの種類で置き換えない
+		{"explicit fail code", newAppError(ErrChecksumMismatch, "a.diff", nil), exitInvalid, exitInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if got := writeCLIResult(&out, CLIResult{Command: "test"}, c.err, c.failCode); got != c.want {
+				t.Errorf("exit code = %d, want %d", got, c.want)
+			}
+			var res CLIResult
+			if err := json.Unmarshal(out.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.OK != (c.err == nil) {
+				t.Errorf("ok = %v with err %v", res.OK, c.err)
+			}
+			if c.err != nil && (res.Error == "" || !strings.Contains(res.Error, c.err.Error())) {
+				t.Errorf("error = %q, want %q", res.Error, c.err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------- 世代の圧縮 (差分チェーンの付け替え) -----------------
+//
+// 差分の溜まった世代で、指定したバージョン (keepFrom) を復元して新しい .base にし、
+// keepFrom 以降の差分をその .base に対して作り直します。keepFrom より前の差分は削除します。
+// 新しい世代は隣の一時フォルダ (.baseN_....compact-tmp) に組み立て、出来上がってから
+// フォルダごと入れ替えるので、途中で止まっても世代が書きかけの状態になることはありません。
+
+const (
+	compactStagingSuffix = ".compact-tmp" // 組み立て中の新しい世代
+	compactOldSuffix     = ".compact-old" // 入れ替えのために退避した元の世代
+)
+
+// diffNamePattern は差分ファイル名 (file.<ts>.<algo>.diff / 旧形式 file.<ts>.diff) です
+var diffNamePattern = regexp.MustCompile(`^(.+)\.(\d{8}_\d{6})(?:\.[0-9a-z]+)?\.diff$`)
+
+// CompactResult は CompactGeneration の結果です
+type CompactResult struct {
+	GenDir    string   `json:"genDir"`
+	Rewritten []string `json:"rewritten"` // 新しい .base に対して作り直した差分
+	Dropped   []string `json:"dropped"`   // 削除した (keepFrom より前の) 差分
+	OldSize   int64    `json:"oldSize"`   // 圧縮前の世代フォルダの合計サイズ
+	NewSize   int64    `json:"newSize"`
+}
+
+// CompactGeneration は世代 genDir を keepFrom (世代内の差分ファイル) の時点から作り直します
+func (a *App) CompactGeneration(genDir, keepFrom string) (*CompactResult, error) {
+	genDir = filepath.Clean(genDir)
+	if _, ok := ParseGenerationDir(filepath.Base(genDir)); !ok {
+		return nil, newAppError(ErrNotGenerationDir, genDir, nil)
+	}
+	unlock, err := a.lockRoot(context.Background(), filepath.Dir(genDir))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := recoverCompaction(genDir); err != nil {
+		return nil, err
+	}
+	keepName := filepath.Base(keepFrom)
+	matches := diffNamePattern.FindStringSubmatch(keepName)
+	if matches == nil {
+		return nil, newAppError(ErrNotDiffFile, keepName, nil)
+	}
+	fileName := matches[1]
+	baseName := fileName + ".base"
+	// 復元処理は作業ファイルのパスから .base を探すので、世代フォルダ内の同名のパスを渡す
+	workFile := filepath.Join(genDir, fileName)
+
+	entries, err := os.ReadDir(genDir)
+	if err != nil {
+		return nil, err
+	}
+	sums, err := loadChecksums(genDir)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &CompactResult{GenDir: genDir, Rewritten: []string{}, Dropped: []string{}}
+	dropped := map[string]bool{}
+	var others []string // この作業ファイルの差分・.base 以外 (そのまま引き継ぐ)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			return nil, newAppError(ErrCompactFailed, filepath.Base(genDir), fmt.Errorf("世代フォルダ内にフォルダ %s があります", name))
+		}
+		if info, err := e.Info(); err == nil {
+			res.OldSize += info.Size()
+		}
+		if m := diffNamePattern.FindStringSubmatch(name); m != nil && m[1] == fileName {
+			if m[2] < matches[2] || (m[2] == matches[2] && name < keepName) {
+				res.Dropped = append(res.Dropped, name)
+				dropped[name] = true
+			} else {
+				res.Rewritten = append(res.Rewritten, name)
+			}
+			continue
+		}
+		if name == baseName || name == checksumFileName || strings.HasPrefix(name, ".") {
+			continue
+		}
+		others = append(others, name)
+	}
+	sort.Strings(res.Rewritten)
+	if len(res.Rewritten) == 0 || res.Rewritten[0] != keepName {
+		return nil, newAppError(ErrNotDiffFile, keepName, nil)
+	}
+
+	staging := compactSiblingPath(genDir, compactStagingSuffix)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(staging, 0755); err != nil {
+		return nil, err
+	}
+	if err := a.buildCompactedGeneration(genDir, staging, workFile, res.Rewritten, others, dropped, sums); err != nil {
+		os.RemoveAll(staging)
+		return nil, newAppError(ErrCompactFailed, filepath.Base(genDir), err)
+	}
+	if res.NewSize, err = dirSize(staging); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	// 入れ替え: 元の世代を退避 → 新しい世代を所定の名前へ → 退避したものを削除
+	old := compactSiblingPath(genDir, compactOldSuffix)
+	if err := os.RemoveAll(old); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.Rename(genDir, old); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.Rename(staging, genDir); err != nil {
+		if rbErr := os.Rename(old, genDir); rbErr != nil {
+			return nil, newAppError(ErrCompactSwapFailed, old, err)
+		}
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	os.RemoveAll(old)
+	return res, nil
+}
+
+// buildCompactedGeneration は staging に keepFrom (rewrite の先頭) を .base とした新しい世代を組み立てます
+func (a *App) buildCompactedGeneration(genDir, staging, workFile string, rewrite, others []string, dropped map[string]bool, sums *ChecksumManifest) error {
+	fileName := filepath.Base(workFile)
+	newBase := filepath.Join(staging, fileName+".base")
+	now := time.Now().Format(time.RFC3339)
+	m := &ChecksumManifest{Version: checksumVersion, Files: map[string]ChecksumEntry{}}
+
+	// 1. keepFrom の時点を復元して新しい .base にする (記録があればハッシュも確かめられる)
+	if err := a.restoreBackupTo(context.Background(), filepath.Join(genDir, rewrite[0]), workFile, newBase); err != nil {
+		return err
+	}
+	baseSum, baseSize, err := hashFile(newBase)
+	if err != nil {
+		return err
+	}
+	m.Files[filepath.Base(newBase)] = ChecksumEntry{Kind: checksumKindBase, Size: baseSize, SHA256: baseSum, Created: now}
+
+	// 2. keepFrom 以降の各バージョンを復元し、同じアルゴリズム・同じ名前で新しい .base から差分を作り直す
+	version := filepath.Join(staging, ".version.tmp")
+	defer os.Remove(version)
+	for _, name := range rewrite {
+		oldDiff := filepath.Join(genDir, name)
+		engine, err := a.detectDiffAlgorithm(oldDiff)
+		if err != nil {
+			return err
+		}
+		if err := a.restoreBackupTo(context.Background(), oldDiff, workFile, version); err != nil {
+			return err
+		}
+		versionSum, _, err := hashFile(version)
+		if err != nil {
+			return err
+		}
+		newDiff := filepath.Join(staging, name)
+		if err := engine.Create(newBase, version, newDiff); err != nil {
+			return err
+		}
+		// 付け替えた差分は設定にかかわらず必ず試験復元する
+		if err := roundTripDiff(context.Background(), engine, newBase, newDiff, versionSum); err != nil {
+			return err
+		}
+
+		entry, ok := sums.Files[name]
+		if !ok {
+			entry = ChecksumEntry{Kind: checksumKindDiff, Created: now}
+		}
+		if entry.SHA256, entry.Size, err = hashFile(newDiff); err != nil {
+			return err
+		}
+		entry.SourceSHA256 = versionSum
+		entry.Base, entry.BaseSHA256 = filepath.Base(newBase), baseSum
+		m.Files[name] = entry
+	}
+
+	// 3. 残す差分のメモと、他のファイルのバックアップはそのまま引き継ぐ
+	for _, name := range others {
+		if strings.HasSuffix(name, ".note") && dropped[strings.TrimSuffix(name, ".note")] {
+			continue
+		}
+		if err := CopyFile(filepath.Join(genDir, name), filepath.Join(staging, name)); err != nil {
+			return err
+		}
+		if entry, ok := sums.Files[name]; ok {
+			m.Files[name] = entry
+		}
+	}
+	return saveChecksums(staging, m)
+}
+
+// compactSiblingPath は世代フォルダの隣に置く作業用フォルダのパスです (先頭が "." なので世代としては扱われない)
+func compactSiblingPath(genDir, suffix string) string {
+	return filepath.Join(filepath.Dir(genDir), "."+filepath.Base(genDir)+suffix)
+}
+
+// recoverCompaction は前回の圧縮が途中で止まっていた場合に世代フォルダを元に戻し、作業用フォルダを片付けます。
+// 新しい世代は完成してから入れ替えるので、退避後に止まっていれば完成した新しい世代を、無ければ元の世代を戻します
+func recoverCompaction(genDir string) error {
+	staging := compactSiblingPath(genDir, compactStagingSuffix)
+	old := compactSiblingPath(genDir, compactOldSuffix)
+	if _, err := os.Stat(genDir); os.IsNotExist(err) {
+		if _, err := os.Stat(old); err == nil {
+			restore := old
+			if _, err := os.Stat(staging); err == nil {
+				restore = staging
+			}
+			if err := os.Rename(restore, genDir); err != nil {
+				return newAppError(ErrCompactRecoverFailed, genDir, err)
+			}
+		}
+	}
+	if err := os.RemoveAll(staging); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+// recoverCompactions はバックアップルート内の、途中で止まった圧縮をすべて元に戻します
+func recoverCompactions(root string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	done := map[string]bool{}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() || !strings.HasPrefix(name, ".") {
+			continue
+		}
+		genName := strings.TrimSuffix(strings.TrimSuffix(name[1:], compactStagingSuffix), compactOldSuffix)
+		if genName == name[1:] || done[genName] {
+			continue
+		}
+		done[genName] = true
+		if err := recoverCompaction(filepath.Join(root, genName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirSize はフォルダ直下のファイルの合計サイズです
+func dirSize(dir string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() {
+			total += info.Size()
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compactFixture は差分 4 つ (アルゴリズムはそれぞれ別) を持つ世代フォルダです
+type compactFixture struct {
+	a        *App
+	work     string
+	root     string
+	genDir   string
+	diffs    []string          // 古い順
+	versions map[string][]byte // 差分のファイル名 → その時点の内容
+}
+
+func newCompactFixture(t *testing.T) *compactFixture {
+	t.Helper()
+	a := newTestApp(t)
+	work := filepath.Join(t.TempDir(), "work.bin")
+	r := rand.New(rand.NewSource(1))
+	data := make([]byte, 200<<10)
+	r.Read(data)
+	writeTestFile(t, work, data)
+
+	root := DefaultBackupDir(work)
+	gen, err := a.generationManager(root).NextGeneration(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := a.generationManager(root).BasePath(gen.DirPath, work)
+	f := &compactFixture{a: a, work: work, root: root, genDir: gen.DirPath, versions: map[string][]byte{}}
+	stamps := []string{"20240101_100000", "20240101_110000", "20240101_120000", "20240101_130000"}
+	for i, algo := range []string{"hdiff", "bsdiff", "zstd", "vcdiff"} {
+		// 少しずつ書き換えた版を作る
+		data = append([]byte(nil), data...)
+		for j := 0; j < 50; j++ {
+			data[r.Intn(len(data))]++
+		}
+		data = append(data, []byte(strings.Repeat(algo, 100))...)
+		writeTestFile(t, work, data)
+
+		engine, err := a.diffAlgorithm(algo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Base(work) + "." + stamps[i] + "." + algo + ".diff"
+		path := filepath.Join(gen.DirPath, name)
+		if err := engine.Create(base, work, path); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		if err := a.recordChecksum(path, checksumKindDiff, work, hex.EncodeToString(sum[:]), base); err != nil {
+			t.Fatal(err)
+		}
+		f.diffs = append(f.diffs, name)
+		f.versions[name] = data
+	}
+	return f
+}
+
+// checkRestores は genDir の差分 names がすべて記録どおりの内容に復元できることを確かめます
+func (f *compactFixture) checkRestores(t *testing.T, names []string) {
+	t.Helper()
+	for _, name := range names {
+		out := filepath.Join(t.TempDir(), "restored.bin")
+		if err := f.a.restoreBackupTo(context.Background(), filepath.Join(f.genDir, name), f.work, out); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(readTestFile(t, out), f.versions[name]) {
+			t.Errorf("%s: restored data differs", name)
+		}
+	}
+}
+
+// checkNoLeftovers はルートに圧縮の作業用フォルダが残っていないことを確かめます
+func (f *compactFixture) checkNoLeftovers(t *testing.T) {
+	t.Helper()
+	entries, err := os.ReadDir(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), compactStagingSuffix) || strings.HasSuffix(e.Name(), compactOldSuffix) {
+			t.Errorf("%s left in the backup root", e.Name())
+		}
+	}
+}
+
+// copyDir はフォルダ直下のファイルを dst にコピーします
+func copyDir(t *testing.T, src, dst string) {
+	t.Helper()
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if err := CopyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompactGeneration(t *testing.T) {
+	f := newCompactFixture(t)
+	res, err := f.a.CompactGeneration(f.genDir, filepath.Join(f.genDir, f.diffs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Dropped) != 1 || res.Dropped[0] != f.diffs[0] {
+		t.Errorf("Dropped = %v, want [%s]", res.Dropped, f.diffs[0])
+	}
+	if strings.Join(res.Rewritten, ",") != strings.Join(f.diffs[1:], ",") {
+		t.Errorf("Rewritten = %v, want %v", res.Rewritten, f.diffs[1:])
+	}
+	if _, err := os.Stat(filepath.Join(f.genDir, f.diffs[0])); !os.IsNotExist(err) {
+		t.Errorf("dropped diff is left: %v", err)
+	}
+	f.checkRestores(t, f.diffs[1:])
+	f.checkNoLeftovers(t)
+
+	// 付け替えた差分も含め、記録と中身が一致している
+	report, err := f.a.VerifyBackups(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Errorf("VerifyBackups after compaction: %+v", report.Issues)
+	}
+}
+
+// 圧縮が途中で止まった各状態から、欠けの無い世代に戻る
+func TestRecoverCompaction(t *testing.T) {
+	cases := []struct {
+		name string
+		// crash は元の世代 (orig) と圧縮後の世代 (compacted) のコピーから、止まった時点のフォルダを作ります
+		crash func(t *testing.T, f *compactFixture, orig, compacted string)
+		// compacted は戻った世代が圧縮後のものか (false なら元の世代)
+		compacted bool
+	}{
+		{"staging only", func(t *testing.T, f *compactFixture, orig, compacted string) {
+			// 組み立て中に止まった: 新しい世代は書きかけ
+			copyDir(t, orig, f.genDir)
+			staging := compactSiblingPath(f.genDir, compactStagingSuffix)
+			copyDir(t, compacted, staging)
+			os.Remove(filepath.Join(staging, checksumFileName))
+		}, false},
+		{"staging and old", func(t *testing.T, f *compactFixture, orig, compacted string) {
+			// 元の世代を退避した直後に止まった
+			copyDir(t, orig, compactSiblingPath(f.genDir, compactOldSuffix))
+			copyDir(t, compacted, compactSiblingPath(f.genDir, compactStagingSuffix))
+		}, true},
+		{"old only, swapped", func(t *testing.T, f *compactFixture, orig, compacted string) {
+			// 入れ替えた後、退避した元の世代を消す前に止まった
+			copyDir(t, compacted, f.genDir)
+			copyDir(t, orig, compactSiblingPath(f.genDir, compactOldSuffix))
+		}, true},
+		{"old only, not swapped", func(t *testing.T, f *compactFixture, orig, compacted string) {
+			// 退避した後、新しい世代が無い (組み立てに失敗した後の巻き戻しの途中)
+			copyDir(t, orig, compactSiblingPath(f.genDir, compactOldSuffix))
+		}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := newCompactFixture(t)
+			orig := filepath.Join(t.TempDir(), "orig")
+			copyDir(t, f.genDir, orig)
+			if _, err := f.a.CompactGeneration(f.genDir, filepath.Join(f.genDir, f.diffs[1])); err != nil {
+				t.Fatal(err)
+			}
+			compacted := filepath.Join(t.TempDir(), "compacted")
+			copyDir(t, f.genDir, compacted)
+			if err := os.RemoveAll(f.genDir); err != nil {
+				t.Fatal(err)
+			}
+			c.crash(t, f, orig, compacted)
+
+			// 一覧などの読むだけの処理からも、排他を取って片付ける
+			if _, err := f.a.GetBackupList(f.work, ""); err != nil {
+				t.Fatal(err)
+			}
+			f.checkNoLeftovers(t)
+			if c.compacted {
+				f.checkRestores(t, f.diffs[1:])
+				if _, err := os.Stat(filepath.Join(f.genDir, f.diffs[0])); !os.IsNotExist(err) {
+					t.Errorf("recovered the original generation instead of the compacted one: %v", err)
+				}
+			} else {
+				f.checkRestores(t, f.diffs)
+			}
+		})
+	}
+}
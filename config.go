@@ -9,6 +9,10 @@ type AppConfig struct {
     RestorePreviousState bool               `json:"getRestoreState"`
     BsdiffMaxFileSize int64                 `json:"bsdiffMaxFileSize"`
     AutoBaseGenerationThreshold float64     `json:"autoBaseGenerationThreshold"`
+    ZstdPatchLevel int                      `json:"zstdPatchLevel"`
+    VerifyDiffAfterWrite bool               `json:"verifyDiffAfterWrite"`
+    RotationPolicies map[string]RotationPolicy `json:"rotationPolicies,omitempty"` // 作業ファイルのパスごとの世代交代ルール
+    RetentionPolicies map[string]RetentionPolicy `json:"retentionPolicies,omitempty"` // 作業ファイルのパスごとの保持ルール
     I18N     map[string]map[string]string  `json:"i18n"`
 }
 
@@ -45,7 +49,34 @@ func LoadAppConfig() (*AppConfig, string, error) {
     if err := json.Unmarshal(data, &cfg); err != nil {
         return nil, "", err
     }
+    // 以前の版が書いた設定ファイルには、後から増えた翻訳が無い
+    if err := mergeDefaultI18N(&cfg, embeddedConfig); err != nil {
+        return nil, "", err
+    }
 
     return &cfg, configPath, nil
 }
+
+// mergeDefaultI18N は defaults (embed された設定) の翻訳のうち、cfg に無い言語・キーだけを補います
+// (ユーザーが書き換えた翻訳はそのまま残す)
+func mergeDefaultI18N(cfg *AppConfig, defaults []byte) error {
+    var def AppConfig
+    if err := json.Unmarshal(defaults, &def); err != nil {
+        return err
+    }
+    if cfg.I18N == nil {
+        cfg.I18N = map[string]map[string]string{}
+    }
+    for lang, texts := range def.I18N {
+        if cfg.I18N[lang] == nil {
+            cfg.I18N[lang] = map[string]string{}
+        }
+        for key, text := range texts {
+            if _, ok := cfg.I18N[lang][key]; !ok {
+                cfg.I18N[lang][key] = text
+            }
+        }
+    }
+    return nil
+}
 
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 以前の版が書いた設定ファイルにも、後から増えた翻訳が補われる
+func TestLoadAppConfigMergesNewI18NKeys(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("AppData", dir)
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var def AppConfig
+	if err := json.Unmarshal(embeddedConfig, &def); err != nil {
+		t.Fatal(err)
+	}
+	old := AppConfig{
+		Language: "ja",
+		I18N: map[string]map[string]string{
+			"ja": {"errWriteFailed": "書き換えた訳"},
+		},
+	}
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(configDir, "cg-file-backup", "AppConfig.json"), data)
+
+	cfg, _, err := LoadAppConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.I18N["ja"]["errWriteFailed"]; got != "書き換えた訳" {
+		t.Errorf("user's translation was overwritten: %q", got)
+	}
+	for lang, texts := range def.I18N {
+		for key, text := range texts {
+			if lang == "ja" && key == "errWriteFailed" {
+				continue
+			}
+			if got := cfg.I18N[lang][key]; got != text {
+				t.Errorf("i18n[%s][%s] = %q, want the default %q", lang, key, got, text)
+			}
+		}
+	}
+	if cfg.Language != "ja" {
+		t.Errorf("language = %q, want the saved ja", cfg.Language)
+	}
+}
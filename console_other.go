@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// attachParentConsole は Windows 以外では何もしません (端末から起動すれば標準出力はそのままつながっている)
+func attachParentConsole() {}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// attachParentConsole は CLI として起動されたとき、標準出力・標準エラーを親プロセス (コマンドプロンプトなど) のコンソールへつなぎます
+// (Wails の exe は GUI サブシステムなのでコンソールを持たず、そのままでは何も表示されない)
+func attachParentConsole() {
+	stdout, _ := syscall.GetStdHandle(syscall.STD_OUTPUT_HANDLE)
+	stderr, _ := syscall.GetStdHandle(syscall.STD_ERROR_HANDLE)
+	if validHandle(stdout) && validHandle(stderr) {
+		return // ファイルやパイプへリダイレクトされている
+	}
+
+	const attachParentProcess = ^uintptr(0) // ATTACH_PARENT_PROCESS ((DWORD)-1)
+	attach := syscall.NewLazyDLL("kernel32.dll").NewProc("AttachConsole")
+	if r, _, _ := attach.Call(attachParentProcess); r == 0 {
+		return // 親にコンソールが無い (エクスプローラーやタスクスケジューラから起動)
+	}
+	con, err := os.OpenFile("CONOUT$", os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	if !validHandle(stdout) {
+		os.Stdout = con
+	}
+	if !validHandle(stderr) {
+		os.Stderr = con
+	}
+}
+
+func validHandle(h syscall.Handle) bool {
+	return h != 0 && h != syscall.InvalidHandle
+}
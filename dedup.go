@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cg-file-backup/libs/fastcdc"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ----------------- 重複排除 (チャンク) バックアップ -----------------
+//
+// 保存のたびにファイルを FastCDC で可変長チャンクに分割し、
+// 中身の SHA-256 を名前にしてバックアップルートの chunks/ に 1 度だけ保存します。
+// 各バージョンはチャンクの並びを記録したマニフェスト (file.<ts>.manifest) で表し、
+// .base や他の差分に頼らずにどのバージョンでも直接復元できます。
+
+const (
+	dedupChunkDir       = "chunks"    // チャンクストアのフォルダ名 (バックアップルート直下)
+	dedupManifestExt    = ".manifest" // マニフェストの拡張子
+	dedupManifestFormat = 1
+)
+
+// DedupManifest は 1 バージョン分のマニフェストです
+type DedupManifest struct {
+	Format   int          `json:"format"`
+	FileName string       `json:"fileName"`
+	Size     int64        `json:"size"`
+	SHA256   string       `json:"sha256"` // ファイル全体のハッシュ
+	Created  string       `json:"created"`
+	Chunks   []DedupChunk `json:"chunks"`
+}
+
+// DedupChunk はマニフェスト内のチャンク 1 つ分です
+type DedupChunk struct {
+	Hash string `json:"hash"` // 非圧縮データの SHA-256 (16 進)
+	Size int64  `json:"size"`
+}
+
+// chunkPath はチャンクの保存先 (chunks/ab/abcdef...) を返します
+func chunkPath(root, hash string) string {
+	return filepath.Join(root, dedupChunkDir, hash[:2], hash)
+}
+
+// DedupBackupFile は src をチャンクに分割して backupDir (世代フォルダならその親) のチャンクストアに保存し、
+// このバージョンのマニフェストを書き出します (最新のバックアップから変わっていなければ何もしません)
+func (a *App) DedupBackupFile(src, backupDir string) (BackupResult, error) {
+	return a.dedupBackup(context.Background(), src, backupDir)
+}
+
+// dedupBackup は DedupBackupFile の本体です (ジョブから中止できます)
+func (a *App) dedupBackup(ctx context.Context, src, backupDir string) (BackupResult, error) {
+	// チャンクストアとマニフェストは、世代フォルダが指定されてもバックアップルートに置く
+	// (世代フォルダに chunks/ を作ると、整理がそのチャンクを回収できず、その世代は圧縮もできなくなる)
+	root := backupRootOf(src, backupDir)
+	unlock, err := a.lockRoot(ctx, root)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer unlock()
+	res, err := a.checkUnchanged(ctx, src, root)
+	if err != nil || res.Unchanged {
+		return res, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, dedupChunkDir), 0755); err != nil {
+		return res, err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return res, err
+	}
+	defer in.Close()
+
+	setJobPhase(ctx, phaseChunk, fileSize(src))
+	chunker, err := fastcdc.NewChunker(newProgressReader(ctx, in), fastcdc.DefaultOptions)
+	if err != nil {
+		return res, err
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return res, err
+	}
+	defer enc.Close()
+
+	now := time.Now()
+	m := DedupManifest{
+		Format:   dedupManifestFormat,
+		FileName: filepath.Base(src),
+		Created:  now.Format(time.RFC3339),
+	}
+	// 中止・失敗したときは、このバックアップで新しく保存したチャンクを残さない
+	var added []string
+	defer func() {
+		if res.Path == "" {
+			for _, path := range added {
+				os.Remove(path)
+			}
+		}
+	}()
+	whole := sha256.New()
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		whole.Write(data)
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		created, err := storeChunk(root, hash, enc.EncodeAll(data, nil))
+		if err != nil {
+			return res, newAppError(ErrWriteFailed, chunkPath(root, hash), err)
+		}
+		if created {
+			added = append(added, chunkPath(root, hash))
+		}
+		m.Chunks = append(m.Chunks, DedupChunk{Hash: hash, Size: int64(len(data))})
+		m.Size += int64(len(data))
+	}
+	// 記録するのは実際にチャンクにした内容のハッシュ (最初に確かめた後で保存されていても食い違わない)
+	m.SHA256 = hex.EncodeToString(whole.Sum(nil))
+	res.SHA256 = m.SHA256
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return res, err
+	}
+	name := filepath.Base(src) + "." + now.Format("20060102_150405") + dedupManifestExt
+	manifestPath := filepath.Join(root, name)
+	if err := writeFileViaTemp(manifestPath, data); err != nil {
+		return res, err
+	}
+	res.Path = manifestPath
+	return res, a.recordChecksum(manifestPath, checksumKindDedup, src, res.SHA256, "")
+}
+
+// storeChunk は圧縮済みチャンクを保存し、新しく作ったかを返します。同じハッシュのチャンクが既にあれば何もしません
+func storeChunk(root, hash string, compressed []byte) (bool, error) {
+	path := chunkPath(root, hash)
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, err
+	}
+	return true, writeFileViaTemp(path, compressed)
+}
+
+// readManifest はマニフェストを読み込みます
+func readManifest(path string) (*DedupManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m DedupManifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, newAppError(ErrManifestCorrupt, filepath.Base(path), err)
+	}
+	if m.Format != dedupManifestFormat {
+		return nil, newAppError(ErrUnsupportedArchive, filepath.Base(path), fmt.Errorf("マニフェスト形式 %d", m.Format))
+	}
+	// ハッシュはチャンクのパスになるので、chunks/ の外を指す値を復元・検証・整理に渡さない
+	for i, c := range m.Chunks {
+		if !isChunkHash(c.Hash) {
+			return nil, newAppError(ErrManifestCorrupt, filepath.Base(path), fmt.Errorf("チャンク %d のハッシュ %q", i, c.Hash))
+		}
+	}
+	return &m, nil
+}
+
+// isChunkHash は s がチャンクの名前 (SHA-256 の小文字 16 進 64 文字) かどうかを返します
+func isChunkHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreManifestTo はマニフェストのチャンクを順に展開して outPath に書き出します。
+// チャンクごとと全体のハッシュを確認し、合わなければ出力を残しません
+func (a *App) restoreManifestTo(ctx context.Context, manifestPath, outPath string) error {
+	m, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	setJobPhase(ctx, phaseRestore, m.Size)
+	err = copyManifestChunks(ctx, m, filepath.Dir(manifestPath), out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// 途中まで書かれた復元ファイルは残さない
+		os.Remove(outPath)
+		if errorCode(err) == "" && ctx.Err() == nil {
+			// チャンクの欠落・破損 (AppError) 以外は書き出しの失敗
+			err = newAppError(ErrWriteFailed, outPath, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// verifyManifestChunks はマニフェストの全チャンクを展開してハッシュを確かめます (書き出しはしません)
+func (a *App) verifyManifestChunks(ctx context.Context, manifestPath string) error {
+	m, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	return copyManifestChunks(ctx, m, filepath.Dir(manifestPath), io.Discard)
+}
+
+// copyManifestChunks はチャンクストア root からマニフェストのチャンクを順に展開して w に書き出します。
+// チャンクごとと全体のハッシュを確認します
+func copyManifestChunks(ctx context.Context, m *DedupManifest, root string, w io.Writer) error {
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	whole := sha256.New()
+	var buf []byte
+	for i, c := range m.Chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		compressed, err := os.ReadFile(chunkPath(root, c.Hash))
+		if err != nil {
+			return newAppError(ErrChunkMissing, c.Hash, fmt.Errorf("チャンク %d: %w", i, err))
+		}
+		buf, err = dec.DecodeAll(compressed, buf[:0])
+		if err != nil {
+			return newAppError(ErrManifestCorrupt, c.Hash, fmt.Errorf("チャンク %d: %w", i, err))
+		}
+		sum := sha256.Sum256(buf)
+		if int64(len(buf)) != c.Size || hex.EncodeToString(sum[:]) != c.Hash {
+			return newAppError(ErrManifestCorrupt, c.Hash, fmt.Errorf("チャンク %d の内容が一致しません", i))
+		}
+		whole.Write(buf)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+		addJobProgress(ctx, c.Size)
+	}
+	if hex.EncodeToString(whole.Sum(nil)) != m.SHA256 {
+		return newAppError(ErrManifestCorrupt, "", fmt.Errorf("復元したファイルのハッシュが一致しません"))
+	}
+	return nil
+}
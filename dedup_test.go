@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 世代フォルダを指定しても、チャンクとマニフェストはバックアップルートに保存する
+func TestDedupBackupIntoGenerationDir(t *testing.T) {
+	a := newTestApp(t)
+	work := filepath.Join(t.TempDir(), "work.bin")
+	data := bytes.Repeat([]byte("dedup backup data "), 20000)
+	writeTestFile(t, work, data)
+
+	root := DefaultBackupDir(work)
+	gen, err := a.generationManager(root).NextGeneration(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := a.DedupBackupFile(work, gen.DirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(res.Path) != root {
+		t.Errorf("manifest written to %s, want %s", res.Path, root)
+	}
+	if _, err := os.Stat(filepath.Join(gen.DirPath, dedupChunkDir)); !os.IsNotExist(err) {
+		t.Errorf("chunk store created in the generation folder: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, dedupChunkDir)); err != nil {
+		t.Errorf("no chunk store in the backup root: %v", err)
+	}
+
+	out := filepath.Join(t.TempDir(), "restored.bin")
+	if err := a.restoreBackupTo(context.Background(), res.Path, work, out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readTestFile(t, out), data) {
+		t.Error("restored data differs")
+	}
+
+	// 同じ内容はどちらを指定しても変更なしになる
+	if res, err := a.DedupBackupFile(work, ""); err != nil || !res.Unchanged {
+		t.Errorf("second backup: %+v, %v; want unchanged", res, err)
+	}
+}
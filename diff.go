@@ -1,132 +1,248 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 )
 
 
-func (a *App) BackupOrDiff(workFile, customDir, algo string) error {
+func (a *App) BackupOrDiff(workFile, customDir, algo string) (BackupResult, error) {
+	return a.backupOrDiff(context.Background(), workFile, customDir, algo)
+}
+
+// backupOrDiff は BackupOrDiff の本体です (ジョブから中止できます)
+func (a *App) backupOrDiff(ctx context.Context, workFile, customDir, algo string) (BackupResult, error) {
 	root := customDir
 	if root == "" {
 		root = DefaultBackupDir(workFile)
 	}
 
-	var targetDir string
-	var currentIdx int
-	var err error
+	// アルゴリズムはレジストリから名前で選ぶ (空なら既定)
+	engine, err := a.diffAlgorithm(algo)
+	if err != nil {
+		return BackupResult{}, err
+	}
+	algo = engine.Name()
+
+	// 世代の作成から差分の確定までは、同じルートを使う他の処理 (別のタブ・アプリ) と重ならないようにする
+	unlock, err := a.lockRoot(ctx, backupRootOf(workFile, customDir))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer unlock()
+
+	// 最新のバックアップから変わっていなければ差分を作らない (直前に待った処理が同じ内容を保存した場合も含む)
+	res, err := a.checkUnchanged(ctx, workFile, customDir)
+	if err != nil || res.Unchanged {
+		return res, err
+	}
 
 	// --- 1. JS側から特定の世代フォルダ (.../baseN) が指定されているか判定 ---
-	baseFolder := filepath.Base(root)
-	if strings.HasPrefix(baseFolder, "base") {
-		// 指定があればそれを使う
-		targetDir = root
-		fmt.Sscanf(baseFolder, "base%d", &currentIdx)
-	} else {
-		// 指定がなければ（親フォルダなら）最新を探索
-		targetDir, currentIdx, err = a.ResolveGenerationDir(root, workFile)
-		if err != nil {
-			return err
-		}
+	var gen *BackupGenInfo
+	if idx, ok := ParseGenerationDir(filepath.Base(root)); ok {
+		// 指定があればそれを使う (世代交代はその親フォルダに作る)
+		gen = &BackupGenInfo{DirPath: root, BaseIdx: idx}
+		root = filepath.Dir(root)
+	}
+	// 途中で止まった世代の圧縮・書き込みの残骸があれば先に片付ける
+	if err := a.prepareBackupRoot(root); err != nil {
+		return BackupResult{}, err
 	}
 
-	// 念のためフォルダ作成
-	if _, err := os.Stat(targetDir); os.IsNotExist(err) {
-		os.MkdirAll(targetDir, 0755)
+	// 作業ファイルは差分を作っている間にも保存されうるので、いまの内容を写した一時ファイルから差分を作り、
+	// 記録するハッシュもその写しのものにする (差分の中身と記録が食い違うと、その差分は復元できなくなる)
+	setJobPhase(ctx, phaseCopy, fileSize(workFile))
+	snapshot, sum, err := snapshotFile(ctx, workFile, root)
+	if err != nil {
+		return BackupResult{}, err
 	}
+	defer os.Remove(snapshot)
+	res.SHA256 = sum
+	gm := a.generationManager(root)
+	gm.Policy = a.GetRotationPolicy(workFile)
 
-	baseName := filepath.Base(workFile)
-	baseFull := filepath.Join(targetDir, baseName+".base")
+	// 中止されたときは、ここで作った世代 (まだ差分の無い .base だけのもの) を残さない
+	var created []string
+	defer func() {
+		if ctx.Err() != nil && res.Path == "" {
+			for _, dir := range created {
+				os.RemoveAll(dir)
+			}
+		}
+	}()
+	newGeneration := func() (*BackupGenInfo, error) {
+		g, err := gm.NextGeneration(workFile)
+		if err == nil {
+			created = append(created, g.DirPath)
+		}
+		return g, err
+	}
 
-	// --- 2. .baseファイル自体の存在チェックと自己修復 ---
-	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
-		if err := CopyFile(workFile, baseFull); err != nil {
-			return fmt.Errorf("failed to sync base file: %w", err)
+	if gen == nil {
+		// 指定がなければ（親フォルダなら）最新を探索 (無ければ base1 を作成)
+		if gen, err = gm.GetLatestGeneration(); err == nil && gen == nil {
+			gen, err = newGeneration()
+		}
+		if err != nil {
+			return BackupResult{}, err
 		}
 	}
 
+	// --- 2. 世代の検証 ---
+	// .base が無い・作成時と違う世代に差分を足すと復元できなくなるので、新しい世代を作る
+	// 差分数・経過日数のルールで交代が決まっている場合も、差分を作る前に新しい世代にする
+	rotate := gm.ValidateGeneration(gen.DirPath, workFile) != nil
+	if !rotate {
+		reason, err := gm.RotationReason(gen.DirPath, workFile, "")
+		if err != nil {
+			return BackupResult{}, err
+		}
+		rotate = reason != ""
+	}
+	if rotate {
+		if gen, err = newGeneration(); err != nil {
+			return BackupResult{}, err
+		}
+	}
+
+	baseName := filepath.Base(workFile)
+	baseFull := gm.BasePath(gen.DirPath, workFile)
+
 	ts := time.Now().Format("20060102_150405")
-	tempDiff := filepath.Join(os.TempDir(), fmt.Sprintf("%s.%s.tmp", baseName, ts))
+	diffName := fmt.Sprintf("%s.%s.%s.diff", baseName, ts, algo)
+	// 作業用の差分は世代フォルダ内の一時ファイルに作る (同じフォルダなので rename で確定できる)
+	tempDiff := filepath.Join(gen.DirPath, atomicTempPrefix+diffName+".tmp")
 	
 	// 差分生成
-	if algo == "bsdiff" {
-		err = a.CreateBsdiff(baseFull, workFile, tempDiff)
-	} else {
-		err = a.CreateHdiff(baseFull, workFile, tempDiff)
-	}
-	if err != nil {
-		os.Remove(tempDiff)
-		return err
+	if err := a.createCheckedDiff(ctx, engine, baseFull, snapshot, res.SHA256, tempDiff); err != nil {
+		return BackupResult{}, err
 	}
 
 	// --- 3. サイズ・閾値判定 ---
-	workStat, _ := os.Stat(workFile)
-	diffStat, _ := os.Stat(tempDiff)
-	threshold := a.GetAutoBaseGenerationThreshold()
-	workSize := workStat.Size()
-	diffSize := diffStat.Size()
-
-	if threshold <= 0 { threshold = 0.8 }
-	
-	shouldNextGen := false
-	if workSize > 100*1024 { // 100KBより大きい場合のみ
-		if float64(diffSize) > float64(workSize)*threshold {
-			shouldNextGen = true
-		}
+	rotate, err = gm.ShouldRotate(gen.DirPath, workFile, tempDiff)
+	if err != nil {
+		os.Remove(tempDiff)
+		return BackupResult{}, err
 	}
-	
-	if shouldNextGen {
+	if rotate {
 		// --- 4a. 【サイズ超過】 世代交代ロジック ---
 		os.Remove(tempDiff)
-		newIdx := currentIdx + 1
-		newGenDir, err := a.CreateNewGeneration(root, newIdx, workFile)
+		newGen, err := newGeneration()
 		if err != nil {
-			return err
+			return BackupResult{}, err
 		}
 
-		newBaseFull := filepath.Join(newGenDir, baseName+".base")
-		finalPath := filepath.Join(newGenDir, fmt.Sprintf("%s.%s.%s.diff", baseName, ts, algo))
+		newBaseFull := gm.BasePath(newGen.DirPath, workFile)
+		tempDiff = filepath.Join(newGen.DirPath, atomicTempPrefix+diffName+".tmp")
 		
-		if algo == "bsdiff" {
-			return a.CreateBsdiff(newBaseFull, workFile, finalPath)
+		if err := a.createCheckedDiff(ctx, engine, newBaseFull, snapshot, res.SHA256, tempDiff); err != nil {
+			return BackupResult{}, err
 		}
-		return a.CreateHdiff(newBaseFull, workFile, finalPath)
+		gen, baseFull = newGen, newBaseFull
+	}
+
+	// --- 4. 移動して確定 ---
+	// (作業用の差分は同じ世代フォルダにあるので、バックアップ先が別のドライブや共有フォルダでも rename で済む)
+	finalPath := filepath.Join(gen.DirPath, diffName)
+	if err := os.Rename(tempDiff, finalPath); err != nil {
+		os.Remove(tempDiff)
+		return BackupResult{}, newAppError(ErrWriteFailed, finalPath, err)
 	}
+	syncDir(gen.DirPath)
+	res.Path = finalPath
+	return res, a.recordChecksum(finalPath, checksumKindDiff, workFile, res.SHA256, baseFull)
+}
 
-	// --- 4b. 【正常】 移動して確定 ---
-	finalPath := filepath.Join(targetDir, fmt.Sprintf("%s.%s.%s.diff", baseName, ts, algo))
-	return os.Rename(tempDiff, finalPath)
+// createCheckedDiff は baseFull から newFile (内容の SHA-256 は newSum) への差分を tempDiff に作り、試験復元で確かめます。
+// 失敗したときや中止されたときは tempDiff を消します
+func (a *App) createCheckedDiff(ctx context.Context, engine DiffAlgorithm, baseFull, newFile, newSum, tempDiff string) error {
+	setJobPhase(ctx, phaseDiff, 0)
+	err := engine.Create(baseFull, newFile, tempDiff)
+	if err == nil {
+		// 差分の作成そのものは途中で止められないので、終わってから確かめる
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = a.checkDiffRoundTrip(ctx, engine, baseFull, tempDiff, newSum)
+	}
+	if err != nil {
+		os.Remove(tempDiff)
+	}
+	return err
+}
+
+// checkDiffRoundTrip は設定 (verifyDiffAfterWrite) が有効なとき、作成した差分を一時ファイルへ試験復元し、
+// 差分を作った内容 (SHA-256 が wantSum) に戻るかを確かめます。戻らない差分は確定させずにエラーにします
+func (a *App) checkDiffRoundTrip(ctx context.Context, engine DiffAlgorithm, baseFull, diffFile, wantSum string) error {
+	if !a.GetVerifyDiffAfterWrite() {
+		return nil
+	}
+	return roundTripDiff(ctx, engine, baseFull, diffFile, wantSum)
 }
 
+// roundTripDiff は diffFile を baseFull に適用した結果の SHA-256 が wantSum になるかを確かめます
+func roundTripDiff(ctx context.Context, engine DiffAlgorithm, baseFull, diffFile, wantSum string) error {
+	tmp, err := os.CreateTemp("", "cg-file-backup-roundtrip-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+
+	setJobPhase(ctx, phaseVerify, 0)
+	if err := engine.Apply(baseFull, diffFile, tmpPath); err != nil {
+		return newAppError(ErrRoundTripFailed, engine.Name(), err)
+	}
+	setJobPhase(ctx, phaseVerify, fileSize(tmpPath))
+	got, _, err := hashFileContext(ctx, tmpPath)
+	if err != nil {
+		return err
+	}
+	if got != wantSum {
+		return newAppError(ErrRoundTripFailed, engine.Name(), nil)
+	}
+	return nil
+}
 
-// ApplyMultiDiff は新旧混在・アルゴリズム不明でも自動判別＆リトライで適用します
+// ApplyMultiDiff は新旧混在・アルゴリズム不明でもファイル先頭から形式を判別して適用します
 func (a *App) ApplyMultiDiff(workFile string, diffPaths []string, _ string) error {
 	for _, dp := range diffPaths {
-		var err error
-		baseName := filepath.Base(dp)
+		outPath := autoOutputPath(workFile)
+		if err := a.applyDiffTo(workFile, dp, outPath); err != nil {
+			return err
+		}
+		if err := a.verifyRestored(context.Background(), dp, outPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyDiffTo は1つの差分ファイルを outPath へ復元します (ApplyMultiDiff / CLI 共通)
+func (a *App) applyDiffTo(workFile, dp, outPath string) error {
+	baseName := filepath.Base(dp)
 
-		// 1. ファイル名による明示的な判別
-		if strings.Contains(baseName, ".bsdiff.") {
-			err = a.ApplyBsdiff(workFile, dp)
-		} else if strings.Contains(baseName, ".hdiff.") {
-			err = a.ApplyHdiffWrapper(workFile, dp)
-		} else {
-			// 2. 識別子がない古い ".diff" ファイルの場合のリトライ戦略
-			// まずは以前の主流だった Bsdiff で試行
-			err = a.ApplyBsdiff(workFile, dp)
-			if err != nil {
-				// Bsdiff で失敗した場合、Hdiff としてリトライ
-				fmt.Printf("Bsdiff failed for %s, retrying with Hdiff...\n", baseName)
-				err = a.ApplyHdiffWrapper(workFile, dp)
-			}
+	// ファイル名ではなく先頭のマジックで形式を判別する
+	// (識別子がない古い ".diff" ファイルや、名前が変えられたファイルも扱える)
+	engine, err := a.detectDiffAlgorithm(dp)
+	if err == nil {
+		var baseFull string
+		baseFull, err = a.resolveBaseFile(workFile, dp)
+		if err == nil {
+			err = engine.Apply(baseFull, dp, outPath)
 		}
+	}
 
-		if err != nil {
-			return fmt.Errorf("復元失敗。正しい差分ファイルではないか、ベースファイルが一致しません (%s): %w", baseName, err)
+	if err != nil {
+		// .base が無い・形式が分からないなど、原因の分かっているものはそのまま返す
+		if errorCode(err) != "" {
+			return err
 		}
+		return newAppError(ErrPatchCorrupt, baseName, err)
 	}
 	return nil
 }
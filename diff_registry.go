@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cg-file-backup/libs/hdiffpatch"
+	"cg-file-backup/libs/vcdiff"
+	"cg-file-backup/libs/zstdpatch"
+	"github.com/kr/binarydist"
+)
+
+// ----------------- 差分アルゴリズムのレジストリ -----------------
+
+// DiffAlgorithm は差分エンジン 1 つ分の実装です。
+// diffAlgorithms に登録すると、バックアップ (BackupOrDiff) と復元 (ApplyMultiDiff / RestoreBackup) の両方から使えます
+type DiffAlgorithm interface {
+	// Name は algo 引数とファイル名 (file.<ts>.<name>.diff) に使う識別子です
+	Name() string
+	// Create は oldFile から newFile への差分を diffFile に書き出します
+	Create(oldFile, newFile, diffFile string) error
+	// Apply は baseFile に diffFile を適用して outPath に書き出します
+	Apply(baseFile, diffFile, outPath string) error
+	// Detect は差分ファイルの先頭 (diffHeadSize バイトまで) が自分の形式かを判定します
+	Detect(head []byte) bool
+}
+
+// diffHeadSize は Detect に渡すファイル先頭のバイト数です
+const diffHeadSize = 64
+
+// diffAlgorithms は利用できる差分エンジンの一覧です。新しいエンジンはここに追加します
+// (先頭が既定のアルゴリズム)
+func (a *App) diffAlgorithms() []DiffAlgorithm {
+	return []DiffAlgorithm{
+		hdiffAlgorithm{a},
+		bsdiffAlgorithm{a},
+		vcdiffAlgorithm{a},
+		zstdAlgorithm{a},
+	}
+}
+
+// GetDiffAlgorithms は利用できる差分アルゴリズム名の一覧を返します
+func (a *App) GetDiffAlgorithms() []string {
+	var names []string
+	for _, algo := range a.diffAlgorithms() {
+		names = append(names, algo.Name())
+	}
+	return names
+}
+
+// diffAlgorithm は名前からアルゴリズムを探します (空なら既定のもの)
+func (a *App) diffAlgorithm(name string) (DiffAlgorithm, error) {
+	algos := a.diffAlgorithms()
+	if name == "" {
+		return algos[0], nil
+	}
+	for _, algo := range algos {
+		if algo.Name() == name {
+			return algo, nil
+		}
+	}
+	return nil, newAppError(ErrUnsupportedAlgorithm, name, nil)
+}
+
+// detectDiffAlgorithm は差分ファイルの先頭を読み、形式に合うアルゴリズムを返します
+func (a *App) detectDiffAlgorithm(diffFile string) (DiffAlgorithm, error) {
+	f, err := os.Open(diffFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, diffHeadSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	for _, algo := range a.diffAlgorithms() {
+		if algo.Detect(head[:n]) {
+			return algo, nil
+		}
+	}
+	return nil, newAppError(ErrPatchCorrupt, filepath.Base(diffFile), fmt.Errorf("差分の形式を判別できません"))
+}
+
+// resolveBaseFile は差分ファイル名から対応する .base を探します
+func (a *App) resolveBaseFile(workFile, diffFile string) (string, error) {
+	backupDir := filepath.Dir(diffFile)
+	gm := a.generationManager(filepath.Dir(backupDir))
+	parts := strings.Split(filepath.Base(diffFile), ".")
+
+	var guessedBaseName string
+	if len(parts) >= 4 && a.isDiffAlgorithmName(parts[len(parts)-2]) {
+		// 新仕様: filename.20260101_120000.<algo>.diff
+		// 後ろから3つ (.timestamp.algo.diff) を除いたものが元のファイル名
+		guessedBaseName = strings.Join(parts[:len(parts)-3], ".") + ".base"
+	} else {
+		// 旧仕様: filename.20240101_120000.diff
+		// ".20" (日付の始まり) で分割してベース名を取得
+		guessedBaseName = strings.Split(filepath.Base(diffFile), ".20")[0] + ".base"
+	}
+
+	baseFull := filepath.Join(backupDir, guessedBaseName)
+	// 推測したベースが見つからない場合、現在開いているファイル名.base を最終確認
+	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
+		baseFull = gm.BasePath(backupDir, workFile)
+	}
+	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
+		return "", newAppError(ErrBaseMissing, guessedBaseName, nil)
+	}
+	return baseFull, nil
+}
+
+func (a *App) isDiffAlgorithmName(name string) bool {
+	for _, algo := range a.diffAlgorithms() {
+		if algo.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// --- 組み込みのアルゴリズム ---
+
+// hdiffAlgorithm は HDiffPatch 形式 (hdiffz 互換) です
+type hdiffAlgorithm struct{ a *App }
+
+func (h hdiffAlgorithm) Name() string { return "hdiff" }
+func (h hdiffAlgorithm) Create(oldFile, newFile, diffFile string) error {
+	return h.a.CreateHdiff(oldFile, newFile, diffFile)
+}
+func (h hdiffAlgorithm) Apply(baseFile, diffFile, outPath string) error {
+	return h.a.ApplyHdiff(baseFile, diffFile, outPath)
+}
+func (h hdiffAlgorithm) Detect(head []byte) bool { return hdiffpatch.IsDiff(head) }
+
+// bsdiffAlgorithm は BSDIFF40 とマルチブロック形式 (BSDIFFMB) です
+type bsdiffAlgorithm struct{ a *App }
+
+func (b bsdiffAlgorithm) Name() string { return "bsdiff" }
+func (b bsdiffAlgorithm) Create(oldFile, newFile, diffFile string) error {
+	return b.a.CreateBsdiff(oldFile, newFile, diffFile)
+}
+func (b bsdiffAlgorithm) Apply(baseFile, diffFile, outPath string) error {
+	return b.a.patchBsdiff(baseFile, diffFile, outPath)
+}
+func (b bsdiffAlgorithm) Detect(head []byte) bool { return binarydist.IsPatch(head) }
+
+// vcdiffAlgorithm は VCDIFF (RFC 3284) 形式です。xdelta3 と差分をやり取りできます
+type vcdiffAlgorithm struct{ a *App }
+
+func (v vcdiffAlgorithm) Name() string { return "vcdiff" }
+func (v vcdiffAlgorithm) Create(oldFile, newFile, diffFile string) error {
+	return v.a.CreateVcdiff(oldFile, newFile, diffFile)
+}
+func (v vcdiffAlgorithm) Apply(baseFile, diffFile, outPath string) error {
+	return v.a.ApplyVcdiff(baseFile, diffFile, outPath)
+}
+func (v vcdiffAlgorithm) Detect(head []byte) bool { return vcdiff.IsDelta(head) }
+
+// zstdAlgorithm は .base を辞書にした zstd フレーム (zstd --patch-from 互換) です
+type zstdAlgorithm struct{ a *App }
+
+func (z zstdAlgorithm) Name() string { return "zstd" }
+func (z zstdAlgorithm) Create(oldFile, newFile, diffFile string) error {
+	return z.a.CreateZstdPatch(oldFile, newFile, diffFile)
+}
+func (z zstdAlgorithm) Apply(baseFile, diffFile, outPath string) error {
+	return z.a.ApplyZstdPatch(baseFile, diffFile, outPath)
+}
+func (z zstdAlgorithm) Detect(head []byte) bool { return zstdpatch.IsPatch(head) }
@@ -97,14 +97,7 @@ def create_release_package():
     else:
         print("Warning: Build directory not found.")
 
-    # 5. 外部依存ディレクトリのコピー
-    required_bins = ["hdiff-bin", "bzip2-bin"]
-    for bin_dir in required_bins:
-        if os.path.exists(bin_dir):
-            print(f"Copying external dependency: {bin_dir}")
-            shutil.copytree(bin_dir, os.path.join(dist_dir, bin_dir))
-
-    # 5.5 サードパーティライセンスディレクトリ (licenses/) のコピー
+    # 5. サードパーティライセンスディレクトリ (licenses/) のコピー
     # go-licenses save ./cmd/... --save_path=licenses で生成されたものを想定
     third_party_licenses_dir = "licenses"
     if os.path.exists(third_party_licenses_dir):
@@ -185,7 +178,7 @@ Maintainer: Developer <dev@example.com>
 Depends: libwebkit2gtk-4.0-37, libgtk-3-0
 Description: {project_name} backup tool
  A file backup utility using Wails.
- Includes hdiff, bzip2 and third-party licenses.
+ Includes third-party licenses.
 """
             with open(os.path.join(deb_root, "DEBIAN/control"), "w") as f:
                 f.write(control_content)
@@ -0,0 +1,105 @@
+package main
+
+import "errors"
+
+// ----------------- エラーの種類 (コード・翻訳キー) -----------------
+//
+// 利用者が対処できる失敗は AppError として返します。種類は errors.Is(err, ErrBaseMissing) のように判定でき、
+// GUI には formatError で {code, key, file, message, detail} のオブジェクトとして、
+// CLI には JSON の errorCode と終了コード (cliExitCodes) として伝わります。
+
+// ErrorCode はエラーの種類を表す固定の文字列です (フロントエンド・CLI の出力に使うので変えないこと)
+type ErrorCode string
+
+// AppError は種類の分かるエラーです
+type AppError struct {
+	Code   ErrorCode
+	Key    string // i18n のキー (フロントエンドが {file} を置き換えて表示する)
+	Msg    string // 既定のメッセージ (CLI・ログ用)
+	Target string // 対象のファイル名など (無ければ空)
+	Err    error  // 原因 (無ければ nil)
+}
+
+// エラーの種類。返すときは newAppError で対象と原因を付けます
+var (
+	ErrBaseMissing          = &AppError{Code: "BASE_MISSING", Key: "errBaseMissing", Msg: "ベースファイル (.base) が見つかりません"}
+	ErrBaseChanged          = &AppError{Code: "BASE_CHANGED", Key: "errBaseChanged", Msg: "ベースファイル (.base) が作成時と異なります"}
+	ErrPatchCorrupt         = &AppError{Code: "PATCH_CORRUPT", Key: "errPatchCorrupt", Msg: "差分ファイルが壊れているか、ベースファイルと一致しません"}
+	ErrChunkMissing         = &AppError{Code: "CHUNK_MISSING", Key: "errChunkMissing", Msg: "重複排除バックアップのチャンクが見つかりません"}
+	ErrManifestCorrupt      = &AppError{Code: "MANIFEST_CORRUPT", Key: "errManifestCorrupt", Msg: "重複排除バックアップのマニフェストまたはチャンクが壊れています"}
+	ErrChecksumMismatch     = &AppError{Code: "CHECKSUM_MISMATCH", Key: "errChecksumMismatch", Msg: "復元結果がバックアップ時のファイルと一致しないため削除しました"}
+	ErrRoundTripFailed      = &AppError{Code: "ROUNDTRIP_FAILED", Key: "errRoundTripFailed", Msg: "作成した差分を試験復元すると作業ファイルに戻らないため、保存を中止しました"}
+	ErrUnsupportedArchive   = &AppError{Code: "UNSUPPORTED_ARCHIVE", Key: "errUnsupportedArchive", Msg: "未対応または中身の無いアーカイブです"}
+	ErrUnsupportedAlgorithm = &AppError{Code: "UNSUPPORTED_ALGORITHM", Key: "errUnsupportedAlgorithm", Msg: "未対応の差分アルゴリズムです"}
+	ErrExternalToolMissing  = &AppError{Code: "EXTERNAL_TOOL_MISSING", Key: "errExternalToolMissing", Msg: "必要な外部ツールが見つかりません"}
+	ErrNotGenerationDir     = &AppError{Code: "NOT_GENERATION_DIR", Key: "errNotGenerationDir", Msg: "世代フォルダではありません"}
+	ErrNotDiffFile          = &AppError{Code: "NOT_DIFF_FILE", Key: "errNotDiffFile", Msg: "この世代の差分ファイルではありません"}
+	ErrInvalidPolicy        = &AppError{Code: "INVALID_POLICY", Key: "errInvalidPolicy", Msg: "ルールに負の値は指定できません"}
+	ErrPolicyNotSet         = &AppError{Code: "POLICY_NOT_SET", Key: "errPolicyNotSet", Msg: "保持ルールが設定されていません"}
+	ErrInvalidSchedule      = &AppError{Code: "INVALID_SCHEDULE", Key: "errInvalidSchedule", Msg: "スケジュールの設定が正しくありません"}
+	ErrInvalidPath          = &AppError{Code: "INVALID_PATH", Key: "errInvalidPath", Msg: "ファイルのパスが正しくありません"}
+	ErrRootLocked           = &AppError{Code: "ROOT_LOCKED", Key: "errRootLocked", Msg: "別のアプリがこのバックアップフォルダを使用中です"}
+	ErrUnsupportedMode      = &AppError{Code: "UNSUPPORTED_MODE", Key: "errUnsupportedMode", Msg: "未対応のバックアップの種類です"}
+	ErrNotDirectory         = &AppError{Code: "NOT_DIRECTORY", Key: "errNotDirectory", Msg: "フォルダではありません"}
+	ErrJobNotFound          = &AppError{Code: "JOB_NOT_FOUND", Key: "errJobNotFound", Msg: "ジョブが見つかりません"}
+	ErrChecksumCorrupt      = &AppError{Code: "CHECKSUM_CORRUPT", Key: "errChecksumCorrupt", Msg: "checksum.json を読み込めません"}
+	ErrWriteFailed          = &AppError{Code: "WRITE_FAILED", Key: "errWriteFailed", Msg: "ファイルを書き込めません"}
+	ErrPruneFailed          = &AppError{Code: "PRUNE_FAILED", Key: "errPruneFailed", Msg: "古いバックアップの削除に失敗しました"}
+	ErrCompactFailed        = &AppError{Code: "COMPACT_FAILED", Key: "errCompactFailed", Msg: "世代の圧縮に失敗しました (元の世代はそのままです)"}
+	ErrCompactSwapFailed    = &AppError{Code: "COMPACT_SWAP_FAILED", Key: "errCompactSwapFailed", Msg: "世代の入れ替えに失敗しました。元の世代は退避先に残っています"}
+	ErrCompactRecoverFailed = &AppError{Code: "COMPACT_RECOVER_FAILED", Key: "errCompactRecoverFailed", Msg: "中断された世代の圧縮を元に戻せません"}
+)
+
+// newAppError は kind の種類で、対象 target と原因 cause を持つエラーを作ります
+func newAppError(kind *AppError, target string, cause error) *AppError {
+	e := *kind
+	e.Target, e.Err = target, cause
+	return &e
+}
+
+func (e *AppError) Error() string {
+	msg := e.Msg
+	if e.Target != "" {
+		msg += ": " + e.Target
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *AppError) Unwrap() error { return e.Err }
+
+// Is は種類 (Code) が同じなら一致とみなします
+func (e *AppError) Is(target error) bool {
+	t, ok := target.(*AppError)
+	return ok && t.Code == e.Code
+}
+
+// errorCode は err の種類を返します (AppError でなければ空)
+func errorCode(err error) ErrorCode {
+	var ae *AppError
+	if errors.As(err, &ae) {
+		return ae.Code
+	}
+	return ""
+}
+
+// formatError は Wails から返すエラーを、フロントエンドが翻訳できる形にします (options.App.ErrorFormatter)。
+// AppError でないものは今までどおり文字列のままです
+func formatError(err error) any {
+	var ae *AppError
+	if !errors.As(err, &ae) {
+		return err.Error()
+	}
+	res := map[string]string{
+		"code":    string(ae.Code),
+		"key":     ae.Key,
+		"file":    ae.Target,
+		"message": err.Error(),
+	}
+	if ae.Err != nil {
+		res["detail"] = ae.Err.Error()
+	}
+	return res
+}
@@ -1,6 +1,6 @@
 package main
 import (
-	"fmt"
+	"context"
 	"os"
 	"io"
 	"strings"
@@ -29,7 +29,8 @@ func (a *App) saveToWorkFile(r io.Reader, targetFile string) error {
 // WriteTextFile は指定されたパスに文字列を書き込みます（汎用）
 func (a *App) WriteTextFile(path string, content string) error {
 	// フォルダが存在しない可能性も考慮する場合はここで作成しても良い
-	return os.WriteFile(path, []byte(content), 0644)
+	// (メモ・セッションが書きかけで残らないよう一時ファイル経由で置き換える)
+	return writeFileViaTemp(path, []byte(content))
 }
 
 // ReadTextFile は指定されたパスのファイルを文字列として読み込みます（汎用）
@@ -106,16 +107,21 @@ func (a *App) GetBackupList(workFile, backupDir string) ([]BackupItem, error) {
 
 	var list []BackupItem
 	baseNameOnly := strings.TrimSuffix(filepath.Base(workFile), filepath.Ext(workFile))
-	validExts := []string{".diff", ".zip", ".tar.gz", ".tar", ".gz"}
+	validExts := []string{".diff", ".zip", ".tar.gz", ".tar", ".gz", dedupManifestExt}
 
 	// --- 1. ルート直下のアーカイブをスキャン ---
-	rootFiles, _ := os.ReadDir(root)
+	rootFiles, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
 	for _, f := range rootFiles {
-		if f.IsDir() { continue }
+		if f.IsDir() || !isBackupArtifactName(f.Name()) { continue }
 		name := f.Name()
 		if !strings.Contains(name, baseNameOnly) { continue }
 		if a.isValidBackupExt(name, validExts) {
-			info, _ := f.Info()
+			// 一覧を作る間に消されたファイルは飛ばす
+			info, err := f.Info()
+			if err != nil { continue }
 			list = append(list, BackupItem{
 				FileName:     name,
 				FilePath:     filepath.Join(root, name),
@@ -126,28 +132,32 @@ func (a *App) GetBackupList(workFile, backupDir string) ([]BackupItem, error) {
 		}
 	}
 
-	// --- 2. すべての世代フォルダ(base*)をスキャン ---
-	dirs, _ := os.ReadDir(root)
-	for _, d := range dirs {
-		if !d.IsDir() || !strings.HasPrefix(d.Name(), "base") {
-			continue
-		}
-
-		// フォルダ名から数値を取得 (base1 -> 1)
-		genIdx := 0
-		fmt.Sscanf(d.Name(), "base%d", &genIdx)
-		
-		genDir := filepath.Join(root, d.Name())
+	// --- 2. すべての世代フォルダ(baseN_*)をスキャン ---
+	if err := a.prepareBackupRootIfIdle(root); err != nil {
+		return nil, err
+	}
+	gens, err := a.generationManager(root).ListGenerations()
+	if err != nil {
+		return nil, err
+	}
+	for _, gen := range gens {
+		genIdx := gen.BaseIdx
+		genDir := gen.DirPath
 		// フォルダ内のファイルをリストに追加
-		genFiles, _ := os.ReadDir(genDir)
+		genFiles, err := os.ReadDir(genDir)
+		if err != nil {
+			return nil, err
+		}
 		for _, f := range genFiles {
-			if f.IsDir() || filepath.Ext(f.Name()) == ".base" || f.Name() == "checksum.json" {
+			// 書き込み途中の一時ファイル・checksum.json・メモは一覧に出さない
+			if f.IsDir() || filepath.Ext(f.Name()) == ".base" || !isBackupArtifactName(f.Name()) {
 				continue
 			}
 			name := f.Name()
 			if !strings.Contains(name, baseNameOnly) { continue }
 			if a.isValidBackupExt(name, validExts) {
-				info, _ := f.Info()
+				info, err := f.Info()
+				if err != nil { continue }
 				list = append(list, BackupItem{
 					FileName:          name,
 					FilePath:          filepath.Join(genDir, name),
@@ -172,80 +182,134 @@ func (a *App) isValidBackupExt(name string, exts []string) bool {
 
 
 
-// CopyBackupFile はファイルをそのままコピーします
-func (a *App) CopyBackupFile(src, backupDir string) error {
+// backupModes は runBackup (CLI・スケジュール) で使えるバックアップの種類です
+var backupModes = []string{"diff", "copy", "zip", "tar", "dedup"}
+
+// runBackup は mode の種類でバックアップを 1 つ作成します (algo は diff、password は zip のときだけ使います)
+func (a *App) runBackup(ctx context.Context, workFile, backupDir, mode, algo, password string) (BackupResult, error) {
+	switch mode {
+	case "diff":
+		return a.backupOrDiff(ctx, workFile, backupDir, algo)
+	case "copy":
+		return a.copyBackup(ctx, workFile, backupDir)
+	case "zip", "tar":
+		return a.archiveBackup(ctx, workFile, backupDir, mode, password)
+	case "dedup":
+		return a.dedupBackup(ctx, workFile, backupDir)
+	}
+	return BackupResult{}, newAppError(ErrUnsupportedMode, mode, nil)
+}
+
+// CopyBackupFile はファイルをそのままコピーします (最新のバックアップから変わっていなければ何もしません)
+func (a *App) CopyBackupFile(src, backupDir string) (BackupResult, error) {
+	return a.copyBackup(context.Background(), src, backupDir)
+}
+
+// copyBackup は CopyBackupFile の本体です (ジョブから中止できます)
+func (a *App) copyBackup(ctx context.Context, src, backupDir string) (BackupResult, error) {
 	if backupDir == "" {
 		backupDir = DefaultBackupDir(src)
 	}
+	unlock, err := a.lockRoot(ctx, backupRootOf(src, backupDir))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer unlock()
+	res, err := a.checkUnchanged(ctx, src, backupDir)
+	if err != nil || res.Unchanged {
+		return res, err
+	}
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
-		return err
+		return res, err
+	}
+	setJobPhase(ctx, phaseCopy, fileSize(src))
+	path := filepath.Join(backupDir, TimestampedName(src))
+	if err := copyFileContext(ctx, src, path); err != nil {
+		return res, err
 	}
-	return CopyFile(src, filepath.Join(backupDir, TimestampedName(src)))
+	res.Path = path
+	return res, a.recordChecksum(res.Path, checksumKindCopy, src, res.SHA256, "")
 }
 
-// ArchiveBackupFile は指定された形式で圧縮バックアップを作成します
-func (a *App) ArchiveBackupFile(src, backupDir, format, password string) error {
+// ArchiveBackupFile は指定された形式で圧縮バックアップを作成します (最新のバックアップから変わっていなければ何もしません)
+func (a *App) ArchiveBackupFile(src, backupDir, format, password string) (BackupResult, error) {
+	return a.archiveBackup(context.Background(), src, backupDir, format, password)
+}
+
+// archiveBackup は ArchiveBackupFile の本体です (ジョブから中止できます)
+func (a *App) archiveBackup(ctx context.Context, src, backupDir, format, password string) (BackupResult, error) {
 	if backupDir == "" {
 		backupDir = DefaultBackupDir(src)
 	}
+	unlock, err := a.lockRoot(ctx, backupRootOf(src, backupDir))
+	if err != nil {
+		return BackupResult{}, err
+	}
+	defer unlock()
+	res, err := a.checkUnchanged(ctx, src, backupDir)
+	if err != nil || res.Unchanged {
+		return res, err
+	}
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
-		return err
+		return res, err
 	}
 
+	setJobPhase(ctx, phaseCompress, fileSize(src))
+	var archivePath string
 	if format == "zip" {
-		return ZipBackupFile(src, backupDir, password)
+		archivePath = filepath.Join(backupDir, TimestampedName(strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".zip"))
+		err = ZipBackupFile(ctx, src, archivePath, password)
+	} else {
+		// Tarはパスワード非対応
+		archivePath = filepath.Join(backupDir, TimestampedName(strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".tar.gz"))
+		err = TarBackupFile(ctx, src, archivePath)
 	}
-	// Tarはパスワード非対応
-	return TarBackupFile(src, backupDir)
-}
-
-// ZipBackupFile はパスワードの有無によりライブラリを使い分けてZIPを作成します
-func ZipBackupFile(src, backupDir, password string) error {
-	zipPath := filepath.Join(backupDir, TimestampedName(strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".zip"))
-
-	zf, err := os.Create(zipPath)
 	if err != nil {
-		return err
+		return res, err
 	}
-	defer zf.Close()
+	res.Path = archivePath
+	return res, a.recordChecksum(archivePath, checksumKindArchive, src, res.SHA256, "")
+}
 
+// ZipBackupFile はパスワードの有無によりライブラリを使い分けて zipPath にZIPを作成します
+func ZipBackupFile(ctx context.Context, src, zipPath, password string) error {
 	f, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-
-	if password != "" {
-		// --- パスワードあり (alexmullins/zip を使用) ---
-		archive := pwzip.NewWriter(zf)
-		defer archive.Close()
-
-		// ライブラリのサンプルに従い、Encrypt で直接 Writer を作成
-		// 引数は (ファイル名, パスワード) の2つのみ
-		writer, err := archive.Encrypt(filepath.Base(src), password)
-		if err != nil {
-			return err
+	in := newProgressReader(ctx, f)
+
+	return writeFileAtomic(zipPath, func(zf *os.File) error {
+		if password != "" {
+			// --- パスワードあり (alexmullins/zip を使用) ---
+			archive := pwzip.NewWriter(zf)
+
+			// ライブラリのサンプルに従い、Encrypt で直接 Writer を作成
+			// 引数は (ファイル名, パスワード) の2つのみ
+			writer, err := archive.Encrypt(filepath.Base(src), password)
+			if err != nil {
+				archive.Close()
+				return err
+			}
+			if _, err := io.Copy(writer, in); err != nil {
+				archive.Close()
+				return err
+			}
+			return archive.Close() // 中央ディレクトリまで書き終えてから確定させる
 		}
 
-		_, err = io.Copy(writer, f)
-		if err != nil {
-			return err
-		}
-
-		return archive.Flush() // 書き込みを確定させるために Flush を呼ぶ
-
-	} else {
 		// --- パスワードなし (標準 archive/zip を使用) ---
 		archive := zip.NewWriter(zf)
-		defer archive.Close()
 
 		info, err := f.Stat()
 		if err != nil {
+			archive.Close()
 			return err
 		}
-
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
+			archive.Close()
 			return err
 		}
 		header.Name = filepath.Base(src)
@@ -253,28 +317,19 @@ func ZipBackupFile(src, backupDir, password string) error {
 
 		writer, err := archive.CreateHeader(header)
 		if err != nil {
+			archive.Close()
 			return err
 		}
-
-		_, err = io.Copy(writer, f)
-		return err
-	}
+		if _, err := io.Copy(writer, in); err != nil {
+			archive.Close()
+			return err
+		}
+		return archive.Close()
+	})
 }
 
-// TarBackupFile は .tar.gz 形式で圧縮します
-func TarBackupFile(src, backupDir string) error {
-	tarPath := filepath.Join(backupDir, TimestampedName(strings.TrimSuffix(filepath.Base(src), filepath.Ext(src))+".tar.gz"))
-	tf, err := os.Create(tarPath)
-	if err != nil {
-		return err
-	}
-	defer tf.Close()
-
-	gw := gzip.NewWriter(tf)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-
+// TarBackupFile は tarPath に .tar.gz 形式で圧縮します
+func TarBackupFile(ctx context.Context, src, tarPath string) error {
 	f, err := os.Open(src)
 	if err != nil {
 		return err
@@ -291,17 +346,32 @@ func TarBackupFile(src, backupDir string) error {
 	}
 	header.Name = filepath.Base(src)
 
-	if err := tw.WriteHeader(header); err != nil {
-		return err
-	}
-	_, err = io.Copy(tw, f)
-	return err
+	return writeFileAtomic(tarPath, func(tf *os.File) error {
+		gw := gzip.NewWriter(tf)
+		tw := tar.NewWriter(gw)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, newProgressReader(ctx, f)); err != nil {
+			return err
+		}
+		// tar の終端と gzip のフッタまで書き終えてから確定させる
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	})
 }
 
 
 
-// CopyFile は単純なファイルコピーを行います
+// CopyFile は単純なファイルコピーを行います (一時ファイル経由で dst を置き換えます)
 func CopyFile(src, dst string) error {
+	return copyFileContext(context.Background(), src, dst)
+}
+
+// copyFileContext は CopyFile と同じですが、読んだ量をジョブの進捗に足し、中止されたら dst に触れずに止めます
+func copyFileContext(ctx context.Context, src, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
 	}
@@ -310,18 +380,12 @@ func CopyFile(src, dst string) error {
 		return err
 	}
 	defer in.Close()
-	out, err := os.Create(dst)
-	if err != nil {
+	return writeFileAtomic(dst, func(out *os.File) error {
+		_, err := io.Copy(out, newProgressReader(ctx, in))
 		return err
-	}
-	defer out.Close()
-	if _, err := io.Copy(out, in); err != nil {
-		return err
-	}
-	return out.Sync()
+	})
 }
 
-
 // DirExists は指定されたパスがディレクトリとして存在するか確認します
 func (a *App) DirExists(path string) bool {
 	info, err := os.Stat(path)
@@ -25,6 +25,7 @@ import {
 } from './ui';
 
 import { setupGlobalEvents } from './events';
+import { syncWatches } from './actions';
 
 // --- 初期化ロジック ---
 async function Initialize() {
@@ -35,6 +36,8 @@ async function Initialize() {
   setI18N(data);
   
   await restoreSession();
+  // 前回監視していたタブの自動バックアップを再開する
+  syncWatches();
 
   const setText = (id, text) => { const el = document.getElementById(id); if (el) el.textContent = text || ""; };
   const setQueryText = (sel, text) => { const el = document.querySelector(sel); if (el) el.textContent = text || ""; };
@@ -51,8 +54,12 @@ async function Initialize() {
     titles[1].textContent = i18n.archiveTitle; descs[1].textContent = i18n.archiveDesc;
     titles[2].textContent = i18n.diffTitle; descs[2].textContent = i18n.diffDesc;
   }
+  if (titles.length >= 4) {
+    titles[3].textContent = i18n.dedupTitle; descs[3].textContent = i18n.dedupDesc;
+  }
 
   setText('execute-backup-btn', i18n.executeBtn);
+  setText('cancel-job-btn', i18n.jobCancel);
   setText('refresh-diff-btn', i18n.refreshBtn);
   setText('apply-selected-btn', i18n.applyBtn);
   setText('select-all-btn', i18n.selectAllBtn);
@@ -60,6 +67,27 @@ async function Initialize() {
   setText('drop-set-workfile', i18n.dropSetWorkFile);
   setText('drop-set-backupdir', i18n.dropSetBackupDir);
   setText('drop-cancel', i18n.dropCancel);
+  setText('rotation-panel-title', i18n.rotationTitle);
+  setText('policy-max-diffs-label', i18n.policyMaxDiffs);
+  setText('policy-max-age-label', i18n.policyMaxAge);
+  setText('policy-size-ratio-label', i18n.policySizeRatio);
+  setText('policy-cumulative-label', i18n.policyCumulative);
+  setText('policy-save-btn', i18n.rotationSave);
+  setText('new-generation-btn', i18n.newGenerationBtn);
+  setText('retention-panel-title', i18n.retentionTitle);
+  setText('retention-keep-last-label', i18n.retentionKeepLast);
+  setText('retention-keep-daily-label', i18n.retentionKeepDaily);
+  setText('retention-keep-weekly-label', i18n.retentionKeepWeekly);
+  setText('retention-keep-monthly-label', i18n.retentionKeepMonthly);
+  setText('retention-max-size-label', i18n.retentionMaxSize);
+  setText('retention-save-btn', i18n.retentionSave);
+  setText('prune-btn', i18n.pruneBtn);
+  setText('watch-toggle-label', i18n.watchToggle);
+  setText('schedule-panel-title', i18n.scheduleTitle);
+  setText('schedule-interval-label', i18n.scheduleInterval);
+  setText('schedule-cron-label', i18n.scheduleCron);
+  setText('schedule-mode-label', i18n.scheduleMode);
+  setText('schedule-save-btn', i18n.scheduleSave);
 
   // Compact用テキスト
   setQueryText('.compact-title-text', i18n.compactMode || "Compact");
@@ -71,6 +99,9 @@ async function Initialize() {
     cSel.options[1].text = i18n.archiveTitle;
     cSel.options[2].text = i18n.diffTitle;
   }
+  if (cSel && cSel.options.length >= 4) {
+    cSel.options[3].text = i18n.dedupTitle;
+  }
 
   const workBtn = document.getElementById('workfile-btn');
   const recentSec = document.querySelector('.recent-files-section');
@@ -142,6 +173,7 @@ function setupDragAndDrop() {
         renderTabs(); 
         UpdateDisplay(); 
         UpdateHistory();
+        syncWatches();
         saveCurrentSession();
       }
     })(); 
@@ -1,10 +1,22 @@
 import {
-  CopyBackupFile,
-  ArchiveBackupFile,
-  BackupOrDiff,
-  RestoreBackup,
+  StartBackupJob,
+  StartRestoreJob,
+  CancelJob,
   GetFileSize,
   GetBsdiffMaxFileSize,
+  GetRotationPolicy,
+  SetRotationPolicy,
+  StartNewGeneration,
+  GetRetentionPolicy,
+  SetRetentionPolicy,
+  PreviewPrune,
+  ApplyPrune,
+  CompactGeneration,
+  StartWatch,
+  StopWatch,
+  GetWatchedFiles,
+  GetSchedule,
+  SetSchedule,
   DirExists
 } from '../wailsjs/go/main/App';
 
@@ -13,7 +25,9 @@ import {
   tabs,
   getActiveTab,
   addToRecentFiles,
-  saveCurrentSession
+  saveCurrentSession,
+  formatSize,
+  errorText
 } from './state';
 
 import {
@@ -21,14 +35,20 @@ import {
   UpdateDisplay,
   UpdateHistory,
   toggleProgress,
-  showFloatingMessage,
+  setProgress,
+  setProgressStatus,
+  showFloatingMessage,
+  showFloatingError,
 } from './ui';
 
-let bsdiffLimit = 104857600; // デフォルト100MB (100 * 1024 * 1024)
+let bsdiffLimit = Infinity; // bsdiffMaxFileSize が 0 (既定) のときは無制限
 // --- タブ操作ロジック ---
 export function switchTab(id) {
   tabs.forEach(t => t.active = (t.id === id));
   renderTabs(); UpdateDisplay(); UpdateHistory();
+  loadRotationPolicy();
+  loadRetentionPolicy();
+  loadSchedule();
   saveCurrentSession();
 }
 
@@ -45,6 +65,7 @@ export function removeTab(id) {
   tabs.splice(index, 1);
   if (wasActive) tabs[Math.max(0, index - 1)].active = true;
   renderTabs(); UpdateDisplay(); UpdateHistory();
+  syncWatches();
   saveCurrentSession();
 }
 
@@ -124,10 +145,11 @@ export async function OnExecute() {
   
   try {
     let successText = "";
+    let job = null;
 
     // --- 1. 単純コピーモード ---
     if (mode === 'copy') { 
-      await CopyBackupFile(tab.workFile, tab.backupDir); 
+      job = await runJob(StartBackupJob(tab.workFile, tab.backupDir, "copy", "", "")); 
       successText = i18n.copyBackupSuccess; 
     }
     // --- 2. アーカイブモード ---
@@ -135,7 +157,7 @@ export async function OnExecute() {
       let fmt = document.getElementById('archive-format').value;
       let pwd = (fmt === "zip-pass") ? document.getElementById('archive-password').value : "";
       if (fmt === "zip-pass") fmt = "zip";
-      await ArchiveBackupFile(tab.workFile, tab.backupDir, fmt, pwd);
+      job = await runJob(StartBackupJob(tab.workFile, tab.backupDir, fmt, "", pwd));
       successText = i18n.archiveBackupSuccess.replace('{format}', fmt.toUpperCase());
     } 
     // --- 3. 差分バックアップモード ---
@@ -156,16 +178,25 @@ export async function OnExecute() {
       // 存在するなら選んだパス、なければバックアップディレクトリ（Go側で自動計算）
       const targetPath = tab.selectedTargetDir || tab.backupDir;
       
-      await BackupOrDiff(tab.workFile, targetPath, algo);
+      job = await runJob(StartBackupJob(tab.workFile, targetPath, "diff", algo, ""));
       successText = `${i18n.diffBackupSuccess} (${algo.toUpperCase()})`;
     }
+    // --- 4. 重複排除 (チャンク) モード ---
+    else if (mode === 'dedup') {
+      job = await runJob(StartBackupJob(tab.workFile, tab.backupDir, "dedup", "", ""));
+      successText = i18n.dedupBackupSuccess;
+    }
     
     toggleProgress(false); 
+    // 中止したときは書きかけのファイルが消されているので、何も作られていない
+    if (job?.state === 'cancelled') { showFloatingMessage(i18n.jobCancelled); return; }
+    // 前回のバックアップから変わっていなければ何も作られていない
+    if (job?.result?.unchanged) { showFloatingMessage(i18n.backupUnchanged); return; }
     showFloatingMessage(successText); 
     UpdateHistory(); // 最新の状態に履歴表示を更新
   } catch (err) { 
     toggleProgress(false); 
-    alert(err); 
+    alert(errorText(err)); 
   }
 }
 
@@ -178,12 +209,252 @@ export async function applySelectedBackups() {
     toggleProgress(true, "Restoring...");
     try {
       for (const p of targets) {
-        await RestoreBackup(p, tab.workFile);
+        const job = await runJob(StartRestoreJob(p, tab.workFile));
+        if (job.state === 'cancelled') {
+          toggleProgress(false);
+          showFloatingMessage(i18n.jobCancelled);
+          UpdateHistory();
+          return;
+        }
       }
       toggleProgress(false);
       showFloatingMessage(i18n.diffApplySuccess);
       UpdateHistory();
     }
-    catch (err) { toggleProgress(false); alert(err); }
+    catch (err) { toggleProgress(false); alert(errorText(err)); }
+  }
+}
+
+// --- ジョブ (バックアップ・復元をバックエンドで実行) ---
+// 進み具合と終了は "job-progress" イベントで届く。実行中は中止ボタンを出す
+const jobWaiters = new Map();   // ID → 終了を待っている resolve
+const finishedJobs = new Map(); // 待ち始める前に終わったジョブ
+let currentJobId = "";
+
+// runJob は Start*Job の戻り値 (ジョブ ID) のジョブが終わるのを待ちます。失敗したときは例外にします
+async function runJob(start) {
+  const id = await start;
+  currentJobId = id;
+  const cancelBtn = document.getElementById('cancel-job-btn');
+  if (cancelBtn) cancelBtn.style.display = 'block';
+  try {
+    const job = finishedJobs.get(id) || await new Promise(resolve => jobWaiters.set(id, resolve));
+    finishedJobs.delete(id);
+    if (job.state === 'failed') throw job.error;
+    return job;
+  } finally {
+    currentJobId = "";
+    if (cancelBtn) cancelBtn.style.display = 'none';
+  }
+}
+
+export async function cancelCurrentJob() {
+  if (!currentJobId) return;
+  try { await CancelJob(currentJobId); } catch (err) { console.warn(err); }
+}
+
+export function onJobProgress(job) {
+  if (job.id === currentJobId) {
+    if (job.state === 'queued') {
+      setProgressStatus(i18n.jobQueued);
+    } else if (job.phase) {
+      const label = i18n['jobPhase' + job.phase[0].toUpperCase() + job.phase.slice(1)] || job.phase;
+      setProgressStatus(job.total > 0 ? `${label} ${Math.min(100, Math.floor(job.done / job.total * 100))}%` : label);
+      if (job.total > 0) setProgress(job.done, job.total);
+    }
   }
+  if (!['done', 'failed', 'cancelled'].includes(job.state)) return;
+  const resolve = jobWaiters.get(job.id);
+  if (resolve) { jobWaiters.delete(job.id); resolve(job); }
+  else finishedJobs.set(job.id, job);
 }
+
+// --- 世代交代ルール (作業ファイルごと) ---
+// 0 や空欄は「使わない」(差分サイズ比は設定の既定値) の意味
+export async function loadRotationPolicy() {
+  const panel = document.getElementById('rotation-panel');
+  if (!panel?.open) return;
+  const tab = getActiveTab();
+  const p = tab?.workFile ? await GetRotationPolicy(tab.workFile) : {};
+  const show = (id, v) => { const el = document.getElementById(id); if (el) el.value = v ? String(v) : ""; };
+  show('policy-max-diffs', p.maxDiffs);
+  show('policy-max-age', p.maxAgeDays);
+  show('policy-size-ratio', p.sizeRatio ? Math.round(p.sizeRatio * 100) : 0);
+  show('policy-cumulative', p.cumulativeRatio ? Math.round(p.cumulativeRatio * 100) : 0);
+}
+
+export async function saveRotationPolicy() {
+  const tab = getActiveTab();
+  if (!tab?.workFile) { alert(i18n.selectFileFirst); return; }
+  const num = (id) => Math.max(0, Number(document.getElementById(id)?.value) || 0);
+  const current = await GetRotationPolicy(tab.workFile);
+  try {
+    await SetRotationPolicy(tab.workFile, {
+      ...current,
+      maxDiffs: Math.floor(num('policy-max-diffs')),
+      maxAgeDays: Math.floor(num('policy-max-age')),
+      sizeRatio: num('policy-size-ratio') / 100,
+      cumulativeRatio: num('policy-cumulative') / 100,
+    });
+    showFloatingMessage(i18n.rotationSaved);
+  } catch (err) { alert(errorText(err)); }
+}
+
+export async function startNewGeneration() {
+  const tab = getActiveTab();
+  if (!tab?.workFile) { alert(i18n.selectFileFirst); return; }
+  if (!confirm(i18n.newGenerationConfirm)) return;
+  toggleProgress(true, i18n.processingMsg);
+  try {
+    await StartNewGeneration(tab.workFile, tab.selectedTargetDir || tab.backupDir);
+    // 以降の差分は新しい (最新の) 世代へ
+    tab.selectedTargetDir = "";
+    saveCurrentSession();
+    toggleProgress(false);
+    showFloatingMessage(i18n.newGenerationCreated);
+    UpdateHistory();
+  } catch (err) { toggleProgress(false); alert(errorText(err)); }
+}
+
+// --- 保持ルール (古いバックアップの整理) ---
+export async function loadRetentionPolicy() {
+  const panel = document.getElementById('retention-panel');
+  if (!panel?.open) return;
+  const tab = getActiveTab();
+  const p = tab?.workFile ? await GetRetentionPolicy(tab.workFile) : {};
+  const show = (id, v) => { const el = document.getElementById(id); if (el) el.value = v ? String(v) : ""; };
+  show('retention-keep-last', p.keepLast);
+  show('retention-keep-daily', p.keepDaily);
+  show('retention-keep-weekly', p.keepWeekly);
+  show('retention-keep-monthly', p.keepMonthly);
+  show('retention-max-size', p.maxTotalSize ? Math.round(p.maxTotalSize / (1024 * 1024)) : 0);
+}
+
+function readRetentionInputs() {
+  const num = (id) => Math.max(0, Math.floor(Number(document.getElementById(id)?.value) || 0));
+  return {
+    keepLast: num('retention-keep-last'),
+    keepDaily: num('retention-keep-daily'),
+    keepWeekly: num('retention-keep-weekly'),
+    keepMonthly: num('retention-keep-monthly'),
+    maxTotalSize: num('retention-max-size') * 1024 * 1024,
+  };
+}
+
+export async function saveRetentionPolicy() {
+  const tab = getActiveTab();
+  if (!tab?.workFile) { alert(i18n.selectFileFirst); return; }
+  try {
+    await SetRetentionPolicy(tab.workFile, readRetentionInputs());
+    showFloatingMessage(i18n.retentionSaved);
+  } catch (err) { alert(errorText(err)); }
+}
+
+// 削除予定を確認してから整理する
+export async function pruneBackups() {
+  const tab = getActiveTab();
+  if (!tab?.workFile) { alert(i18n.selectFileFirst); return; }
+  const policy = readRetentionInputs();
+  try {
+    const plan = await PreviewPrune(tab.workFile, tab.backupDir, policy);
+    if (!plan.delete.length) { showFloatingMessage(i18n.pruneNothing); return; }
+    const msg = i18n.pruneConfirm
+      .replace('{count}', plan.delete.length)
+      .replace('{size}', formatSize(plan.freeBytes));
+    if (!confirm(msg)) return;
+    toggleProgress(true, i18n.processingMsg);
+    await ApplyPrune(tab.workFile, tab.backupDir, policy);
+    tab.selectedTargetDir = "";
+    saveCurrentSession();
+    toggleProgress(false);
+    showFloatingMessage(i18n.pruneDone);
+    UpdateHistory();
+  } catch (err) { toggleProgress(false); alert(errorText(err)); }
+}
+
+// --- 世代の圧縮: 選んだ差分の時点を .base にして、以降の差分を作り直す ---
+export async function compactGenerationFrom(diffPath) {
+  if (!confirm(i18n.compactConfirm)) return;
+  const genDir = diffPath.replace(/[\\/][^\\/]+$/, "");
+  toggleProgress(true, i18n.processingMsg);
+  try {
+    const res = await CompactGeneration(genDir, diffPath);
+    toggleProgress(false);
+    showFloatingMessage(i18n.compactDone
+      .replace('{before}', formatSize(res.oldSize))
+      .replace('{after}', formatSize(res.newSize)));
+    UpdateHistory();
+  } catch (err) { toggleProgress(false); alert(errorText(err)); }
+}
+
+// --- 保存時の自動バックアップ (タブごと) ---
+// 監視するのは tab.watch が付いたタブの作業ファイル。作業ファイルや保存先を変えたらもう一度呼ぶ
+export async function syncWatches() {
+  const wanted = new Map();
+  for (const t of tabs) {
+    if (t.watch && t.workFile) wanted.set(t.workFile, t);
+  }
+  for (const f of await GetWatchedFiles()) {
+    if (!wanted.has(f)) await StopWatch(f);
+  }
+  for (const [file, t] of wanted) {
+    try {
+      await StartWatch(file, t.selectedTargetDir || t.backupDir, t.watchAlgo || "");
+    } catch (err) {
+      t.watch = false;
+      showFloatingError(errorText(err));
+    }
+  }
+}
+
+export async function toggleWatch(enabled) {
+  const tab = getActiveTab();
+  if (enabled && !tab?.workFile) {
+    alert(i18n.selectFileFirst);
+    UpdateDisplay();
+    return;
+  }
+  tab.watch = enabled;
+  tab.watchAlgo = document.getElementById('diff-algo')?.value || "";
+  await syncWatches();
+  UpdateDisplay();
+  saveCurrentSession();
+  if (tab.watch) showFloatingMessage(i18n.watchStarted);
+}
+
+// --- スケジュール (N 分ごと / cron 式) ---
+// 保存先・差分アルゴリズムは保存したときのタブの設定を使う
+export async function loadSchedule() {
+  const panel = document.getElementById('schedule-panel');
+  if (!panel?.open) return;
+  const tab = getActiveTab();
+  const s = tab?.workFile ? await GetSchedule(tab.workFile) : {};
+  document.getElementById('schedule-interval').value = s.intervalMinutes ? String(s.intervalMinutes) : "";
+  document.getElementById('schedule-cron').value = s.cron || "";
+  document.getElementById('schedule-mode').value = s.mode || "diff";
+  const last = document.getElementById('schedule-last');
+  if (last) {
+    const result = { backup: i18n.scheduleResultBackup, unchanged: i18n.scheduleResultUnchanged, error: i18n.scheduleResultError }[s.lastResult];
+    last.textContent = result ? `${i18n.scheduleLast || "Last"}: ${new Date(s.lastRun).toLocaleString()} (${result})` : "";
+    last.title = s.lastError || "";
+  }
+}
+
+export async function saveSchedule() {
+  const tab = getActiveTab();
+  if (!tab?.workFile) { alert(i18n.selectFileFirst); return; }
+  try {
+    const interval = Math.max(0, Math.floor(Number(document.getElementById('schedule-interval').value) || 0));
+    const cron = document.getElementById('schedule-cron').value.trim();
+    await SetSchedule({
+      workFile: tab.workFile,
+      backupDir: tab.selectedTargetDir || tab.backupDir,
+      mode: document.getElementById('schedule-mode').value,
+      algo: document.getElementById('diff-algo')?.value || "",
+      intervalMinutes: interval,
+      cron,
+    });
+    showFloatingMessage(interval || cron ? i18n.scheduleSaved : i18n.scheduleRemoved);
+    loadSchedule();
+  } catch (err) { alert(errorText(err)); }
+}
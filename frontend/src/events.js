@@ -3,28 +3,42 @@ import {
   SelectBackupFolder,
   GetFileSize,
   WriteTextFile,
-  ReadTextFile,
-  RestoreBackup
+  ReadTextFile
 } from '../wailsjs/go/main/App';
 
 import {
   i18n,
   getActiveTab,
   addToRecentFiles,
-  saveCurrentSession
+  saveCurrentSession,
+  errorText
 } from './state';
 
 import {
   renderTabs,
   UpdateDisplay,
   UpdateHistory,
-  toggleProgress,
-  showFloatingMessage
+  setProgress,
+  showFloatingMessage,
+  showFloatingError
 } from './ui';
 
 import {
   addTab,
   OnExecute,
+  loadRotationPolicy,
+  saveRotationPolicy,
+  startNewGeneration,
+  loadRetentionPolicy,
+  saveRetentionPolicy,
+  pruneBackups,
+  syncWatches,
+  toggleWatch,
+  loadSchedule,
+  saveSchedule,
+  applySelectedBackups,
+  cancelCurrentJob,
+  onJobProgress,
 } from './actions';
 
 // --- ドラッグアンドドロップの基本防止設定 ---
@@ -66,6 +80,10 @@ export function setupGlobalEvents() {
         tab.workFileSize = await GetFileSize(res);
         addToRecentFiles(res);
         renderTabs(); UpdateDisplay(); UpdateHistory();
+        loadRotationPolicy();
+        loadRetentionPolicy();
+        loadSchedule();
+        syncWatches();
         saveCurrentSession();
         showFloatingMessage(i18n.updatedWorkFile);
       }
@@ -74,11 +92,22 @@ export function setupGlobalEvents() {
       if (res) {
         tab.backupDir = res;
         UpdateDisplay(); UpdateHistory();
+        syncWatches();
         saveCurrentSession();
 	showFloatingMessage(i18n.updatedBackupDir);
       }
     } else if (id === 'execute-backup-btn' || id === 'compact-execute-btn') {
       OnExecute();
+    } else if (id === 'policy-save-btn') {
+      saveRotationPolicy();
+    } else if (id === 'new-generation-btn') {
+      startNewGeneration();
+    } else if (id === 'retention-save-btn') {
+      saveRetentionPolicy();
+    } else if (id === 'prune-btn') {
+      pruneBackups();
+    } else if (id === 'schedule-save-btn') {
+      saveSchedule();
     } else if (id === 'refresh-diff-btn') {
       UpdateHistory();
     } else if (id === 'select-all-btn') {
@@ -86,17 +115,9 @@ export function setupGlobalEvents() {
       const all = Array.from(cbs).every(cb => cb.checked);
       cbs.forEach(cb => cb.checked = !all);
     } else if (id === 'apply-selected-btn') {
-      const targets = Array.from(document.querySelectorAll('.diff-checkbox:checked')).map(el => el.value);
-      if (targets.length > 0 && confirm(i18n.restoreConfirm)) {
-        toggleProgress(true, "Restoring...");
-        try { 
-          for (const p of targets) { await RestoreBackup(p, tab.workFile); } 
-          toggleProgress(false); 
-          showFloatingMessage(i18n.diffApplySuccess); 
-          UpdateHistory(); 
-        }
-        catch (err) { toggleProgress(false); alert(err); }
-      }
+      applySelectedBackups();
+    } else if (id === 'cancel-job-btn') {
+      cancelCurrentJob();
     }
   });
 
@@ -123,7 +144,36 @@ export function setupGlobalEvents() {
     }
   });
 
+  // 世代交代ルールのパネルは開いたときに作業ファイルの設定を読み込む
+  document.getElementById('rotation-panel')?.addEventListener('toggle', loadRotationPolicy);
+  document.getElementById('retention-panel')?.addEventListener('toggle', loadRetentionPolicy);
+  document.getElementById('schedule-panel')?.addEventListener('toggle', loadSchedule);
+  document.getElementById('watch-toggle')?.addEventListener('change', (e) => toggleWatch(e.target.checked));
+
   // --- Wails Runtime Events ---
+  window.runtime.EventsOn("hdiff-progress", (p) => {
+    setProgress(p.done, p.total);
+  });
+
+  // バックアップ・復元ジョブの進み具合と終了
+  window.runtime.EventsOn("job-progress", onJobProgress);
+
+  // スケジュールでバックアップした (変更が無く何もしなかったときは届かない)
+  window.runtime.EventsOn("schedule-backup", (ev) => {
+    const name = ev.workFile.split(/[\\/]/).pop();
+    if (ev.error) { showFloatingError(`${name}: ${errorText(ev.error)}`); loadSchedule(); return; }
+    if (getActiveTab()?.workFile === ev.workFile) { UpdateHistory(); loadSchedule(); }
+    showFloatingMessage((i18n.scheduleBackupDone || "Scheduled backup: {file}").replace('{file}', name));
+  });
+
+  // 保存を監視中のファイルが自動でバックアップされた
+  window.runtime.EventsOn("watch-backup", (ev) => {
+    const name = ev.workFile.split(/[\\/]/).pop();
+    if (!ev.ok) { showFloatingError(`${name}: ${errorText(ev.error)}`); return; }
+    if (getActiveTab()?.workFile === ev.workFile) UpdateHistory();
+    showFloatingMessage((i18n.watchBackupDone || "Auto backup: {file}").replace('{file}', name));
+  });
+
   window.runtime.EventsOn("compact-mode-event", (isCompact) => {
     const view = document.getElementById("compact-view");
     if (isCompact) {
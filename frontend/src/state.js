@@ -74,3 +74,14 @@ export function formatSize(bytes) {
   const i = Math.floor(Math.log(bytes) / Math.log(k));
   return parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + ' ' + sizes[i];
 }
+
+// バックエンドのエラーを表示用の文字列にする。
+// 種類の分かるエラー (AppError) は {code, key, file, message, detail} で届くので、翻訳文に置き換える
+// (翻訳の無い古い設定ファイルではバックエンドのメッセージのまま)
+export function errorText(err) {
+  if (!err || typeof err !== 'object' || !err.code) return String(err);
+  const text = i18n?.[err.key];
+  if (!text) return err.message;
+  const msg = text.replace('{file}', err.file || '');
+  return err.detail ? `${msg}\n\n(${err.detail})` : msg;
+}
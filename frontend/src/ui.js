@@ -20,7 +20,7 @@ import {
     GetConfigDir
 } from '../wailsjs/go/main/App';
 
-import { switchTab, removeTab,updateExecute,reorderTabs } from './actions';
+import { switchTab, removeTab,updateExecute,reorderTabs,compactGenerationFrom,syncWatches } from './actions';
 
 // UI描画・メッセージ系（通常版）
 export function showFloatingMessage(text) {
@@ -92,6 +92,7 @@ export function renderRecentFiles() {
         addToRecentFiles(path);
         renderRecentFiles(); // state側で呼べないためここで実行
         renderTabs(); UpdateDisplay(); UpdateHistory();
+        syncWatches();
         saveCurrentSession();
 	showFloatingMessage(i18n.updatedWorkFile);
         const popup = document.querySelector('.recent-files-section');
@@ -249,6 +250,9 @@ export function UpdateDisplay() {
   if (cFileEl) cFileEl.textContent = tab.workFile ? tab.workFile.split(/[\\/]/).pop() : (i18n.selectedWorkFile || "No File Selected");
   const cSel = document.getElementById('compact-mode-select');
   if (cSel && mode) cSel.value = mode;
+
+  const watchEl = document.getElementById('watch-toggle');
+  if (watchEl) watchEl.checked = !!tab.watch;
 }
 
 export async function UpdateHistory() {
@@ -290,6 +294,7 @@ export async function UpdateHistory() {
     const itemsHtml = await Promise.all(data.map(async (item) => {
       const note = await ReadTextFile(item.filePath + ".note").catch(() => "");
       const isDiffFile = item.fileName.toLowerCase().endsWith('.diff');
+      const isManifest = item.fileName.toLowerCase().endsWith('.manifest');
       const isArchive = !isDiffFile && item.generation === 0;
 
       const itemDir = item.filePath.substring(0, item.filePath.lastIndexOf('/')) 
@@ -298,7 +303,11 @@ export async function UpdateHistory() {
       let statusHtml = "";
       let genBadge = "";
 
-      if (isArchive) {
+      if (isManifest) {
+        const dedupText = i18n.dedupVersion || " Dedup Version";
+        statusHtml = `<div style="color:#2f8f5b; font-weight:bold;">${dedupText}</div>`;
+        genBadge = `<span style="font-size:10px; color:#fff; background:#7a5c99; padding:1px 4px; border-radius:3px; margin-left:5px;">Dedup</span>`;
+      } else if (isArchive) {
         const archiveText = i18n.fullArchive || " Full Archive";
         statusHtml = `<div style="color:#2f8f5b; font-weight:bold;">${archiveText}</div>`;
         genBadge = `<span style="font-size:10px; color:#fff; background:#2f8f5b; padding:1px 4px; border-radius:3px; margin-left:5px;">Archive</span>`;
@@ -335,6 +344,7 @@ export async function UpdateHistory() {
                 ${note ? `<div style="font-size:10px; color:#2f8f5b; font-style:italic; overflow:hidden; text-overflow:ellipsis; white-space:nowrap;"> ${note}</div>` : ''}
               </div>
             </label>
+            ${isDiffFile && item.generation > 0 ? `<button class="compact-btn" data-path="${item.filePath}" title="${i18n.compactFromHere || 'Compact from here'}" style="background:none; border:none; cursor:pointer; font-size:12px; padding:4px; color:#3B5998;">⤓</button>` : ''}
             <button class="note-btn" data-path="${item.filePath}" style="background:none; border:none; cursor:pointer; font-size:14px; padding:4px;"></button>
           </div>
         </div>`;
@@ -354,6 +364,15 @@ export async function UpdateHistory() {
         });
     });
 
+    // --- 世代の圧縮 (この差分の時点を新しい .base にする) ---
+    list.querySelectorAll('.compact-btn').forEach(btn => {
+      btn.onclick = (e) => {
+        e.preventDefault();
+        e.stopPropagation();
+        compactGenerationFrom(btn.getAttribute('data-path'));
+      };
+    });
+
  // --- メモボタンの修正版リスナー ---
     list.querySelectorAll('.note-btn').forEach(btn => {
       btn.onclick = async (e) => {
@@ -453,3 +472,21 @@ export function toggleProgress(show, text = "") {
     }, 500);
   }
 }
+
+// バックエンドから届いた進捗 (done / total バイト) をプログレスバーに反映
+// 進捗表示の文言だけを変える (ジョブの段階など)
+export function setProgressStatus(text) {
+  const status = document.getElementById('progress-status');
+  const cSts = document.getElementById('compact-status-label');
+  if (status) status.textContent = text;
+  if (cSts) cSts.textContent = text;
+}
+
+export function setProgress(done, total) {
+  if (!total) return;
+  const percent = Math.min(100, Math.floor((done / total) * 100)) + '%';
+  const bar = document.getElementById('progress-bar');
+  const cBar = document.getElementById('compact-progress-bar');
+  if (bar) bar.style.width = percent;
+  if (cBar) cBar.style.width = percent;
+}
@@ -13,15 +13,25 @@ export function ApplyMultiBsdiff(arg1:string,arg2:Array<string>):Promise<void>;
 
 export function ApplyMultiDiff(arg1:string,arg2:Array<string>,arg3:string):Promise<void>;
 
-export function ArchiveBackupFile(arg1:string,arg2:string,arg3:string,arg4:string):Promise<void>;
+export function ApplyPrune(arg1:string,arg2:string,arg3:main.RetentionPolicy):Promise<main.PrunePlan>;
+
+export function ApplyVcdiff(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function ApplyZstdPatch(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function ArchiveBackupFile(arg1:string,arg2:string,arg3:string,arg4:string):Promise<main.BackupResult>;
 
 export function BackupOrBsdiff(arg1:string,arg2:string):Promise<void>;
 
-export function BackupOrDiff(arg1:string,arg2:string,arg3:string):Promise<void>;
+export function BackupOrDiff(arg1:string,arg2:string,arg3:string):Promise<main.BackupResult>;
 
 export function BackupOrHdiff(arg1:string,arg2:string):Promise<void>;
 
-export function CopyBackupFile(arg1:string,arg2:string):Promise<void>;
+export function CancelJob(arg1:string):Promise<void>;
+
+export function CompactGeneration(arg1:string,arg2:string):Promise<main.CompactResult>;
+
+export function CopyBackupFile(arg1:string,arg2:string):Promise<main.BackupResult>;
 
 export function CreateBsdiff(arg1:string,arg2:string,arg3:string):Promise<void>;
 
@@ -29,6 +39,12 @@ export function CreateHdiff(arg1:string,arg2:string,arg3:string):Promise<void>;
 
 export function CreateNewGeneration(arg1:string,arg2:number,arg3:string):Promise<string>;
 
+export function CreateVcdiff(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function CreateZstdPatch(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function DedupBackupFile(arg1:string,arg2:string):Promise<main.BackupResult>;
+
 export function DirExists(arg1:string):Promise<boolean>;
 
 export function FindLatestBaseDir(arg1:string):Promise<string|number>;
@@ -45,18 +61,38 @@ export function GetConfig():Promise<main.AppConfig>;
 
 export function GetConfigDir():Promise<string>;
 
+export function GetDiffAlgorithms():Promise<Array<string>>;
+
 export function GetFileSize(arg1:string):Promise<number>;
 
 export function GetHdiffList(arg1:string,arg2:string):Promise<Array<main.DiffFileInfo>>;
 
 export function GetI18N():Promise<Record<string, string>>;
 
+export function GetJobs():Promise<Array<main.JobInfo>>;
+
 export function GetLanguageText(arg1:string):Promise<string>;
 
 export function GetRestorePreviousState():Promise<boolean>;
 
+export function GetRetentionPolicy(arg1:string):Promise<main.RetentionPolicy>;
+
+export function GetRotationPolicy(arg1:string):Promise<main.RotationPolicy>;
+
+export function GetSchedule(arg1:string):Promise<main.BackupSchedule>;
+
+export function GetSchedules():Promise<Array<main.BackupSchedule>>;
+
+export function GetVerifyDiffAfterWrite():Promise<boolean>;
+
+export function GetWatchedFiles():Promise<Array<string>>;
+
+export function GetZstdPatchLevel():Promise<number>;
+
 export function OpenDirectory(arg1:string):Promise<void>;
 
+export function PreviewPrune(arg1:string,arg2:string,arg3:main.RetentionPolicy):Promise<main.PrunePlan>;
+
 export function ReadTextFile(arg1:string):Promise<string>;
 
 export function ResolveGenerationDir(arg1:string,arg2:string):Promise<string>;
@@ -77,6 +113,28 @@ export function SetLanguage(arg1:string):Promise<void>;
 
 export function SetRestorePreviousState(arg1:boolean):Promise<void>;
 
+export function SetRetentionPolicy(arg1:string,arg2:main.RetentionPolicy):Promise<void>;
+
+export function SetRotationPolicy(arg1:string,arg2:main.RotationPolicy):Promise<void>;
+
+export function SetSchedule(arg1:main.BackupSchedule):Promise<void>;
+
+export function SetVerifyDiffAfterWrite(arg1:boolean):Promise<void>;
+
+export function StartBackupJob(arg1:string,arg2:string,arg3:string,arg4:string,arg5:string):Promise<string>;
+
+export function StartNewGeneration(arg1:string,arg2:string):Promise<string>;
+
+export function StartRestoreJob(arg1:string,arg2:string):Promise<string>;
+
+export function StartVerifyJob(arg1:string):Promise<string>;
+
+export function StartWatch(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function StopWatch(arg1:string):Promise<void>;
+
 export function ToggleCompactMode(arg1:boolean):Promise<void>;
 
+export function VerifyBackups(arg1:string):Promise<main.VerifyReport>;
+
 export function WriteTextFile(arg1:string,arg2:string):Promise<void>;
@@ -22,6 +22,18 @@ export function ApplyMultiDiff(arg1, arg2, arg3) {
   return window['go']['main']['App']['ApplyMultiDiff'](arg1, arg2, arg3);
 }
 
+export function ApplyPrune(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ApplyPrune'](arg1, arg2, arg3);
+}
+
+export function ApplyVcdiff(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ApplyVcdiff'](arg1, arg2, arg3);
+}
+
+export function ApplyZstdPatch(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ApplyZstdPatch'](arg1, arg2, arg3);
+}
+
 export function ArchiveBackupFile(arg1, arg2, arg3, arg4) {
   return window['go']['main']['App']['ArchiveBackupFile'](arg1, arg2, arg3, arg4);
 }
@@ -38,6 +50,14 @@ export function BackupOrHdiff(arg1, arg2) {
   return window['go']['main']['App']['BackupOrHdiff'](arg1, arg2);
 }
 
+export function CancelJob(arg1) {
+  return window['go']['main']['App']['CancelJob'](arg1);
+}
+
+export function CompactGeneration(arg1, arg2) {
+  return window['go']['main']['App']['CompactGeneration'](arg1, arg2);
+}
+
 export function CopyBackupFile(arg1, arg2) {
   return window['go']['main']['App']['CopyBackupFile'](arg1, arg2);
 }
@@ -54,6 +74,18 @@ export function CreateNewGeneration(arg1, arg2, arg3) {
   return window['go']['main']['App']['CreateNewGeneration'](arg1, arg2, arg3);
 }
 
+export function CreateVcdiff(arg1, arg2, arg3) {
+  return window['go']['main']['App']['CreateVcdiff'](arg1, arg2, arg3);
+}
+
+export function CreateZstdPatch(arg1, arg2, arg3) {
+  return window['go']['main']['App']['CreateZstdPatch'](arg1, arg2, arg3);
+}
+
+export function DedupBackupFile(arg1, arg2) {
+  return window['go']['main']['App']['DedupBackupFile'](arg1, arg2);
+}
+
 export function DirExists(arg1) {
   return window['go']['main']['App']['DirExists'](arg1);
 }
@@ -86,6 +118,10 @@ export function GetConfigDir() {
   return window['go']['main']['App']['GetConfigDir']();
 }
 
+export function GetDiffAlgorithms() {
+  return window['go']['main']['App']['GetDiffAlgorithms']();
+}
+
 export function GetFileSize(arg1) {
   return window['go']['main']['App']['GetFileSize'](arg1);
 }
@@ -98,6 +134,10 @@ export function GetI18N() {
   return window['go']['main']['App']['GetI18N']();
 }
 
+export function GetJobs() {
+  return window['go']['main']['App']['GetJobs']();
+}
+
 export function GetLanguageText(arg1) {
   return window['go']['main']['App']['GetLanguageText'](arg1);
 }
@@ -106,10 +146,42 @@ export function GetRestorePreviousState() {
   return window['go']['main']['App']['GetRestorePreviousState']();
 }
 
+export function GetRetentionPolicy(arg1) {
+  return window['go']['main']['App']['GetRetentionPolicy'](arg1);
+}
+
+export function GetRotationPolicy(arg1) {
+  return window['go']['main']['App']['GetRotationPolicy'](arg1);
+}
+
+export function GetSchedule(arg1) {
+  return window['go']['main']['App']['GetSchedule'](arg1);
+}
+
+export function GetSchedules() {
+  return window['go']['main']['App']['GetSchedules']();
+}
+
+export function GetVerifyDiffAfterWrite() {
+  return window['go']['main']['App']['GetVerifyDiffAfterWrite']();
+}
+
+export function GetWatchedFiles() {
+  return window['go']['main']['App']['GetWatchedFiles']();
+}
+
+export function GetZstdPatchLevel() {
+  return window['go']['main']['App']['GetZstdPatchLevel']();
+}
+
 export function OpenDirectory(arg1) {
   return window['go']['main']['App']['OpenDirectory'](arg1);
 }
 
+export function PreviewPrune(arg1, arg2, arg3) {
+  return window['go']['main']['App']['PreviewPrune'](arg1, arg2, arg3);
+}
+
 export function ReadTextFile(arg1) {
   return window['go']['main']['App']['ReadTextFile'](arg1);
 }
@@ -150,10 +222,54 @@ export function SetRestorePreviousState(arg1) {
   return window['go']['main']['App']['SetRestorePreviousState'](arg1);
 }
 
+export function SetRetentionPolicy(arg1, arg2) {
+  return window['go']['main']['App']['SetRetentionPolicy'](arg1, arg2);
+}
+
+export function SetRotationPolicy(arg1, arg2) {
+  return window['go']['main']['App']['SetRotationPolicy'](arg1, arg2);
+}
+
+export function SetSchedule(arg1) {
+  return window['go']['main']['App']['SetSchedule'](arg1);
+}
+
+export function SetVerifyDiffAfterWrite(arg1) {
+  return window['go']['main']['App']['SetVerifyDiffAfterWrite'](arg1);
+}
+
+export function StartBackupJob(arg1, arg2, arg3, arg4, arg5) {
+  return window['go']['main']['App']['StartBackupJob'](arg1, arg2, arg3, arg4, arg5);
+}
+
+export function StartNewGeneration(arg1, arg2) {
+  return window['go']['main']['App']['StartNewGeneration'](arg1, arg2);
+}
+
+export function StartRestoreJob(arg1, arg2) {
+  return window['go']['main']['App']['StartRestoreJob'](arg1, arg2);
+}
+
+export function StartVerifyJob(arg1) {
+  return window['go']['main']['App']['StartVerifyJob'](arg1);
+}
+
+export function StartWatch(arg1, arg2, arg3) {
+  return window['go']['main']['App']['StartWatch'](arg1, arg2, arg3);
+}
+
+export function StopWatch(arg1) {
+  return window['go']['main']['App']['StopWatch'](arg1);
+}
+
 export function ToggleCompactMode(arg1) {
   return window['go']['main']['App']['ToggleCompactMode'](arg1);
 }
 
+export function VerifyBackups(arg1) {
+  return window['go']['main']['App']['VerifyBackups'](arg1);
+}
+
 export function WriteTextFile(arg1, arg2) {
   return window['go']['main']['App']['WriteTextFile'](arg1, arg2);
 }
@@ -19,12 +19,56 @@ export namespace frontend {
 
 export namespace main {
 	
+	export class RetentionPolicy {
+	    keepLast: number;
+	    keepDaily: number;
+	    keepWeekly: number;
+	    keepMonthly: number;
+	    maxTotalSize: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RetentionPolicy(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.keepLast = source["keepLast"];
+	        this.keepDaily = source["keepDaily"];
+	        this.keepWeekly = source["keepWeekly"];
+	        this.keepMonthly = source["keepMonthly"];
+	        this.maxTotalSize = source["maxTotalSize"];
+	    }
+	}
+	export class RotationPolicy {
+	    sizeRatio: number;
+	    minWorkSize: number;
+	    maxDiffs: number;
+	    maxAgeDays: number;
+	    cumulativeRatio: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new RotationPolicy(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.sizeRatio = source["sizeRatio"];
+	        this.minWorkSize = source["minWorkSize"];
+	        this.maxDiffs = source["maxDiffs"];
+	        this.maxAgeDays = source["maxAgeDays"];
+	        this.cumulativeRatio = source["cumulativeRatio"];
+	    }
+	}
 	export class AppConfig {
 	    language: string;
 	    alwaysOnTop: boolean;
 	    getRestoreState: boolean;
 	    bsdiffMaxFileSize: number;
 	    autoBaseGenerationThreshold: number;
+	    zstdPatchLevel: number;
+	    verifyDiffAfterWrite: boolean;
+	    rotationPolicies?: Record<string, RotationPolicy>;
+	    retentionPolicies?: Record<string, RetentionPolicy>;
 	    i18n: Record<string, any>;
 	
 	    static createFrom(source: any = {}) {
@@ -38,8 +82,30 @@ export namespace main {
 	        this.getRestoreState = source["getRestoreState"];
 	        this.bsdiffMaxFileSize = source["bsdiffMaxFileSize"];
 	        this.autoBaseGenerationThreshold = source["autoBaseGenerationThreshold"];
+	        this.zstdPatchLevel = source["zstdPatchLevel"];
+	        this.verifyDiffAfterWrite = source["verifyDiffAfterWrite"];
+	        this.rotationPolicies = this.convertValues(source["rotationPolicies"], RotationPolicy, true);
+	        this.retentionPolicies = this.convertValues(source["retentionPolicies"], RetentionPolicy, true);
 	        this.i18n = source["i18n"];
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class BackupItem {
 	    fileName: string;
@@ -61,6 +127,70 @@ export namespace main {
 	        this.generation = source["generation"];
 	    }
 	}
+	export class BackupResult {
+	    unchanged: boolean;
+	    path: string;
+	    sha256: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new BackupResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.unchanged = source["unchanged"];
+	        this.path = source["path"];
+	        this.sha256 = source["sha256"];
+	    }
+	}
+	export class BackupSchedule {
+	    workFile: string;
+	    backupDir: string;
+	    mode: string;
+	    algo: string;
+	    intervalMinutes: number;
+	    cron: string;
+	    lastRun: string;
+	    lastResult: string;
+	    lastError: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new BackupSchedule(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.workFile = source["workFile"];
+	        this.backupDir = source["backupDir"];
+	        this.mode = source["mode"];
+	        this.algo = source["algo"];
+	        this.intervalMinutes = source["intervalMinutes"];
+	        this.cron = source["cron"];
+	        this.lastRun = source["lastRun"];
+	        this.lastResult = source["lastResult"];
+	        this.lastError = source["lastError"];
+	    }
+	}
+	export class CompactResult {
+	    genDir: string;
+	    rewritten: string[];
+	    dropped: string[];
+	    oldSize: number;
+	    newSize: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new CompactResult(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.genDir = source["genDir"];
+	        this.rewritten = source["rewritten"];
+	        this.dropped = source["dropped"];
+	        this.oldSize = source["oldSize"];
+	        this.newSize = source["newSize"];
+	    }
+	}
 	export class DiffFileInfo {
 	    fileName: string;
 	    filePath: string;
@@ -79,6 +209,152 @@ export namespace main {
 	        this.fileSize = source["fileSize"];
 	    }
 	}
+	export class JobInfo {
+	    id: string;
+	    kind: string;
+	    workFile: string;
+	    target: string;
+	    state: string;
+	    phase: string;
+	    done: number;
+	    total: number;
+	    result?: any;
+	    error?: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new JobInfo(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.kind = source["kind"];
+	        this.workFile = source["workFile"];
+	        this.target = source["target"];
+	        this.state = source["state"];
+	        this.phase = source["phase"];
+	        this.done = source["done"];
+	        this.total = source["total"];
+	        this.result = source["result"];
+	        this.error = source["error"];
+	    }
+	}
+	export class PruneItem {
+	    path: string;
+	    kind: string;
+	    size: number;
+	    time?: string;
+	    reason?: string;
+	    count?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PruneItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.kind = source["kind"];
+	        this.size = source["size"];
+	        this.time = source["time"];
+	        this.reason = source["reason"];
+	        this.count = source["count"];
+	    }
+	}
+	export class PrunePlan {
+	    root: string;
+	    applied: boolean;
+	    keep: PruneItem[];
+	    delete: PruneItem[];
+	    removeDirs?: string[];
+	    keepBytes: number;
+	    freeBytes: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PrunePlan(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.root = source["root"];
+	        this.applied = source["applied"];
+	        this.keep = this.convertValues(source["keep"], PruneItem);
+	        this.delete = this.convertValues(source["delete"], PruneItem);
+	        this.removeDirs = source["removeDirs"];
+	        this.keepBytes = source["keepBytes"];
+	        this.freeBytes = source["freeBytes"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+	
+	export class VerifyIssue {
+	    kind: string;
+	    path: string;
+	    detail?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new VerifyIssue(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.kind = source["kind"];
+	        this.path = source["path"];
+	        this.detail = source["detail"];
+	    }
+	}
+	export class VerifyReport {
+	    root: string;
+	    checked: number;
+	    issues: VerifyIssue[];
+	
+	    static createFrom(source: any = {}) {
+	        return new VerifyReport(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.root = source["root"];
+	        this.checked = source["checked"];
+	        this.issues = this.convertValues(source["issues"], VerifyIssue);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 
 }
 
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
+
+	"cg-file-backup/libs/hdiffpatch"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 func (a *App) GetHdiffList(workFile, customDir string) ([]DiffFileInfo, error) {
@@ -48,14 +53,71 @@ func (a *App) BackupOrHdiff(workFile, customDir string) error {
 }
 
 func (a *App) ApplyHdiffWrapper(workFile, diffFile string) error {
-	backupDir := filepath.Dir(diffFile)
-	baseName := strings.Split(filepath.Base(diffFile), ".20")[0] + ".base"
-	baseFull := filepath.Join(backupDir, baseName)
-	if _, err := os.Stat(baseFull); os.IsNotExist(err) {
-		baseFull = filepath.Join(backupDir, filepath.Base(workFile)+".base")
+	return a.applyHdiffTo(workFile, diffFile, autoOutputPath(workFile))
+}
+
+// applyHdiffTo は ApplyHdiffWrapper の本体です。復元先 outPath は呼び出し側が決めます
+func (a *App) applyHdiffTo(workFile, diffFile, outPath string) error {
+	baseFull, err := a.resolveBaseFile(workFile, diffFile)
+	if err != nil { return err }
+	if err := a.ApplyHdiff(baseFull, diffFile, outPath); err != nil { return err }
+	return a.verifyRestored(context.Background(), diffFile, outPath)
+}
+
+// CreateHdiff は OldFile から NewFile への差分を HDiffPatch 形式 (zstd 圧縮) で DiffFile に書き出します
+func (a *App) CreateHdiff(OldFile, NewFile, DiffFile string) error {
+	oldF, err := os.Open(OldFile)
+	if err != nil { return err }
+	defer oldF.Close()
+	newF, err := os.Open(NewFile)
+	if err != nil { return err }
+	defer newF.Close()
+
+	err = writeFileAtomic(DiffFile, func(out *os.File) error {
+		return hdiffpatch.Diff(oldF, newF, out, a.hdiffOptions("create"))
+	})
+	if err != nil {
+		return fmt.Errorf("hdiff 作成に失敗しました: %w", err)
 	}
+	return nil
+}
+
+// ApplyHdiff は baseFull に diffFile を適用して outPath に書き出します
+// (hdiffz -s / -SD で作成された既存の差分もそのまま適用できます)
+func (a *App) ApplyHdiff(baseFull, diffFile, outPath string) error {
+	base, err := os.Open(baseFull)
+	if err != nil { return err }
+	defer base.Close()
+	baseInfo, err := base.Stat()
+	if err != nil { return err }
+
+	diff, err := os.Open(diffFile)
+	if err != nil { return err }
+	defer diff.Close()
+	diffInfo, err := diff.Stat()
+	if err != nil { return err }
+
+	out, err := os.Create(outPath)
+	if err != nil { return err }
+	err = hdiffpatch.Patch(base, baseInfo.Size(), diff, diffInfo.Size(), out, a.hdiffOptions("apply"))
+	if closeErr := out.Close(); err == nil { err = closeErr }
+	if err != nil {
+		// 途中まで書かれた復元ファイルは残さない
+		os.Remove(outPath)
+		return newAppError(ErrPatchCorrupt, filepath.Base(diffFile), fmt.Errorf("hdiff: %w", err))
+	}
+	return nil
+}
 
-	outPath := autoOutputPath(workFile)
-	// 各OS版の ApplyHdiff を呼び出し
-	return a.ApplyHdiff(baseFull, diffFile, outPath)
+// hdiffOptions は進捗を "hdiff-progress" イベントとしてフロントエンドへ送る設定を返します
+// (CLI モードでは ctx が無いため送信しません)
+func (a *App) hdiffOptions(op string) *hdiffpatch.Options {
+	if a.ctx == nil { return nil }
+	return &hdiffpatch.Options{Progress: func(done, total int64) {
+		runtime.EventsEmit(a.ctx, "hdiff-progress", map[string]interface{}{
+			"op":    op,
+			"done":  done,
+			"total": total,
+		})
+	}}
 }
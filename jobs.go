@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// ----------------- ジョブ (バックグラウンド実行・進捗・中止) -----------------
+//
+// 大きなファイルのバックアップ・復元・検証は Wails の呼び出しの中で待たず、ジョブとしてキューに入れて
+// 1 つずつ実行します。Start*Job はすぐにジョブ ID を返し、段階と処理したバイト数、最後の結果は
+// "job-progress" イベント (JobInfo) で知らせます。CancelJob で中止するとファイルの読み込みが止まり、
+// 書きかけの出力 (一時ファイル・復元途中のファイル・作りかけの世代) を削除します。
+// 処理の本体は ctx を受け取り、読み込みを progressReader で包んで進捗と中止を伝えます。
+
+// jobProgressInterval より短い間隔では進捗イベントを送りません (段階の変わり目と終了は必ず送ります)
+const jobProgressInterval = 200 * time.Millisecond
+
+// ジョブの状態 (JobInfo.State)
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// ジョブの段階 (JobInfo.Phase)
+const (
+	phaseHash     = "hash"     // 作業ファイルのハッシュ計算 (変更の有無の確認)
+	phaseDiff     = "diff"     // 差分の作成
+	phaseCopy     = "copy"     // コピー
+	phaseCompress = "compress" // ZIP / TAR への圧縮
+	phaseChunk    = "chunk"    // チャンクへの分割と保存
+	phaseRestore  = "restore"  // 復元先への書き出し
+	phaseVerify   = "verify"   // ハッシュの照合 (試験復元・復元結果・検証)
+	phaseWait     = "wait"     // 同じバックアップフォルダを使う別の処理の終了待ち (lockRoot)
+)
+
+// JobInfo はジョブ 1 つ分の状態です ("job-progress" イベント・GetJobs)
+type JobInfo struct {
+	ID       string `json:"id"`
+	Kind     string `json:"kind"`     // backup, restore, verify
+	WorkFile string `json:"workFile"` // 作業ファイル (verify では空)
+	Target   string `json:"target"`   // 復元するバックアップ・検証するフォルダ
+	State    string `json:"state"`    // queued, running, done, failed, cancelled
+	Phase    string `json:"phase"`
+	Done     int64  `json:"done"`             // 今の段階で処理したバイト数
+	Total    int64  `json:"total"`            // 今の段階の全体のバイト数 (分からなければ 0)
+	Result   any    `json:"result,omitempty"` // BackupResult / 復元先のパス / VerifyReport
+	Error    any    `json:"error,omitempty"`  // formatError の形
+}
+
+// job は実行待ち・実行中のジョブです
+type job struct {
+	app      *App
+	info     JobInfo
+	run      func(ctx context.Context) (any, error)
+	ctx      context.Context
+	cancel   context.CancelFunc
+	lastEmit time.Time
+}
+
+type jobContextKey struct{}
+
+// StartBackupJob は runBackup をジョブとして実行します (mode は backupModes のいずれか)
+func (a *App) StartBackupJob(workFile, backupDir, mode, algo, password string) (string, error) {
+	if _, err := os.Stat(workFile); err != nil {
+		return "", newAppError(ErrInvalidPath, workFile, err)
+	}
+	return a.enqueueJob(JobInfo{Kind: "backup", WorkFile: workFile, Target: backupDir}, func(ctx context.Context) (any, error) {
+		return a.runBackup(ctx, workFile, backupDir, mode, algo, password)
+	})
+}
+
+// StartRestoreJob は RestoreBackup をジョブとして実行します。結果は復元したファイルのパスです
+func (a *App) StartRestoreJob(path, workFile string) (string, error) {
+	return a.enqueueJob(JobInfo{Kind: "restore", WorkFile: workFile, Target: path}, func(ctx context.Context) (any, error) {
+		out := autoOutputPath(workFile)
+		return out, a.restoreBackupTo(ctx, path, workFile, out)
+	})
+}
+
+// StartVerifyJob は VerifyBackups をジョブとして実行します
+func (a *App) StartVerifyJob(root string) (string, error) {
+	return a.enqueueJob(JobInfo{Kind: "verify", Target: root}, func(ctx context.Context) (any, error) {
+		return a.verifyBackups(ctx, root)
+	})
+}
+
+// CancelJob はジョブを中止します。待っているものはキューから外し、実行中のものは読み込みを止めて後片付けします
+func (a *App) CancelJob(id string) error {
+	a.jobMu.Lock()
+	j, ok := a.jobs[id]
+	if !ok {
+		a.jobMu.Unlock()
+		return newAppError(ErrJobNotFound, id, nil)
+	}
+	queued := j.info.State == jobQueued
+	if queued {
+		for i, q := range a.jobQueue {
+			if q == j {
+				a.jobQueue = append(a.jobQueue[:i], a.jobQueue[i+1:]...)
+				break
+			}
+		}
+		j.info.State = jobCancelled
+		delete(a.jobs, id)
+	}
+	a.jobMu.Unlock()
+
+	j.cancel()
+	if queued {
+		j.emit(true)
+	}
+	return nil
+}
+
+// GetJobs は待っている・実行中のジョブの一覧を返します (キューの順)
+func (a *App) GetJobs() []JobInfo {
+	a.jobMu.Lock()
+	defer a.jobMu.Unlock()
+	list := []JobInfo{}
+	if a.jobCurrent != nil {
+		list = append(list, a.jobCurrent.info)
+	}
+	for _, j := range a.jobQueue {
+		list = append(list, j.info)
+	}
+	return list
+}
+
+// enqueueJob はジョブをキューの最後に入れ、実行役がいなければ起動します
+func (a *App) enqueueJob(info JobInfo, run func(ctx context.Context) (any, error)) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{app: a, run: run, cancel: cancel}
+	j.ctx = context.WithValue(ctx, jobContextKey{}, j)
+
+	a.jobMu.Lock()
+	a.jobSeq++
+	info.ID = fmt.Sprintf("job-%d", a.jobSeq)
+	info.State = jobQueued
+	j.info = info
+	if a.jobs == nil {
+		a.jobs = map[string]*job{}
+	}
+	a.jobs[info.ID] = j
+	a.jobQueue = append(a.jobQueue, j)
+	start := !a.jobWorking
+	a.jobWorking = true
+	a.jobMu.Unlock()
+
+	j.emit(true)
+	if start {
+		go a.runJobs()
+	}
+	return info.ID, nil
+}
+
+// runJobs はキューが空になるまでジョブを順に実行します
+func (a *App) runJobs() {
+	for {
+		a.jobMu.Lock()
+		if len(a.jobQueue) == 0 {
+			a.jobWorking, a.jobCurrent = false, nil
+			a.jobMu.Unlock()
+			return
+		}
+		j := a.jobQueue[0]
+		a.jobQueue = a.jobQueue[1:]
+		a.jobCurrent = j
+		j.info.State = jobRunning
+		a.jobMu.Unlock()
+
+		j.emit(true)
+		result, err := j.run(j.ctx)
+
+		a.jobMu.Lock()
+		switch {
+		case err != nil && j.ctx.Err() != nil:
+			j.info.State = jobCancelled
+		case err != nil:
+			j.info.State, j.info.Error = jobFailed, formatError(err)
+		default:
+			j.info.State, j.info.Result = jobDone, result
+		}
+		delete(a.jobs, j.info.ID)
+		a.jobMu.Unlock()
+		j.cancel()
+		j.emit(true)
+	}
+}
+
+// emit は状態を "job-progress" イベントで送ります。force でなければ jobProgressInterval ごとに間引きます
+func (j *job) emit(force bool) {
+	j.app.jobMu.Lock()
+	if !force && time.Since(j.lastEmit) < jobProgressInterval {
+		j.app.jobMu.Unlock()
+		return
+	}
+	j.lastEmit = time.Now()
+	info := j.info
+	j.app.jobMu.Unlock()
+
+	if j.app.ctx != nil {
+		runtime.EventsEmit(j.app.ctx, "job-progress", info)
+	}
+}
+
+// jobFromContext は ctx で実行中のジョブを返します (ジョブでなければ nil)
+func jobFromContext(ctx context.Context) *job {
+	j, _ := ctx.Value(jobContextKey{}).(*job)
+	return j
+}
+
+// setJobPhase は ctx のジョブを段階 phase (全体 total バイト) に進めます。ジョブでなければ何もしません
+func setJobPhase(ctx context.Context, phase string, total int64) {
+	j := jobFromContext(ctx)
+	if j == nil {
+		return
+	}
+	j.app.jobMu.Lock()
+	j.info.Phase, j.info.Done, j.info.Total = phase, 0, total
+	j.app.jobMu.Unlock()
+	j.emit(true)
+}
+
+// addJobProgress は ctx のジョブの今の段階に n バイト足します
+func addJobProgress(ctx context.Context, n int64) {
+	j := jobFromContext(ctx)
+	if j == nil || n == 0 {
+		return
+	}
+	j.app.jobMu.Lock()
+	j.info.Done += n
+	j.app.jobMu.Unlock()
+	j.emit(false)
+}
+
+// progressReader は読んだバイト数をジョブの進捗に足し、ジョブが中止されていれば読み込みを止めます
+type progressReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func newProgressReader(ctx context.Context, r io.Reader) io.Reader {
+	return &progressReader{ctx: ctx, r: r}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	if err := p.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := p.r.Read(b)
+	addJobProgress(p.ctx, int64(n))
+	return n, err
+}
+
+// fileSize は path のサイズを返します (進捗の全体量に使うので、分からなければ 0)
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,630 @@
+package binarydist
+
+import (
+	"io"
+)
+
+// Package compress/bzip2 implements only decompression, so this is a
+// bzip2 encoder following the reference implementation (libbzip2 at
+// block size 9): the same block boundaries, run-length encoding, table
+// selection and Huffman code lengths, so the output is byte for byte what
+// `bzip2 -c` writes for almost all inputs. The only possible difference is
+// the order of identical rotations in a periodic block, which the
+// reference sort leaves implementation defined; either order decodes to
+// the same data.
+
+const (
+	bzBlockSize100k = 9
+	bzMaxBlock      = 100000*bzBlockSize100k - 19 // nblockMAX in libbzip2
+	bzGroupSize     = 50                          // symbols coded with one table
+	bzIters         = 4                           // table refinement passes
+	bzMaxCodeLen    = 17
+	bzRunA          = 0
+	bzRunB          = 1
+)
+
+type bzip2Writer struct {
+	w   io.Writer
+	bs  bitWriter
+	err error
+
+	block  []byte
+	inUse  [256]bool
+	runCh  int // pending run byte, or 256 for none
+	runLen int
+
+	blockCRC    uint32
+	combinedCRC uint32
+}
+
+func newBzip2Writer(w io.Writer) (io.WriteCloser, error) {
+	bw := &bzip2Writer{
+		w:        w,
+		block:    make([]byte, 0, bzMaxBlock+5),
+		runCh:    256,
+		blockCRC: 0xffffffff,
+	}
+	bw.bs.putBytes('B', 'Z', 'h', '0'+bzBlockSize100k)
+	return bw, nil
+}
+
+func (bw *bzip2Writer) Write(b []byte) (int, error) {
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	for _, c := range b {
+		bw.addChar(c)
+		if len(bw.block) >= bzMaxBlock {
+			bw.writeBlock()
+			if bw.err != nil {
+				return 0, bw.err
+			}
+		}
+	}
+	return len(b), nil
+}
+
+func (bw *bzip2Writer) Close() error {
+	if bw.err != nil {
+		return bw.err
+	}
+	bw.flushRun()
+	bw.writeBlock()
+	bw.bs.putBytes(0x17, 0x72, 0x45, 0x38, 0x50, 0x90)
+	bw.bs.putUint32(bw.combinedCRC)
+	bw.bs.finish()
+	bw.flushOutput()
+	return bw.err
+}
+
+// addChar is the first run-length encoding: runs of 4 to 255 equal bytes
+// become the 4 bytes followed by a count of the remaining ones.
+func (bw *bzip2Writer) addChar(c byte) {
+	ch := int(c)
+	if ch != bw.runCh && bw.runLen == 1 {
+		bw.blockCRC = bzCRCUpdate(bw.blockCRC, byte(bw.runCh))
+		bw.inUse[bw.runCh] = true
+		bw.block = append(bw.block, byte(bw.runCh))
+		bw.runCh = ch
+	} else if ch != bw.runCh || bw.runLen == 255 {
+		bw.flushRun()
+		bw.runCh, bw.runLen = ch, 1
+	} else {
+		bw.runLen++
+	}
+}
+
+func (bw *bzip2Writer) flushRun() {
+	if bw.runCh < 256 {
+		c := byte(bw.runCh)
+		for i := 0; i < bw.runLen; i++ {
+			bw.blockCRC = bzCRCUpdate(bw.blockCRC, c)
+		}
+		bw.inUse[c] = true
+		switch bw.runLen {
+		case 1:
+			bw.block = append(bw.block, c)
+		case 2:
+			bw.block = append(bw.block, c, c)
+		case 3:
+			bw.block = append(bw.block, c, c, c)
+		default:
+			bw.inUse[bw.runLen-4] = true
+			bw.block = append(bw.block, c, c, c, c, byte(bw.runLen-4))
+		}
+	}
+	bw.runCh, bw.runLen = 256, 0
+}
+
+// writeBlock compresses the current block (if any) into the bit stream.
+// Blocks are not byte aligned, so only whole bytes are passed on to w.
+func (bw *bzip2Writer) writeBlock() {
+	if len(bw.block) > 0 {
+		crc := ^bw.blockCRC
+		bw.combinedCRC = (bw.combinedCRC<<1 | bw.combinedCRC>>31) ^ crc
+
+		ptr := bwtSort(bw.block)
+		origPtr := 0
+		for i, p := range ptr {
+			if p == 0 {
+				origPtr = i
+				break
+			}
+		}
+
+		bw.bs.putBytes(0x31, 0x41, 0x59, 0x26, 0x53, 0x59)
+		bw.bs.putUint32(crc)
+		bw.bs.put(1, 0) // not randomised
+		bw.bs.put(24, uint32(origPtr))
+		bw.sendMTFValues(bw.mtfValues(ptr))
+	}
+
+	bw.block = bw.block[:0]
+	bw.inUse = [256]bool{}
+	bw.blockCRC = 0xffffffff
+	bw.flushOutput()
+}
+
+func (bw *bzip2Writer) flushOutput() {
+	if bw.err == nil && len(bw.bs.out) > 0 {
+		_, bw.err = bw.w.Write(bw.bs.out)
+	}
+	bw.bs.out = bw.bs.out[:0]
+}
+
+// mtfValues applies move-to-front and the zero run encoding (RUNA/RUNB)
+// to the last column of the sorted rotations. The result ends with EOB.
+func (bw *bzip2Writer) mtfValues(ptr []int32) []uint16 {
+	var unseqToSeq [256]byte
+	nInUse := 0
+	for i, used := range bw.inUse {
+		if used {
+			unseqToSeq[i] = byte(nInUse)
+			nInUse++
+		}
+	}
+
+	var yy [256]byte
+	for i := range yy {
+		yy[i] = byte(i)
+	}
+	out := make([]uint16, 0, len(ptr)+1)
+	zPend := 0
+	flushZeros := func() {
+		if zPend == 0 {
+			return
+		}
+		zPend--
+		for {
+			if zPend&1 != 0 {
+				out = append(out, bzRunB)
+			} else {
+				out = append(out, bzRunA)
+			}
+			if zPend < 2 {
+				break
+			}
+			zPend = (zPend - 2) / 2
+		}
+		zPend = 0
+	}
+
+	n := len(bw.block)
+	for _, p := range ptr {
+		j := int(p) - 1
+		if j < 0 {
+			j += n
+		}
+		c := unseqToSeq[bw.block[j]]
+		if yy[0] == c {
+			zPend++
+			continue
+		}
+		flushZeros()
+		k := 1
+		tmp := yy[1]
+		yy[1] = yy[0]
+		for c != tmp {
+			k++
+			tmp, yy[k] = yy[k], tmp
+		}
+		yy[0] = tmp
+		out = append(out, uint16(k+1))
+	}
+	flushZeros()
+	return append(out, uint16(nInUse+1))
+}
+
+// sendMTFValues chooses and writes the Huffman tables, the selectors and
+// the coded symbols of one block.
+func (bw *bzip2Writer) sendMTFValues(mtfv []uint16) {
+	nInUse := 0
+	for _, used := range bw.inUse {
+		if used {
+			nInUse++
+		}
+	}
+	alphaSize := nInUse + 2
+	nMTF := len(mtfv)
+
+	var freq [258]int32
+	for _, v := range mtfv {
+		freq[v]++
+	}
+
+	var nGroups int
+	switch {
+	case nMTF < 200:
+		nGroups = 2
+	case nMTF < 600:
+		nGroups = 3
+	case nMTF < 1200:
+		nGroups = 4
+	case nMTF < 2400:
+		nGroups = 5
+	default:
+		nGroups = 6
+	}
+
+	// Initial tables: split the symbols into nGroups ranges of roughly
+	// equal frequency, each table cheap for its own range.
+	var length [6][258]uint8
+	for t := range length {
+		for v := 0; v < alphaSize; v++ {
+			length[t][v] = 15
+		}
+	}
+	remF, gs := int32(nMTF), 0
+	for nPart := nGroups; nPart > 0; nPart-- {
+		tFreq := remF / int32(nPart)
+		ge, aFreq := gs-1, int32(0)
+		for aFreq < tFreq && ge < alphaSize-1 {
+			ge++
+			aFreq += freq[ge]
+		}
+		if ge > gs && nPart != nGroups && nPart != 1 && (nGroups-nPart)%2 == 1 {
+			aFreq -= freq[ge]
+			ge--
+		}
+		for v := 0; v < alphaSize; v++ {
+			if v >= gs && v <= ge {
+				length[nPart-1][v] = 0
+			} else {
+				length[nPart-1][v] = 15
+			}
+		}
+		gs = ge + 1
+		remF -= aFreq
+	}
+
+	selectors := make([]uint8, 0, (nMTF+bzGroupSize-1)/bzGroupSize)
+	for iter := 0; iter < bzIters; iter++ {
+		var rfreq [6][258]int32
+		selectors = selectors[:0]
+		for gs := 0; gs < nMTF; gs += bzGroupSize {
+			ge := gs + bzGroupSize
+			if ge > nMTF {
+				ge = nMTF
+			}
+			bt, bc := 0, int(^uint(0)>>1)
+			for t := 0; t < nGroups; t++ {
+				cost := 0
+				for _, v := range mtfv[gs:ge] {
+					cost += int(length[t][v])
+				}
+				if cost < bc {
+					bt, bc = t, cost
+				}
+			}
+			selectors = append(selectors, uint8(bt))
+			for _, v := range mtfv[gs:ge] {
+				rfreq[bt][v]++
+			}
+		}
+		for t := 0; t < nGroups; t++ {
+			bzMakeCodeLengths(length[t][:alphaSize], rfreq[t][:alphaSize], bzMaxCodeLen)
+		}
+	}
+
+	var code [6][258]uint32
+	for t := 0; t < nGroups; t++ {
+		bzAssignCodes(code[t][:alphaSize], length[t][:alphaSize])
+	}
+
+	// Symbol map: which of the 16 ranges of 16 bytes are used, then which
+	// bytes within the used ranges.
+	var inUse16 [16]bool
+	for i, used := range bw.inUse {
+		if used {
+			inUse16[i/16] = true
+		}
+	}
+	for _, used := range inUse16 {
+		bw.bs.putBool(used)
+	}
+	for i, used := range inUse16 {
+		if used {
+			for j := 0; j < 16; j++ {
+				bw.bs.putBool(bw.inUse[i*16+j])
+			}
+		}
+	}
+
+	// Selectors, move-to-front coded in unary.
+	bw.bs.put(3, uint32(nGroups))
+	bw.bs.put(15, uint32(len(selectors)))
+	pos := [6]uint8{0, 1, 2, 3, 4, 5}
+	for _, s := range selectors {
+		j := 0
+		for pos[j] != s {
+			j++
+		}
+		copy(pos[1:j+1], pos[:j])
+		pos[0] = s
+		for ; j > 0; j-- {
+			bw.bs.put(1, 1)
+		}
+		bw.bs.put(1, 0)
+	}
+
+	// Code lengths, delta coded.
+	for t := 0; t < nGroups; t++ {
+		curr := length[t][0]
+		bw.bs.put(5, uint32(curr))
+		for _, l := range length[t][:alphaSize] {
+			for ; curr < l; curr++ {
+				bw.bs.put(2, 2)
+			}
+			for ; curr > l; curr-- {
+				bw.bs.put(2, 3)
+			}
+			bw.bs.put(1, 0)
+		}
+	}
+
+	for i, s := range selectors {
+		group := mtfv[i*bzGroupSize:]
+		if len(group) > bzGroupSize {
+			group = group[:bzGroupSize]
+		}
+		for _, v := range group {
+			bw.bs.put(uint(length[s][v]), code[s][v])
+		}
+	}
+}
+
+// bzMakeCodeLengths computes Huffman code lengths for freq, limited to
+// maxLen bits by repeatedly flattening the frequencies, exactly as
+// BZ2_hbMakeCodeLengths does (ties are broken by tree depth).
+func bzMakeCodeLengths(length []uint8, freq []int32, maxLen int) {
+	alphaSize := len(freq)
+	heap := make([]int32, alphaSize+2)
+	weight := make([]int32, alphaSize*2)
+	parent := make([]int32, alphaSize*2)
+
+	for i, f := range freq {
+		if f == 0 {
+			f = 1
+		}
+		weight[i+1] = f << 8
+	}
+
+	for {
+		nNodes, nHeap := alphaSize, 0
+		heap[0], weight[0], parent[0] = 0, 0, -2
+
+		upHeap := func(z int) {
+			tmp := heap[z]
+			for weight[tmp] < weight[heap[z>>1]] {
+				heap[z] = heap[z>>1]
+				z >>= 1
+			}
+			heap[z] = tmp
+		}
+		downHeap := func(z int) {
+			tmp := heap[z]
+			for {
+				y := z << 1
+				if y > nHeap {
+					break
+				}
+				if y < nHeap && weight[heap[y+1]] < weight[heap[y]] {
+					y++
+				}
+				if weight[tmp] < weight[heap[y]] {
+					break
+				}
+				heap[z] = heap[y]
+				z = y
+			}
+			heap[z] = tmp
+		}
+
+		for i := 1; i <= alphaSize; i++ {
+			parent[i] = -1
+			nHeap++
+			heap[nHeap] = int32(i)
+			upHeap(nHeap)
+		}
+		for nHeap > 1 {
+			n1 := heap[1]
+			heap[1] = heap[nHeap]
+			nHeap--
+			downHeap(1)
+			n2 := heap[1]
+			heap[1] = heap[nHeap]
+			nHeap--
+			downHeap(1)
+
+			nNodes++
+			parent[n1], parent[n2] = int32(nNodes), int32(nNodes)
+			w1, w2 := weight[n1], weight[n2]
+			depth := w1 & 0xff
+			if w2&0xff > depth {
+				depth = w2 & 0xff
+			}
+			weight[nNodes] = (w1&^0xff + w2&^0xff) | (1 + depth)
+			parent[nNodes] = -1
+			nHeap++
+			heap[nHeap] = int32(nNodes)
+			upHeap(nHeap)
+		}
+
+		tooLong := false
+		for i := 1; i <= alphaSize; i++ {
+			j := 0
+			for k := i; parent[k] >= 0; k = int(parent[k]) {
+				j++
+			}
+			length[i-1] = uint8(j)
+			if j > maxLen {
+				tooLong = true
+			}
+		}
+		if !tooLong {
+			return
+		}
+		for i := 1; i <= alphaSize; i++ {
+			j := weight[i] >> 8
+			weight[i] = (1 + j/2) << 8
+		}
+	}
+}
+
+// bzAssignCodes assigns canonical codes in order of length, then symbol.
+func bzAssignCodes(code []uint32, length []uint8) {
+	minLen, maxLen := uint8(32), uint8(0)
+	for _, l := range length {
+		if l < minLen {
+			minLen = l
+		}
+		if l > maxLen {
+			maxLen = l
+		}
+	}
+	vec := uint32(0)
+	for n := minLen; n <= maxLen; n++ {
+		for i, l := range length {
+			if l == n {
+				code[i] = vec
+				vec++
+			}
+		}
+		vec <<= 1
+	}
+}
+
+// bwtSort returns the start positions of the rotations of block in
+// sorted order, using prefix doubling with counting sorts.
+func bwtSort(block []byte) []int32 {
+	n := len(block)
+	sa := make([]int32, n)
+	rank := make([]int32, n)
+	tmp := make([]int32, n)
+	cnt := make([]int32, n+257)
+
+	for _, c := range block {
+		cnt[int(c)+1]++
+	}
+	for i := 1; i <= 256; i++ {
+		cnt[i] += cnt[i-1]
+	}
+	for i, c := range block {
+		sa[cnt[c]] = int32(i)
+		cnt[c]++
+	}
+	classes := int32(0)
+	for i := range sa {
+		if i > 0 && block[sa[i]] != block[sa[i-1]] {
+			classes++
+		}
+		rank[sa[i]] = classes
+	}
+	classes++
+
+	for k := 1; int(classes) < n && k < n; k *= 2 {
+		// Order by the second half: rotation i-k in the order of i.
+		for i, p := range sa {
+			q := int(p) - k
+			if q < 0 {
+				q += n
+			}
+			tmp[i] = int32(q)
+		}
+		// Stable counting sort by the first half.
+		for i := range cnt[:classes+1] {
+			cnt[i] = 0
+		}
+		for _, r := range rank {
+			cnt[r+1]++
+		}
+		for i := int32(1); i <= classes; i++ {
+			cnt[i] += cnt[i-1]
+		}
+		for _, p := range tmp {
+			r := rank[p]
+			sa[cnt[r]] = p
+			cnt[r]++
+		}
+		// New ranks from pairs (rank[i], rank[i+k]).
+		second := func(p int32) int32 {
+			q := int(p) + k
+			if q >= n {
+				q -= n
+			}
+			return rank[q]
+		}
+		classes = 0
+		tmp[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			a, b := sa[i-1], sa[i]
+			if rank[a] != rank[b] || second(a) != second(b) {
+				classes++
+			}
+			tmp[b] = classes
+		}
+		classes++
+		rank, tmp = tmp, rank
+	}
+	return sa
+}
+
+var bzCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+func bzCRCUpdate(crc uint32, b byte) uint32 {
+	return crc<<8 ^ bzCRCTable[byte(crc>>24)^b]
+}
+
+// bitWriter collects bits most significant first.
+type bitWriter struct {
+	out   []byte
+	buf   uint64
+	nbits uint
+}
+
+func (b *bitWriter) put(n uint, v uint32) {
+	b.buf = b.buf<<n | uint64(v)&(1<<n-1)
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.nbits -= 8
+		b.out = append(b.out, byte(b.buf>>b.nbits))
+	}
+}
+
+func (b *bitWriter) putBool(v bool) {
+	if v {
+		b.put(1, 1)
+	} else {
+		b.put(1, 0)
+	}
+}
+
+func (b *bitWriter) putBytes(bs ...byte) {
+	for _, c := range bs {
+		b.put(8, uint32(c))
+	}
+}
+
+func (b *bitWriter) putUint32(v uint32) {
+	b.put(16, v>>16)
+	b.put(16, v&0xffff)
+}
+
+func (b *bitWriter) finish() {
+	if b.nbits > 0 {
+		b.put(8-b.nbits, 0)
+	}
+}
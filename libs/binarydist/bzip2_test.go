@@ -0,0 +1,86 @@
+package binarydist
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+)
+
+var bzip2T = [][]byte{
+	nil,
+	[]byte("a"),
+	[]byte("abcdefabcdef"),
+	bytes.Repeat([]byte("ab"), 5000),
+	bytes.Repeat([]byte{0}, 3e6),
+	mustRandBytes(2e6), // more than one block
+	mustReadAll(mustOpen("testdata/sample.old")),
+	bytes.Repeat(mustReadAll(mustOpen("diff.go")), 100),
+}
+
+func bzip2Bytes(b []byte, chunk int) []byte {
+	var buf bytes.Buffer
+	w, err := newBzip2Writer(&buf)
+	if err != nil {
+		panic(err)
+	}
+	for len(b) > 0 {
+		n := chunk
+		if n > len(b) {
+			n = len(b)
+		}
+		if _, err := w.Write(b[:n]); err != nil {
+			panic(err)
+		}
+		b = b[n:]
+	}
+	if err := w.Close(); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBzip2RoundTrip(t *testing.T) {
+	for i, s := range bzip2T {
+		z := bzip2Bytes(s, 1<<20)
+		got, err := ioutil.ReadAll(bzip2.NewReader(bytes.NewReader(z)))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !bytes.Equal(got, s) {
+			t.Fatalf("%d: round trip produced different output", i)
+		}
+		if other := bzip2Bytes(s, 777); !bytes.Equal(other, z) {
+			t.Fatalf("%d: output depends on write sizes", i)
+		}
+	}
+}
+
+// sample.new.bz2 was written by `bzip2 -c` (libbzip2 1.0.8).
+func TestBzip2Reference(t *testing.T) {
+	got := bzip2Bytes(mustReadAll(mustOpen("testdata/sample.new")), 4096)
+	exp := mustReadAll(mustOpen("testdata/sample.new.bz2"))
+	if !bytes.Equal(got, exp) {
+		t.Fatalf("produced different output at pos %d", matchlen(got, exp))
+	}
+}
+
+func TestBzip2Command(t *testing.T) {
+	if _, err := exec.LookPath("bzip2"); err != nil {
+		t.Skip("bzip2 not found")
+	}
+	// Periodic blocks may order their identical rotations differently.
+	for _, i := range []int{0, 1, 5, 6} {
+		s := bzip2T[i]
+		cmd := exec.Command("bzip2", "-c")
+		cmd.Stdin = bytes.NewReader(s)
+		exp, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bzip2Bytes(s, 4096); !bytes.Equal(got, exp) {
+			t.Errorf("%d: produced different output at pos %d", i, matchlen(got, exp))
+		}
+	}
+}
@@ -4,152 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
-	"io/ioutil"
 )
 
-func swap(a []int, i, j int) { a[i], a[j] = a[j], a[i] }
-
-func split(I, V []int, start, length, h int) {
-	var i, j, k, x, jj, kk int
-
-	if length < 16 {
-		for k = start; k < start+length; k += j {
-			j = 1
-			x = V[I[k]+h]
-			for i = 1; k+i < start+length; i++ {
-				if V[I[k+i]+h] < x {
-					x = V[I[k+i]+h]
-					j = 0
-				}
-				if V[I[k+i]+h] == x {
-					swap(I, k+i, k+j)
-					j++
-				}
-			}
-			for i = 0; i < j; i++ {
-				V[I[k+i]] = k + j - 1
-			}
-			if j == 1 {
-				I[k] = -1
-			}
-		}
-		return
-	}
-
-	x = V[I[start+length/2]+h]
-	jj = 0
-	kk = 0
-	for i = start; i < start+length; i++ {
-		if V[I[i]+h] < x {
-			jj++
-		}
-		if V[I[i]+h] == x {
-			kk++
-		}
-	}
-	jj += start
-	kk += jj
-
-	i = start
-	j = 0
-	k = 0
-	for i < jj {
-		if V[I[i]+h] < x {
-			i++
-		} else if V[I[i]+h] == x {
-			swap(I, i, jj+j)
-			j++
-		} else {
-			swap(I, i, kk+k)
-			k++
-		}
-	}
-
-	for jj+j < kk {
-		if V[I[jj+j]+h] == x {
-			j++
-		} else {
-			swap(I, jj+j, kk+k)
-			k++
-		}
-	}
-
-	if jj > start {
-		split(I, V, start, jj-start, h)
-	}
-
-	for i = 0; i < kk-jj; i++ {
-		V[I[jj+i]] = kk - 1
-	}
-	if jj == kk-1 {
-		I[jj] = -1
-	}
-
-	if start+length > kk {
-		split(I, V, kk, start+length-kk, h)
-	}
-}
-
-func qsufsort(obuf []byte) []int {
-	var buckets [256]int
-	var i, h int
-	I := make([]int, len(obuf)+1)
-	V := make([]int, len(obuf)+1)
-
-	for _, c := range obuf {
-		buckets[c]++
-	}
-	for i = 1; i < 256; i++ {
-		buckets[i] += buckets[i-1]
-	}
-	copy(buckets[1:], buckets[:])
-	buckets[0] = 0
-
-	for i, c := range obuf {
-		buckets[c]++
-		I[buckets[c]] = i
-	}
-
-	I[0] = len(obuf)
-	for i, c := range obuf {
-		V[i] = buckets[c]
-	}
-
-	V[len(obuf)] = 0
-	for i = 1; i < 256; i++ {
-		if buckets[i] == buckets[i-1]+1 {
-			I[buckets[i]] = -1
-		}
-	}
-	I[0] = -1
-
-	for h = 1; I[0] != -(len(obuf) + 1); h += h {
-		var n int
-		for i = 0; i < len(obuf)+1; {
-			if I[i] < 0 {
-				n -= I[i]
-				i -= I[i]
-			} else {
-				if n != 0 {
-					I[i-n] = -n
-				}
-				n = V[I[i]] + 1 - i
-				split(I, V, i, n, h)
-				i += n
-				n = 0
-			}
-		}
-		if n != 0 {
-			I[i-n] = -n
-		}
-	}
-
-	for i = 0; i < len(obuf)+1; i++ {
-		I[V[i]] = i
-	}
-	return I
-}
-
 func matchlen(a, b []byte) (i int) {
 	for i < len(a) && i < len(b) && a[i] == b[i] {
 		i++
@@ -157,15 +13,15 @@ func matchlen(a, b []byte) (i int) {
 	return i
 }
 
-func search(I []int, obuf, nbuf []byte, st, en int) (pos, n int) {
+func search(I []int32, obuf, nbuf []byte, st, en int) (pos, n int) {
 	if en-st < 2 {
 		x := matchlen(obuf[I[st]:], nbuf)
 		y := matchlen(obuf[I[en]:], nbuf)
 
 		if x > y {
-			return I[st], x
+			return int(I[st]), x
 		} else {
-			return I[en], y
+			return int(I[en]), y
 		}
 	}
 
@@ -178,57 +34,159 @@ func search(I []int, obuf, nbuf []byte, st, en int) (pos, n int) {
 	panic("unreached")
 }
 
+// Diffing works on windows so that memory use depends on these sizes
+// rather than on the file sizes: every diffWindow bytes of the new file
+// are diffed against the old data around the same relative offset, with
+// diffWindowPad bytes of slack on either side. When both files fit in a
+// single window the patch is exactly that of the classic bsdiff.
+var (
+	diffWindow    int64 = 16 << 20
+	diffWindowPad int64 = 8 << 20
+)
+
 // Diff computes the difference between old and new, according to the bsdiff
 // algorithm, and writes the result to patch.
+//
+// old and new are read window by window through io.ReaderAt when they
+// implement it together with io.Seeker (as *os.File does); other readers
+// are spooled to a temporary file first.
 func Diff(old, new io.Reader, patch io.Writer) error {
-	obuf, err := ioutil.ReadAll(old)
+	oldAt, oldSize, closeOld, err := openReaderAt(old)
 	if err != nil {
 		return err
 	}
+	defer closeOld()
 
-	nbuf, err := ioutil.ReadAll(new)
+	newAt, newSize, closeNew, err := openReaderAt(new)
 	if err != nil {
 		return err
 	}
+	defer closeNew()
 
-	pbuf, err := diffBytes(obuf, nbuf)
-	if err != nil {
+	// The three blocks are compressed separately; ctrl and diff are only
+	// complete at the end, so all of them are spooled before writing.
+	var ctrl, db, eb spool
+	defer ctrl.Close()
+	defer db.Close()
+	defer eb.Close()
+
+	d := &differ{}
+	if d.ctrl, err = newBzip2Writer(&ctrl); err != nil {
+		return err
+	}
+	if d.db, err = newBzip2Writer(&db); err != nil {
+		return err
+	}
+	if d.eb, err = newBzip2Writer(&eb); err != nil {
 		return err
 	}
 
-	_, err = patch.Write(pbuf)
-	return err
-}
+	windows := (newSize + diffWindow - 1) / diffWindow
+	if windows == 0 {
+		windows = 1
+	}
+	var obuf, nbuf []byte
+	var I []int32
+	oldLo, oldHi := int64(-1), int64(-1)
+	for w := int64(0); w < windows; w++ {
+		newLo := w * diffWindow
+		newHi := newLo + diffWindow
+		if newHi > newSize {
+			newHi = newSize
+		}
 
-func diffBytes(obuf, nbuf []byte) ([]byte, error) {
-	var patch seekBuffer
-	err := diff(obuf, nbuf, &patch)
-	if err != nil {
-		return nil, err
+		lo, hi := oldWindow(newLo, oldSize, newSize)
+		if lo != oldLo || hi != oldHi {
+			if obuf, err = readWindow(oldAt, obuf, lo, hi); err != nil {
+				return err
+			}
+			I = nil // let the previous suffix array go before building the next
+			I = suffixArray(obuf)
+			oldLo, oldHi = lo, hi
+		}
+		if nbuf, err = readWindow(newAt, nbuf, newLo, newHi); err != nil {
+			return err
+		}
+
+		d.oldBase, d.nextBase = lo, -1
+		if w+1 < windows {
+			d.nextBase, _ = oldWindow(newHi, oldSize, newSize)
+		}
+		if err = d.diff(obuf, nbuf, I); err != nil {
+			return err
+		}
 	}
-	return patch.buf, nil
-}
 
-func diff(obuf, nbuf []byte, patch io.WriteSeeker) error {
-	var lenf int
-	I := qsufsort(obuf)
-	db := make([]byte, len(nbuf))
-	eb := make([]byte, len(nbuf))
-	var dblen, eblen int
+	for _, c := range []io.Closer{d.ctrl, d.db, d.eb} {
+		if err = c.Close(); err != nil {
+			return err
+		}
+	}
 
-	var hdr header
-	hdr.Magic = magic
-	hdr.NewSize = int64(len(nbuf))
-	err := binary.Write(patch, signMagLittleEndian{}, &hdr)
-	if err != nil {
+	hdr := header{
+		Magic:   magic,
+		CtrlLen: ctrl.size,
+		DiffLen: db.size,
+		NewSize: newSize,
+	}
+	if err = binary.Write(patch, signMagLittleEndian{}, &hdr); err != nil {
 		return err
 	}
+	for _, s := range []*spool{&ctrl, &db, &eb} {
+		if _, err = s.WriteTo(patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldWindow returns the part of old that the window of new starting at
+// newLo is diffed against.
+func oldWindow(newLo, oldSize, newSize int64) (lo, hi int64) {
+	span := diffWindow + 2*diffWindowPad
+	if oldSize <= span {
+		return 0, oldSize
+	}
+	lo = int64(float64(newLo)*(float64(oldSize)/float64(newSize))) - diffWindowPad
+	if lo > oldSize-span {
+		lo = oldSize - span
+	}
+	if lo < 0 {
+		lo = 0
+	}
+	return lo, lo + span
+}
 
-	// Compute the differences, writing ctrl as we go
-	pfbz2, err := newBzip2Writer(patch)
-	if err != nil {
-		return err
+func readWindow(r io.ReaderAt, buf []byte, lo, hi int64) ([]byte, error) {
+	n := int(hi - lo)
+	if cap(buf) < n {
+		buf = make([]byte, n)
 	}
+	buf = buf[:n]
+	if m, err := r.ReadAt(buf, lo); m < n {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// differ writes the ctrl, diff and extra blocks of one patch, window by
+// window.
+type differ struct {
+	ctrl, db, eb io.WriteCloser
+
+	// oldBase is the offset of the current old window in the old file;
+	// the last ctrl triple of a window seeks to nextBase, where the next
+	// window starts, or is left as bsdiff computes it for the last one.
+	oldBase, nextBase int64
+
+	scratch []byte
+}
+
+func (d *differ) diff(obuf, nbuf []byte, I []int32) error {
+	var lenf int
 	var scan, pos, length int
 	var lastscan, lastpos, lastoffset int
 	for scan < len(nbuf) {
@@ -303,33 +261,26 @@ func diff(obuf, nbuf []byte, patch io.WriteSeeker) error {
 				lenb -= lens
 			}
 
-			for i := 0; i < lenf; i++ {
-				db[dblen+i] = nbuf[lastscan+i] - obuf[lastpos+i]
+			if cap(d.scratch) < lenf {
+				d.scratch = make([]byte, lenf)
 			}
-			for i := 0; i < (scan-lenb)-(lastscan+lenf); i++ {
-				eb[eblen+i] = nbuf[lastscan+lenf+i]
+			db := d.scratch[:lenf]
+			for i := range db {
+				db[i] = nbuf[lastscan+i] - obuf[lastpos+i]
 			}
-
-			dblen += lenf
-			eblen += (scan - lenb) - (lastscan + lenf)
-
-			err = binary.Write(pfbz2, signMagLittleEndian{}, int64(lenf))
-			if err != nil {
-				pfbz2.Close()
+			if _, err := d.db.Write(db); err != nil {
 				return err
 			}
-
-			val := (scan - lenb) - (lastscan + lenf)
-			err = binary.Write(pfbz2, signMagLittleEndian{}, int64(val))
-			if err != nil {
-				pfbz2.Close()
+			if _, err := d.eb.Write(nbuf[lastscan+lenf : scan-lenb]); err != nil {
 				return err
 			}
 
-			val = (pos - lenb) - (lastpos + lenf)
-			err = binary.Write(pfbz2, signMagLittleEndian{}, int64(val))
-			if err != nil {
-				pfbz2.Close()
+			seek := int64((pos - lenb) - (lastpos + lenf))
+			if scan == len(nbuf) && d.nextBase >= 0 {
+				seek = d.nextBase - (d.oldBase + int64(lastpos+lenf))
+			}
+			ctrl := [3]int64{int64(lenf), int64((scan - lenb) - (lastscan + lenf)), seek}
+			if err := binary.Write(d.ctrl, signMagLittleEndian{}, ctrl); err != nil {
 				return err
 			}
 
@@ -338,71 +289,5 @@ func diff(obuf, nbuf []byte, patch io.WriteSeeker) error {
 			lastoffset = pos - scan
 		}
 	}
-	err = pfbz2.Close()
-	if err != nil {
-		return err
-	}
-
-	// Compute size of compressed ctrl data
-	l64, err := patch.Seek(0, 1)
-	if err != nil {
-		return err
-	}
-	hdr.CtrlLen = int64(l64 - 32)
-
-	// Write compressed diff data
-	pfbz2, err = newBzip2Writer(patch)
-	if err != nil {
-		return err
-	}
-	n, err := pfbz2.Write(db[:dblen])
-	if err != nil {
-		pfbz2.Close()
-		return err
-	}
-	if n != dblen {
-		pfbz2.Close()
-		return io.ErrShortWrite
-	}
-	err = pfbz2.Close()
-	if err != nil {
-		return err
-	}
-
-	// Compute size of compressed diff data
-	n64, err := patch.Seek(0, 1)
-	if err != nil {
-		return err
-	}
-	hdr.DiffLen = n64 - l64
-
-	// Write compressed extra data
-	pfbz2, err = newBzip2Writer(patch)
-	if err != nil {
-		return err
-	}
-	n, err = pfbz2.Write(eb[:eblen])
-	if err != nil {
-		pfbz2.Close()
-		return err
-	}
-	if n != eblen {
-		pfbz2.Close()
-		return io.ErrShortWrite
-	}
-	err = pfbz2.Close()
-	if err != nil {
-		return err
-	}
-
-	// Seek to the beginning, write the header, and close the file
-	_, err = patch.Seek(0, 0)
-	if err != nil {
-		return err
-	}
-	err = binary.Write(patch, signMagLittleEndian{}, &hdr)
-	if err != nil {
-		return err
-	}
 	return nil
 }
@@ -3,6 +3,7 @@ package binarydist
 import (
 	"bytes"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"testing"
@@ -65,3 +66,108 @@ func TestDiff(t *testing.T) {
 		}
 	}
 }
+
+// TestDiffSample checks Diff against a patch written by the reference
+// bsdiff, which needs no external tools now that bzip2 is built in.
+func TestDiffSample(t *testing.T) {
+	got, err := ioutil.TempFile("/tmp", "bspatch.")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(got.Name())
+
+	err = Diff(mustOpen("testdata/sample.old"), mustOpen("testdata/sample.new"), got)
+	if err != nil {
+		t.Fatal("err", err)
+	}
+
+	if n := fileCmp(got, mustOpen("testdata/sample.patch")); n > -1 {
+		t.Fatalf("produced different output at pos %d", n)
+	}
+}
+
+// TestDiffWindows diffs files larger than the diff window, so the old
+// file is searched window by window and the blocks are spooled to disk.
+func TestDiffWindows(t *testing.T) {
+	defer func(w, p int64, m int) { diffWindow, diffWindowPad, spoolMemory = w, p, m }(diffWindow, diffWindowPad, spoolMemory)
+	diffWindow, diffWindowPad, spoolMemory = 8<<10, 4<<10, 16<<10
+
+	r := rand.New(rand.NewSource(3))
+	obuf := make([]byte, 200<<10)
+	r.Read(obuf)
+	nbuf := append([]byte(nil), obuf[:50<<10]...)
+	nbuf = append(nbuf, []byte("inserted in the middle")...)
+	nbuf = append(nbuf, obuf[50<<10:120<<10]...)
+	nbuf = append(nbuf, obuf[125<<10:]...)
+	for i := 0; i < 100; i++ {
+		nbuf[r.Intn(len(nbuf))]++
+	}
+
+	var patch bytes.Buffer
+	if err := Diff(bytes.NewReader(obuf), bytes.NewReader(nbuf), &patch); err != nil {
+		t.Fatal(err)
+	}
+	if patch.Len() > len(nbuf)/10 {
+		t.Errorf("patch is %d bytes for %d bytes of mostly old data", patch.Len(), len(nbuf))
+	}
+
+	var got bytes.Buffer
+	if err := Patch(bytes.NewReader(obuf), &got, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), nbuf) {
+		t.Fatalf("produced different output at pos %d", matchlen(got.Bytes(), nbuf))
+	}
+}
+
+func benchmarkData(size int) (old, new []byte) {
+	r := rand.New(rand.NewSource(1))
+	old = make([]byte, size)
+	r.Read(old)
+	new = append([]byte(nil), old...)
+	for i := 0; i < size/1000; i++ {
+		new[r.Intn(size)]++
+	}
+	return old, new
+}
+
+func BenchmarkSuffixArray(b *testing.B) {
+	random, _ := benchmarkData(4 << 20)
+	text := bytes.Repeat(mustReadAll(mustOpen("diff.go")), (4<<20)/len(mustReadAll(mustOpen("diff.go"))))
+	for _, bc := range []struct {
+		name string
+		data []byte
+	}{{"random", random}, {"text", text}} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(bc.data)))
+			for i := 0; i < b.N; i++ {
+				suffixArray(bc.data)
+			}
+		})
+	}
+}
+
+func BenchmarkDiff(b *testing.B) {
+	old, new := benchmarkData(4 << 20)
+	b.SetBytes(int64(len(new)))
+	for i := 0; i < b.N; i++ {
+		if err := Diff(bytes.NewReader(old), bytes.NewReader(new), ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPatch(b *testing.B) {
+	old, new := benchmarkData(4 << 20)
+	var patch bytes.Buffer
+	if err := Diff(bytes.NewReader(old), bytes.NewReader(new), &patch); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(new)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := Patch(bytes.NewReader(old), ioutil.Discard, bytes.NewReader(patch.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
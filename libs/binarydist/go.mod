@@ -1 +1,3 @@
 module "github.com/kr/binarydist"
+
+go 1.21
@@ -0,0 +1,256 @@
+package binarydist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+var multiMagic = [8]byte{'B', 'S', 'D', 'I', 'F', 'F', 'M', 'B'}
+
+// multiVersion is the container version written by DiffParallel.
+const multiVersion = 1
+
+// diffBlockMin bounds the block count Patch accepts for a given new size,
+// so a corrupt header cannot make it allocate a huge block table.
+const diffBlockMin = 1 << 10
+
+// ErrOldSize is returned by Patch when a multi-block patch was made
+// against an old file of a different size.
+var ErrOldSize = errors.New("old file size does not match patch")
+
+// Multi-block container format:
+//
+//	0       8    "BSDIFFMB"
+//	8       8    version (1)
+//	16      8    sizeof(oldfile)
+//	24      8    sizeof(newfile)
+//	32      8    N, the number of blocks
+//	40      32N  block table
+//	40+32N  ???  the block patches, one after another
+//
+// with each table entry (o,l,n,p) meaning "the next n bytes of newfile are
+// the BSDIFF40 patch of the next p bytes applied to oldfile[o:o+l]".
+// Blocks are independent, so they can be diffed and patched concurrently.
+type multiHeader struct {
+	Magic   [8]byte
+	Version int64
+	OldSize int64
+	NewSize int64
+	Blocks  int64
+}
+
+type blockEntry struct {
+	OldOff, OldLen, NewLen, PatchLen int64
+}
+
+// IsPatch reports whether head, the start of a file, looks like a patch
+// that Patch can apply: a classic BSDIFF40 patch or a multi-block one.
+func IsPatch(head []byte) bool {
+	return len(head) >= 8 && (string(head[:8]) == string(magic[:]) || string(head[:8]) == string(multiMagic[:]))
+}
+
+// DiffParallel is like Diff, but splits new into blocks of the diff window
+// size that are diffed concurrently, each against the part of old around
+// the same relative offset, and writes them into a multi-block container.
+// workers limits the number of blocks in progress; each one holds its own
+// windows and suffix array in memory. If workers <= 0, GOMAXPROCS is used.
+func DiffParallel(old, new io.Reader, patch io.Writer, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	oldAt, oldSize, closeOld, err := openReaderAt(old)
+	if err != nil {
+		return err
+	}
+	defer closeOld()
+
+	newAt, newSize, closeNew, err := openReaderAt(new)
+	if err != nil {
+		return err
+	}
+	defer closeNew()
+
+	n := (newSize + diffWindow - 1) / diffWindow
+	if n == 0 {
+		n = 1
+	}
+	table := make([]blockEntry, n)
+
+	// The block patches are collected in order in one spool, since the
+	// table in front of them needs their sizes. Blocks are diffed in
+	// batches of workers so the output does not depend on timing.
+	var body spool
+	defer body.Close()
+	for lo := int64(0); lo < n; lo += int64(workers) {
+		hi := lo + int64(workers)
+		if hi > n {
+			hi = n
+		}
+		parts := make([]spool, hi-lo)
+		errs := make([]error, hi-lo)
+		var wg sync.WaitGroup
+		for b := lo; b < hi; b++ {
+			newLo := b * diffWindow
+			newLen := min64(diffWindow, newSize-newLo)
+			oldLo, oldHi := oldWindow(newLo, oldSize, newSize)
+			table[b] = blockEntry{OldOff: oldLo, OldLen: oldHi - oldLo, NewLen: newLen}
+
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = Diff(
+					io.NewSectionReader(oldAt, oldLo, oldHi-oldLo),
+					io.NewSectionReader(newAt, newLo, newLen),
+					&parts[i],
+				)
+			}(int(b - lo))
+		}
+		wg.Wait()
+
+		for i := range parts {
+			if err == nil {
+				err = errs[i]
+			}
+			if err == nil {
+				table[lo+int64(i)].PatchLen = parts[i].size
+				_, err = parts[i].WriteTo(&body)
+			}
+			parts[i].Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	hdr := multiHeader{
+		Magic:   multiMagic,
+		Version: multiVersion,
+		OldSize: oldSize,
+		NewSize: newSize,
+		Blocks:  n,
+	}
+	if err = binary.Write(patch, signMagLittleEndian{}, &hdr); err != nil {
+		return err
+	}
+	if err = binary.Write(patch, signMagLittleEndian{}, table); err != nil {
+		return err
+	}
+	_, err = body.WriteTo(patch)
+	return err
+}
+
+// patchMulti applies a multi-block patch whose magic has been read. The
+// blocks are applied concurrently when new implements io.WriterAt (as
+// *os.File does) and one after another otherwise.
+func patchMulti(old io.Reader, new io.Writer, patch io.Reader) error {
+	var hdr struct{ Version, OldSize, NewSize, Blocks int64 }
+	err := binary.Read(patch, signMagLittleEndian{}, &hdr)
+	if err != nil {
+		return err
+	}
+	if hdr.Version != multiVersion {
+		return fmt.Errorf("binarydist: unsupported multi-block patch version %d", hdr.Version)
+	}
+	if hdr.OldSize < 0 || hdr.NewSize < 0 || hdr.Blocks < 1 || hdr.Blocks > hdr.NewSize/diffBlockMin+1 {
+		return ErrCorrupt
+	}
+	table := make([]blockEntry, hdr.Blocks)
+	if err = binary.Read(patch, signMagLittleEndian{}, table); err != nil {
+		return err
+	}
+
+	oldAt, oldSize, closeOld, err := openReaderAt(old)
+	if err != nil {
+		return err
+	}
+	defer closeOld()
+	if oldSize != hdr.OldSize {
+		return ErrOldSize
+	}
+
+	newOff := make([]int64, len(table))
+	patchOff := make([]int64, len(table))
+	var nsum, psum int64
+	for i, e := range table {
+		if e.OldOff < 0 || e.OldLen < 0 || e.OldOff > oldSize-e.OldLen || e.NewLen < 0 || e.PatchLen < 0 {
+			return ErrCorrupt
+		}
+		newOff[i], patchOff[i] = nsum, psum
+		nsum += e.NewLen
+		psum += e.PatchLen
+		if nsum > hdr.NewSize || psum < 0 {
+			return ErrCorrupt
+		}
+	}
+	if nsum != hdr.NewSize {
+		return ErrCorrupt
+	}
+
+	patchAt, patchSize, closePatch, err := openReaderAt(patch)
+	if err != nil {
+		return err
+	}
+	defer closePatch()
+	if patchSize != psum {
+		return ErrCorrupt
+	}
+
+	apply := func(i int, w io.Writer) error {
+		e := table[i]
+		pr := io.NewSectionReader(patchAt, patchOff[i], e.PatchLen)
+		var m [8]byte
+		if _, err := io.ReadFull(pr, m[:]); err != nil || m != magic {
+			return ErrCorrupt
+		}
+		return patchBlock(io.NewSectionReader(oldAt, e.OldOff, e.OldLen), w, pr, e.NewLen)
+	}
+
+	wa, ok := new.(io.WriterAt)
+	if !ok {
+		for i := range table {
+			if err = apply(i, new); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	jobs := make(chan int)
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if err := apply(i, io.NewOffsetWriter(wa, newOff[i])); err != nil {
+					mu.Lock()
+					if first == nil {
+						first = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range table {
+		mu.Lock()
+		failed := first != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,104 @@
+package binarydist
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func parallelData() (old, new []byte) {
+	r := rand.New(rand.NewSource(4))
+	old = make([]byte, 100<<10)
+	r.Read(old)
+	new = append([]byte(nil), old[:30<<10]...)
+	new = append(new, []byte("inserted between the blocks")...)
+	new = append(new, old[32<<10:]...)
+	for i := 0; i < 50; i++ {
+		new[r.Intn(len(new))]++
+	}
+	return old, new
+}
+
+func TestDiffParallel(t *testing.T) {
+	defer func(w, p int64) { diffWindow, diffWindowPad = w, p }(diffWindow, diffWindowPad)
+	diffWindow, diffWindowPad = 8<<10, 4<<10
+	old, new := parallelData()
+
+	var patch bytes.Buffer
+	if err := DiffParallel(bytes.NewReader(old), bytes.NewReader(new), &patch, 4); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPatch(patch.Bytes()) || !bytes.HasPrefix(patch.Bytes(), multiMagic[:]) {
+		t.Fatal("DiffParallel did not write a multi-block patch")
+	}
+	var other bytes.Buffer
+	if err := DiffParallel(bytes.NewReader(old), bytes.NewReader(new), &other, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patch.Bytes(), other.Bytes()) {
+		t.Fatal("output depends on the number of workers")
+	}
+
+	// Sequentially into a buffer.
+	var got bytes.Buffer
+	if err := Patch(bytes.NewReader(old), &got, bytes.NewReader(patch.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), new) {
+		t.Fatalf("produced different output at pos %d", matchlen(got.Bytes(), new))
+	}
+
+	// Concurrently into a file.
+	f, err := ioutil.TempFile("", "bspatch.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := Patch(bytes.NewReader(old), f, bytes.NewReader(patch.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if b := mustReadAll(f); !bytes.Equal(b, new) {
+		t.Fatalf("produced different output at pos %d", matchlen(b, new))
+	}
+}
+
+func TestDiffParallelEmpty(t *testing.T) {
+	var patch bytes.Buffer
+	if err := DiffParallel(bytes.NewReader(nil), bytes.NewReader(nil), &patch, 0); err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err := Patch(bytes.NewReader(nil), &got, &patch); err != nil {
+		t.Fatal(err)
+	}
+	if got.Len() != 0 {
+		t.Fatalf("got %d bytes, want 0", got.Len())
+	}
+}
+
+func TestPatchMultiCorrupt(t *testing.T) {
+	defer func(w, p int64) { diffWindow, diffWindowPad = w, p }(diffWindow, diffWindowPad)
+	diffWindow, diffWindowPad = 8<<10, 4<<10
+	old, new := parallelData()
+
+	var patch bytes.Buffer
+	if err := DiffParallel(bytes.NewReader(old), bytes.NewReader(new), &patch, 0); err != nil {
+		t.Fatal(err)
+	}
+	p := patch.Bytes()
+
+	if err := Patch(bytes.NewReader(old[1:]), ioutil.Discard, bytes.NewReader(p)); err != ErrOldSize {
+		t.Errorf("wrong old file: got %v, want %v", err, ErrOldSize)
+	}
+	if err := Patch(bytes.NewReader(old), ioutil.Discard, bytes.NewReader(p[:len(p)-20])); err == nil {
+		t.Error("truncated patch applied without error")
+	}
+	bad := append([]byte(nil), p...)
+	bad[40+16] ^= 1 // NewLen of the first block
+	if err := Patch(bytes.NewReader(old), ioutil.Discard, bytes.NewReader(bad)); err == nil {
+		t.Error("patch with a wrong block table applied without error")
+	}
+}
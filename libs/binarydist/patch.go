@@ -1,54 +1,90 @@
 package binarydist
 
 import (
+	"bufio"
 	"bytes"
 	"compress/bzip2"
 	"encoding/binary"
 	"errors"
 	"io"
-	"io/ioutil"
 )
 
 var ErrCorrupt = errors.New("corrupt patch")
 
 // Patch applies patch to old, according to the bspatch algorithm,
 // and writes the result to new.
+//
+// old and patch are read through io.ReaderAt when they implement it
+// together with io.Seeker (as *os.File does), and new is written as the
+// patch is applied, so memory use does not depend on the file sizes.
+//
+// Both classic BSDIFF40 patches and the multi-block patches written by
+// DiffParallel are accepted; the format is told by the magic.
 func Patch(old io.Reader, new io.Writer, patch io.Reader) error {
-	var hdr header
+	var m [8]byte
+	if _, err := io.ReadFull(patch, m[:]); err != nil {
+		return err
+	}
+	switch m {
+	case magic:
+		return patchBlock(old, new, patch, -1)
+	case multiMagic:
+		return patchMulti(old, new, patch)
+	}
+	return ErrCorrupt
+}
+
+// patchBlock applies a BSDIFF40 patch whose magic has been read. If
+// newSize >= 0, the patch must produce exactly that many bytes.
+func patchBlock(old io.Reader, new io.Writer, patch io.Reader, newSize int64) error {
+	var hdr struct{ CtrlLen, DiffLen, NewSize int64 }
 	err := binary.Read(patch, signMagLittleEndian{}, &hdr)
 	if err != nil {
 		return err
 	}
-	if hdr.Magic != magic {
-		return ErrCorrupt
-	}
 	if hdr.CtrlLen < 0 || hdr.DiffLen < 0 || hdr.NewSize < 0 {
 		return ErrCorrupt
 	}
-
-	ctrlbuf := make([]byte, hdr.CtrlLen)
-	_, err = io.ReadFull(patch, ctrlbuf)
-	if err != nil {
-		return err
+	if newSize >= 0 && hdr.NewSize != newSize {
+		return ErrCorrupt
 	}
-	cpfbz2 := bzip2.NewReader(bytes.NewReader(ctrlbuf))
 
-	diffbuf := make([]byte, hdr.DiffLen)
-	_, err = io.ReadFull(patch, diffbuf)
-	if err != nil {
-		return err
+	var ctrlr, diffr, extrar io.Reader
+	if pa, ok := patch.(readAtSeeker); ok {
+		off, err := pa.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		ctrlr = io.NewSectionReader(pa, off, hdr.CtrlLen)
+		diffr = io.NewSectionReader(pa, off+hdr.CtrlLen, hdr.DiffLen)
+		// The entire rest of the file is the extra block.
+		extrar = io.NewSectionReader(pa, off+hdr.CtrlLen+hdr.DiffLen, 1<<62)
+	} else {
+		ctrlbuf := make([]byte, hdr.CtrlLen)
+		_, err = io.ReadFull(patch, ctrlbuf)
+		if err != nil {
+			return err
+		}
+		diffbuf := make([]byte, hdr.DiffLen)
+		_, err = io.ReadFull(patch, diffbuf)
+		if err != nil {
+			return err
+		}
+		ctrlr, diffr, extrar = bytes.NewReader(ctrlbuf), bytes.NewReader(diffbuf), patch
 	}
-	dpfbz2 := bzip2.NewReader(bytes.NewReader(diffbuf))
-
-	// The entire rest of the file is the extra block.
-	epfbz2 := bzip2.NewReader(patch)
+	cpfbz2 := bzip2.NewReader(ctrlr)
+	dpfbz2 := bzip2.NewReader(diffr)
+	epfbz2 := bzip2.NewReader(extrar)
 
-	obuf, err := ioutil.ReadAll(old)
+	oldAt, oldSize, closeOld, err := openReaderAt(old)
 	if err != nil {
 		return err
 	}
+	defer closeOld()
 
-	nbuf := make([]byte, hdr.NewSize)
+	out := bufio.NewWriterSize(new, patchChunk)
+	buf := make([]byte, patchChunk)
+	obuf := make([]byte, patchChunk)
 
 	var oldpos, newpos int64
 	for newpos < hdr.NewSize {
@@ -59,21 +95,24 @@ func Patch(old io.Reader, new io.Writer, patch io.Reader) error {
 		}
 
 		// Sanity-check
-		if newpos+ctrl.Add > hdr.NewSize {
+		if ctrl.Add < 0 || ctrl.Copy < 0 || newpos+ctrl.Add > hdr.NewSize {
 			return ErrCorrupt
 		}
 
-		// Read diff string
-		_, err = io.ReadFull(dpfbz2, nbuf[newpos:newpos+ctrl.Add])
-		if err != nil {
-			return ErrCorrupt
-		}
-
-		// Add old data to diff string
-		for i := int64(0); i < ctrl.Add; i++ {
-			if oldpos+i >= 0 && oldpos+i < int64(len(obuf)) {
-				nbuf[newpos+i] += obuf[oldpos+i]
+		// Read diff string and add old data to it
+		for done := int64(0); done < ctrl.Add; {
+			b := buf[:min64(ctrl.Add-done, patchChunk)]
+			_, err = io.ReadFull(dpfbz2, b)
+			if err != nil {
+				return ErrCorrupt
 			}
+			if err = addOld(b, oldAt, oldSize, oldpos+done, obuf); err != nil {
+				return err
+			}
+			if _, err = out.Write(b); err != nil {
+				return err
+			}
+			done += int64(len(b))
 		}
 
 		// Adjust pointers
@@ -86,9 +125,12 @@ func Patch(old io.Reader, new io.Writer, patch io.Reader) error {
 		}
 
 		// Read extra string
-		_, err = io.ReadFull(epfbz2, nbuf[newpos:newpos+ctrl.Copy])
+		_, err = io.CopyN(out, epfbz2, ctrl.Copy)
 		if err != nil {
-			return ErrCorrupt
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrCorrupt
+			}
+			return err
 		}
 
 		// Adjust pointers
@@ -96,14 +138,37 @@ func Patch(old io.Reader, new io.Writer, patch io.Reader) error {
 		oldpos += ctrl.Seek
 	}
 
-	// Write the new file
-	for len(nbuf) > 0 {
-		n, err := new.Write(nbuf)
-		if err != nil {
-			return err
-		}
-		nbuf = nbuf[n:]
-	}
+	return out.Flush()
+}
+
+const patchChunk = 64 << 10
 
+// addOld adds old[pos:pos+len(b)] to b; bytes outside old add nothing.
+func addOld(b []byte, old io.ReaderAt, oldSize, pos int64, obuf []byte) error {
+	lo, hi := pos, pos+int64(len(b))
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > oldSize {
+		hi = oldSize
+	}
+	if lo >= hi {
+		return nil
+	}
+	o := obuf[:hi-lo]
+	if n, err := old.ReadAt(o, lo); n < len(o) {
+		return err
+	}
+	d := b[lo-pos:]
+	for i, c := range o {
+		d[i] += c
+	}
 	return nil
 }
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
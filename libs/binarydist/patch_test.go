@@ -1,6 +1,8 @@
 package binarydist
 
 import (
+	"bytes"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -60,3 +62,29 @@ func TestPatchHk(t *testing.T) {
 		t.Fatalf("produced different output at pos %d", n)
 	}
 }
+
+// TestPatchReaders applies a patch from plain readers, which Patch cannot
+// seek in and has to buffer or spool.
+func TestPatchReaders(t *testing.T) {
+	exp := mustReadAll(mustOpen("testdata/sample.new"))
+	var got bytes.Buffer
+	err := Patch(
+		struct{ io.Reader }{mustOpen("testdata/sample.old")},
+		&got,
+		struct{ io.Reader }{mustOpen("testdata/sample.patch")},
+	)
+	if err != nil {
+		t.Fatal("err", err)
+	}
+	if !bytes.Equal(got.Bytes(), exp) {
+		t.Fatalf("produced different output at pos %d", matchlen(got.Bytes(), exp))
+	}
+}
+
+func TestPatchCorrupt(t *testing.T) {
+	patch := mustReadAll(mustOpen("testdata/sample.patch"))
+	err := Patch(mustOpen("testdata/sample.old"), ioutil.Discard, bytes.NewReader(patch[:len(patch)-20]))
+	if err == nil {
+		t.Fatal("truncated patch applied without error")
+	}
+}
@@ -0,0 +1,180 @@
+package binarydist
+
+import (
+	"sort"
+)
+
+// suffixArray returns the suffix array of obuf as bsdiff's search expects
+// it: len(obuf)+1 entries, the first being the empty suffix. obuf must be
+// shorter than 1<<31 bytes.
+//
+// It uses SA-IS (Nong, Zhang and Chan, "Linear Suffix Array Construction
+// by Almost Pure Induced-Sorting"), which runs in linear time. The suffix
+// array of a text is unique, so the patches are the same as with the
+// qsufsort of the original bsdiff.
+func suffixArray(obuf []byte) []int32 {
+	I := make([]int32, len(obuf)+1)
+	I[0] = int32(len(obuf))
+	sais(obuf, I[1:], 255)
+	return I
+}
+
+// saisNaive is the text length below which plain comparison sorting is
+// faster than induced sorting.
+const saisNaive = 16
+
+// sais writes the suffix array of s, whose symbols are at most upper,
+// to sa (len(sa) == len(s)).
+func sais[T byte | int32](s []T, sa []int32, upper int) {
+	n := len(s)
+	if n < saisNaive {
+		for i := range sa {
+			sa[i] = int32(i)
+		}
+		sort.Slice(sa, func(i, j int) bool { return lessSuffix(s, int(sa[i]), int(sa[j])) })
+		return
+	}
+
+	// ls[i] reports whether suffix i is S-type (smaller than suffix i+1);
+	// the last suffix is L-type as it is followed by the empty suffix.
+	ls := make([]bool, n)
+	for i := n - 2; i >= 0; i-- {
+		if s[i] == s[i+1] {
+			ls[i] = ls[i+1]
+		} else {
+			ls[i] = s[i] < s[i+1]
+		}
+	}
+
+	// Bucket c holds the suffixes starting with c: first the L-type ones
+	// from sumL[c], then the S-type ones from sumS[c].
+	sumL := make([]int32, upper+2)
+	sumS := make([]int32, upper+2)
+	for i, c := range s {
+		if !ls[i] {
+			sumS[int(c)]++
+		} else {
+			sumL[int(c)+1]++
+		}
+	}
+	for i := 0; i <= upper; i++ {
+		sumS[i] += sumL[i]
+		sumL[i+1] += sumS[i]
+	}
+
+	buf := make([]int32, upper+2)
+	induce := func(lms []int32) {
+		for i := range sa {
+			sa[i] = -1
+		}
+		copy(buf, sumS)
+		for _, d := range lms {
+			sa[buf[int(s[d])]] = d
+			buf[int(s[d])]++
+		}
+		copy(buf, sumL)
+		sa[buf[int(s[n-1])]] = int32(n - 1)
+		buf[int(s[n-1])]++
+		for i := 0; i < n; i++ {
+			if v := sa[i]; v >= 1 && !ls[v-1] {
+				sa[buf[int(s[v-1])]] = v - 1
+				buf[int(s[v-1])]++
+			}
+		}
+		copy(buf, sumL)
+		for i := n - 1; i >= 0; i-- {
+			if v := sa[i]; v >= 1 && ls[v-1] {
+				buf[int(s[v-1])+1]--
+				sa[buf[int(s[v-1])+1]] = v - 1
+			}
+		}
+	}
+
+	// LMS suffixes: S-type suffixes preceded by an L-type one. There are
+	// at most n/2 of them, which leaves room in sa for the reduced problem.
+	isLMS := func(i int) bool { return i > 0 && i < n && ls[i] && !ls[i-1] }
+	var lms []int32
+	for i := 1; i < n; i++ {
+		if isLMS(i) {
+			lms = append(lms, int32(i))
+		}
+	}
+	m := len(lms)
+
+	induce(lms)
+	if m == 0 {
+		return
+	}
+
+	// Move the LMS suffixes, now sorted by their LMS substrings, to sa[:m]
+	// and name the substrings in that order. The names are stored at
+	// sa[m+pos/2] (LMS positions are at least two apart), then packed into
+	// sa[n-m:] in text order to form the reduced string.
+	j := 0
+	for _, v := range sa {
+		if isLMS(int(v)) {
+			sa[j] = v
+			j++
+		}
+	}
+	names := sa[m:]
+	for i := range names {
+		names[i] = -1
+	}
+	name := int32(0)
+	for i := 0; i < m; i++ {
+		if i == 0 || !equalLMS(s, ls, int(sa[i-1]), int(sa[i])) {
+			name++
+		}
+		names[sa[i]/2] = name - 1
+	}
+
+	if int(name) < m {
+		// Some LMS substrings are equal: sort the LMS suffixes by sorting
+		// the suffixes of the reduced string.
+		j = n - 1
+		for i := len(names) - 1; i >= 0; i-- {
+			if names[i] >= 0 {
+				sa[j] = names[i]
+				j--
+			}
+		}
+		sais(sa[n-m:], sa[:m], int(name)-1)
+		for i := 0; i < m; i++ {
+			sa[n-m+i] = lms[sa[i]]
+		}
+		copy(lms, sa[n-m:])
+	} else {
+		copy(lms, sa[:m])
+	}
+	induce(lms)
+}
+
+// equalLMS reports whether the LMS substrings at a and b (up to and
+// including the next LMS position) are equal.
+func equalLMS[T byte | int32](s []T, ls []bool, a, b int) bool {
+	n := len(s)
+	for k := 0; ; k++ {
+		if a+k == n || b+k == n || s[a+k] != s[b+k] || ls[a+k] != ls[b+k] {
+			return false
+		}
+		if k > 0 {
+			endA := ls[a+k] && !ls[a+k-1]
+			endB := ls[b+k] && !ls[b+k-1]
+			if endA || endB {
+				return endA && endB
+			}
+		}
+	}
+}
+
+func lessSuffix[T byte | int32](s []T, i, j int) bool {
+	for i < len(s) && j < len(s) {
+		if s[i] != s[j] {
+			return s[i] < s[j]
+		}
+		i++
+		j++
+	}
+	return i == len(s)
+}
@@ -3,20 +3,42 @@ package binarydist
 import (
 	"bytes"
 	"crypto/rand"
+	mrand "math/rand"
 	"testing"
 )
 
 var sortT = [][]byte{
+	nil,
+	[]byte("a"),
 	mustRandBytes(1000),
 	mustReadAll(mustOpen("test.old")),
+	mustReadAll(mustOpen("testdata/sample.old")),
 	[]byte("abcdefabcdef"),
+	[]byte("mississippi"),
+	bytes.Repeat([]byte("ab"), 1000),
+	bytes.Repeat([]byte("abcabd"), 1000),
+	make([]byte, 1000),
 }
 
-func TestQsufsort(t *testing.T) {
-	for _, s := range sortT {
-		I := qsufsort(s)
+func TestSuffixArray(t *testing.T) {
+	tests := sortT
+	// Small alphabets give long repeats and deep recursion.
+	r := mrand.New(mrand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		s := make([]byte, r.Intn(500))
+		for j := range s {
+			s[j] = 'a' + byte(r.Intn(1+i%3))
+		}
+		tests = append(tests, s)
+	}
+
+	for _, s := range tests {
+		I := suffixArray(s)
+		if len(I) != len(s)+1 || I[0] != int32(len(s)) {
+			t.Fatalf("len %d: got %d entries starting with %d", len(s), len(I), I[0])
+		}
 		for i := 1; i < len(I); i++ {
-			if bytes.Compare(s[I[i-1]:], s[I[i]:]) > 0 {
+			if bytes.Compare(s[I[i-1]:], s[I[i]:]) >= 0 {
 				t.Fatalf("unsorted at %d", i)
 			}
 		}
@@ -0,0 +1,187 @@
+// Package cron parses the five-field schedule expressions of crontab(5)
+// and finds the times they match.
+//
+// The fields are minute (0-59), hour (0-23), day of month (1-31), month
+// (1-12 or jan-dec) and day of week (0-7 or sun-sat, where both 0 and 7
+// are Sunday). Each field is "*", a value, a range "a-b", or a list of
+// these separated by commas, and "*" and ranges may take a step "/n".
+// As in cron, when both the day of month and the day of week are
+// restricted, a day matching either one matches. The shorthands @hourly,
+// @daily (or @midnight), @weekly, @monthly and @yearly (or @annually) are
+// accepted too.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bit i is set when value i matches
+	domStar, dowStar              bool   // the field was "*" (or "*/1")
+}
+
+var shorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse parses a five-field expression or a shorthand.
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if s, ok := shorthands[strings.ToLower(expr)]; ok {
+		expr = s
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron: expected 5 fields, got %d in %q", len(fields), expr)
+	}
+
+	var s Schedule
+	var err error
+	if s.minute, _, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if s.hour, _, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if s.dom, s.domStar, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if s.month, _, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, err
+	}
+	if s.dow, s.dowStar, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, err
+	}
+	// 7 is another name for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return &s, nil
+}
+
+// parseField returns the set of values the field matches and whether it
+// matches every value.
+func parseField(field string, lo, hi int, names map[string]int) (uint64, bool, error) {
+	var bits uint64
+	star := false
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("cron: bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+
+		var first, last int
+		switch {
+		case rng == "*":
+			first, last = lo, hi
+			star = star || step == 1
+		case strings.Contains(rng, "-"):
+			i := strings.IndexByte(rng, '-')
+			var err error
+			if first, err = parseValue(rng[:i], lo, hi, names); err != nil {
+				return 0, false, err
+			}
+			if last, err = parseValue(rng[i+1:], lo, hi, names); err != nil {
+				return 0, false, err
+			}
+			if first > last {
+				return 0, false, fmt.Errorf("cron: empty range %q", rng)
+			}
+		default:
+			v, err := parseValue(rng, lo, hi, names)
+			if err != nil {
+				return 0, false, err
+			}
+			first, last = v, v
+			// "5/15" means from 5 to the end in steps of 15
+			if step > 1 {
+				last = hi
+			}
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, star, nil
+}
+
+func parseValue(s string, lo, hi int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("cron: bad value %q", s)
+	}
+	if v < lo || v > hi {
+		return 0, fmt.Errorf("cron: %d out of range %d-%d", v, lo, hi)
+	}
+	return v, nil
+}
+
+// Next returns the first matching time strictly after t, in t's location,
+// with seconds cleared. It returns the zero time if nothing matches within
+// the next five years (as for "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dow
+	case s.dowStar:
+		return dom
+	}
+	return dom || dow
+}
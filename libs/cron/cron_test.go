@@ -0,0 +1,94 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, expr string) *Schedule {
+	t.Helper()
+	s, err := Parse(expr)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", expr, err)
+	}
+	return s
+}
+
+func at(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2026-01-01 10:00", "2026-01-01 10:01"},
+		{"*/15 * * * *", "2026-01-01 10:07", "2026-01-01 10:15"},
+		{"5/20 * * * *", "2026-01-01 10:26", "2026-01-01 10:45"},
+		{"0 9-17/4 * * *", "2026-01-01 13:00", "2026-01-01 17:00"},
+		{"30 18 * * mon-fri", "2026-01-02 19:00", "2026-01-05 18:30"}, // Friday evening -> Monday
+		{"0 0 1 * *", "2026-01-31 12:00", "2026-02-01 00:00"},
+		{"0 12 29 feb *", "2026-03-01 00:00", "2028-02-29 12:00"},
+		{"0 0 * * 7", "2026-01-01 00:00", "2026-01-04 00:00"}, // 7 is Sunday
+		{"0,30 8 * * *", "2026-01-01 08:00", "2026-01-01 08:30"},
+		{"@daily", "2026-12-31 23:59", "2027-01-01 00:00"},
+		{"@hourly", "2026-01-01 10:59", "2026-01-01 11:00"},
+	}
+	for _, tt := range tests {
+		got := mustParse(t, tt.expr).Next(at(tt.from))
+		if want := at(tt.want); !got.Equal(want) {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+// With both day fields restricted, either one matching is enough.
+func TestNextDayOfMonthOrWeek(t *testing.T) {
+	s := mustParse(t, "0 0 13 * fri")
+	got := s.Next(at("2026-01-01 00:00")) // Friday Jan 2 comes before the 13th
+	if want := at("2026-01-02 00:00"); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	got = s.Next(at("2026-01-12 00:00"))
+	if want := at("2026-01-13 00:00"); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextClearsSeconds(t *testing.T) {
+	from := time.Date(2026, 1, 1, 10, 0, 42, 5, time.UTC)
+	if got, want := mustParse(t, "* * * * *").Next(from), at("2026-01-01 10:01"); !got.Equal(want) {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestNextNeverMatches(t *testing.T) {
+	if got := mustParse(t, "0 0 30 2 *").Next(at("2026-01-01 00:00")); !got.IsZero() {
+		t.Errorf("got %s, want zero time", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"a * * * *",
+		"@reboot",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", expr)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package fastcdc splits a stream into content-defined chunks with FastCDC
+// (Xia et al., "FastCDC: a Fast and Efficient Content-Defined Chunking
+// Approach for Data Deduplication", USENIX ATC 2016).
+//
+// Chunk boundaries depend only on the bytes near them, so an insertion or
+// deletion changes the chunks around the edit and leaves the others as
+// they were; equal chunks of different versions can then be stored once.
+package fastcdc
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Options are the chunk size limits. AvgSize must be a power of two with
+// MinSize <= AvgSize <= MaxSize.
+type Options struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// DefaultOptions suit files of a few megabytes to a few gigabytes.
+var DefaultOptions = Options{MinSize: 16 << 10, AvgSize: 64 << 10, MaxSize: 256 << 10}
+
+// ErrOptions is returned by NewChunker for inconsistent Options.
+var ErrOptions = errors.New("fastcdc: invalid options")
+
+// normalization is the level of normalized chunking: cut points are made
+// 1<<normalization times harder to find before AvgSize and that much
+// easier after it, which narrows the size distribution.
+const normalization = 2
+
+// Chunker reads a stream and returns its chunks.
+type Chunker struct {
+	r     io.Reader
+	opt   Options
+	maskS uint64 // used before AvgSize
+	maskL uint64 // used after AvgSize
+
+	buf   []byte
+	start int // start of the unread data in buf
+	end   int // end of the data in buf
+	eof   bool
+}
+
+// NewChunker returns a Chunker reading from r.
+func NewChunker(r io.Reader, opt Options) (*Chunker, error) {
+	if opt.MinSize <= 0 || opt.AvgSize < opt.MinSize || opt.MaxSize < opt.AvgSize ||
+		bits.OnesCount(uint(opt.AvgSize)) != 1 || opt.AvgSize < 1<<(normalization+1) {
+		return nil, ErrOptions
+	}
+	n := uint(bits.TrailingZeros(uint(opt.AvgSize)))
+	return &Chunker{
+		r:     r,
+		opt:   opt,
+		maskS: highMask(n + normalization),
+		maskL: highMask(n - normalization),
+		buf:   make([]byte, 2*opt.MaxSize),
+	}, nil
+}
+
+// highMask returns a mask of the n highest bits. The gear hash shifts left,
+// so its high bits depend on the last 64 bytes and its low bits only on
+// the last few.
+func highMask(n uint) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	data := c.buf[c.start:c.end]
+	n := c.cut(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill reads until MaxSize bytes are buffered or the input ends.
+func (c *Chunker) fill() error {
+	if c.end-c.start >= c.opt.MaxSize || c.eof {
+		return nil
+	}
+	if c.start > 0 {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+	}
+	for c.end < c.opt.MaxSize && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data.
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n <= c.opt.MinSize {
+		return n
+	}
+	if n > c.opt.MaxSize {
+		n = c.opt.MaxSize
+	}
+	normal := c.opt.AvgSize
+	if normal > n {
+		normal = n
+	}
+
+	var fp uint64
+	i := c.opt.MinSize
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gear holds the random values of the gear hash. They are fixed, since
+// changing them would move every chunk boundary.
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	x := uint64(0x6663646367656172) // "fcdcgear"
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		gear[i] = z ^ z>>31
+	}
+}
@@ -0,0 +1,108 @@
+package fastcdc
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func chunks(t *testing.T, r io.Reader, opt Options) [][]byte {
+	t.Helper()
+	c, err := NewChunker(r, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out [][]byte
+	for {
+		b, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, append([]byte(nil), b...))
+	}
+}
+
+func random(seed int64, n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+func TestChunkSizes(t *testing.T) {
+	opt := Options{MinSize: 2 << 10, AvgSize: 8 << 10, MaxSize: 32 << 10}
+	for _, data := range [][]byte{
+		nil,
+		[]byte("short"),
+		random(1, 3<<20),
+		bytes.Repeat([]byte{0}, 1<<20), // no cut points at all
+	} {
+		cs := chunks(t, bytes.NewReader(data), opt)
+		if got := bytes.Join(cs, nil); !bytes.Equal(got, data) {
+			t.Fatalf("chunks do not add up to the input")
+		}
+		for i, c := range cs {
+			if len(c) > opt.MaxSize || len(c) == 0 || (len(c) < opt.MinSize && i != len(cs)-1) {
+				t.Fatalf("chunk %d of %d has %d bytes", i, len(cs), len(c))
+			}
+		}
+		if len(data) == 3<<20 {
+			avg := len(data) / len(cs)
+			if avg < opt.AvgSize/2 || avg > opt.AvgSize*2 {
+				t.Errorf("average chunk size %d, want about %d", avg, opt.AvgSize)
+			}
+		}
+	}
+}
+
+func TestReadSizes(t *testing.T) {
+	data := random(2, 1<<20)
+	want := chunks(t, bytes.NewReader(data), DefaultOptions)
+	got := chunks(t, iotest.HalfReader(iotest.OneByteReader(bytes.NewReader(data))), DefaultOptions)
+	if len(got) != len(want) {
+		t.Fatalf("%d chunks, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("chunk %d depends on read sizes", i)
+		}
+	}
+}
+
+// An insertion should only change the chunks around it.
+func TestInsertion(t *testing.T) {
+	data := random(3, 4<<20)
+	edited := append(append(append([]byte(nil), data[:1<<21]...), "inserted"...), data[1<<21:]...)
+
+	seen := map[string]bool{}
+	for _, c := range chunks(t, bytes.NewReader(data), DefaultOptions) {
+		seen[string(c)] = true
+	}
+	cs := chunks(t, bytes.NewReader(edited), DefaultOptions)
+	changed := 0
+	for _, c := range cs {
+		if !seen[string(c)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("%d of %d chunks changed", changed, len(cs))
+	}
+}
+
+func TestOptions(t *testing.T) {
+	for _, opt := range []Options{
+		{},
+		{MinSize: 1024, AvgSize: 3000, MaxSize: 8192},
+		{MinSize: 4096, AvgSize: 2048, MaxSize: 8192},
+		{MinSize: 1024, AvgSize: 4096, MaxSize: 2048},
+	} {
+		if _, err := NewChunker(nil, opt); err != ErrOptions {
+			t.Errorf("%+v: err = %v", opt, err)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package hdiffpatch
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// matchBlock is the length of the old data blocks put in the hash
+	// table; any match of at least 2*matchBlock-1 bytes is found.
+	matchBlock = 32
+
+	// resumeLen is how many bytes must match to resume a cover on the
+	// diagonal of the previous one without a hash lookup.
+	resumeLen = 16
+
+	// mergeGap is the largest gap between two covers on the same diagonal
+	// that is stored as a byte-wise difference instead of new data.
+	mergeGap = 64
+
+	// stepSize is the target size of the cover and rle data of one step,
+	// and therefore of the buffer hpatchz needs for the diff.
+	stepSize = 256 * 1024
+)
+
+// Diffing works on windows so that memory use depends on these sizes
+// rather than on the file sizes: every diffWindow bytes of new are
+// matched against at most oldWindow bytes of old, centered where the
+// last match of the previous window points (or at the same relative
+// offset before there is one). When old fits in oldWindow it is matched
+// as a whole.
+var (
+	diffWindow int64 = 16 << 20
+	oldWindow  int64 = 64 << 20
+)
+
+// Diff computes the difference between old and new and writes it to diff
+// as a zstd compressed HDIFFSF20 diff.
+//
+// old and new are read window by window through io.ReaderAt when they
+// implement it together with io.Seeker (as *os.File does); other readers
+// are spooled to a temporary file first. The compressed body is spooled
+// too, as the header in front of it holds its sizes.
+func Diff(old, new io.Reader, diff io.Writer, opt *Options) error {
+	oldAt, oldSize, closeOld, err := openReaderAt(old)
+	if err != nil {
+		return err
+	}
+	defer closeOld()
+
+	newAt, newSize, closeNew, err := openReaderAt(new)
+	if err != nil {
+		return err
+	}
+	defer closeNew()
+
+	var body spool
+	defer body.Close()
+	enc, err := zstd.NewWriter(&body, zstd.WithEncoderLevel(zstd.SpeedBetterCompression), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+	sw := &stepWriter{w: &countingWriter{w: enc}, new: newAt}
+
+	var obuf, nbuf []byte
+	var table []uint32
+	var bits uint
+	oldLo, oldHi := int64(-1), int64(-1)
+	var last *cover
+	for newLo := int64(0); newLo < newSize; newLo += diffWindow {
+		newHi := min(newLo+diffWindow, newSize)
+		lo, hi := oldWindowFor(newLo, oldSize, newSize, last)
+		if lo != oldLo || hi != oldHi {
+			if obuf, err = readWindow(oldAt, obuf, lo, hi); err != nil {
+				return err
+			}
+			table, bits = indexBlocks(obuf, table)
+			oldLo, oldHi = lo, hi
+		}
+		if nbuf, err = readWindow(newAt, nbuf, newLo, newHi); err != nil {
+			return err
+		}
+
+		w := window{old: obuf, new: nbuf, oldBase: lo, newBase: newLo, table: table, bits: bits}
+		covers := findCovers(w, newSize, opt)
+		for _, c := range covers {
+			if err := sw.add(w, c); err != nil {
+				return err
+			}
+		}
+		if len(covers) > 0 {
+			last = &covers[len(covers)-1]
+		}
+	}
+	if err := sw.finish(newSize); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	head := []byte(typeSingle + "&" + compressZstd + "\x00")
+	head = appendUint(head, newSize)
+	head = appendUint(head, oldSize)
+	head = appendUint(head, sw.covers)
+	head = appendUint(head, sw.stepMax)
+	head = appendUint(head, sw.w.n)
+	if sw.w.n == 0 {
+		head = appendUint(head, 0) // an empty body is stored as is
+	} else {
+		head = appendUint(head, body.size)
+	}
+	if _, err := diff.Write(head); err != nil {
+		return err
+	}
+	if sw.w.n > 0 {
+		if _, err := body.WriteTo(diff); err != nil {
+			return err
+		}
+	}
+	opt.progress(newSize, newSize)
+	return nil
+}
+
+// oldWindowFor returns the part of old that the window of new starting at
+// newLo is matched against; last is the last cover found before it, if any.
+func oldWindowFor(newLo, oldSize, newSize int64, last *cover) (lo, hi int64) {
+	if oldSize <= oldWindow {
+		return 0, oldSize
+	}
+	center := int64(float64(newLo) * (float64(oldSize) / float64(newSize)))
+	if last != nil {
+		center = newLo + last.oldPos - last.newPos
+	}
+	lo = center + diffWindow/2 - oldWindow/2
+	lo = max(0, min(lo, oldSize-oldWindow))
+	return lo, lo + oldWindow
+}
+
+func readWindow(r io.ReaderAt, buf []byte, lo, hi int64) ([]byte, error) {
+	n := int(hi - lo)
+	if cap(buf) < n {
+		buf = make([]byte, n)
+	}
+	buf = buf[:n]
+	if m, err := r.ReadAt(buf, lo); m < n {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// window is the part of old and new being matched, with the hash table of
+// the old part; covers found in it hold positions in the whole files.
+type window struct {
+	old, new         []byte
+	oldBase, newBase int64
+	table            []uint32
+	bits             uint
+}
+
+// indexBlocks builds the hash table of old's matchBlock-aligned blocks,
+// reusing table's memory, and returns it with its size in bits.
+func indexBlocks(old []byte, table []uint32) ([]uint32, uint) {
+	bits := uint(8)
+	for 1<<bits < len(old)/matchBlock && bits < 31 {
+		bits++
+	}
+	if cap(table) < 1<<bits {
+		table = make([]uint32, 1<<bits)
+	}
+	table = table[:1<<bits]
+	clear(table) // block index + 1, 0 means empty
+	for i := 0; i+matchBlock <= len(old); i += matchBlock {
+		table[hashSlot(hashBlock(old[i:i+matchBlock]), bits)] = uint32(i/matchBlock + 1)
+	}
+	return table, bits
+}
+
+// findCovers finds the regions of the new window that also appear in the
+// old one, using a rolling hash over new and the table of old's
+// matchBlock-aligned blocks.
+func findCovers(w window, newSize int64, opt *Options) []cover {
+	old, new, table, bits := w.old, w.new, w.table, w.bits
+	if len(old) < matchBlock || len(new) < matchBlock {
+		return nil
+	}
+
+	var covers []cover
+	lastEnd, diag, haveDiag := 0, 0, false
+	nextProgress := progressEvery
+	h := hashBlock(new[:matchBlock])
+	for i := 0; i+matchBlock <= len(new); {
+		if i >= nextProgress {
+			opt.progress(w.newBase+int64(i), newSize)
+			nextProgress = i + progressEvery
+		}
+
+		start, pos, found := i, -1, false
+		if haveDiag && i+diag >= 0 && i+diag+resumeLen <= len(old) &&
+			bytes.Equal(old[i+diag:i+diag+resumeLen], new[i:i+resumeLen]) {
+			pos, found = i+diag, true
+		} else if e := table[hashSlot(h, bits)]; e != 0 {
+			o := int(e-1) * matchBlock
+			if bytes.Equal(old[o:o+matchBlock], new[i:i+matchBlock]) {
+				pos, found = o, true
+				for start > lastEnd && pos > 0 && old[pos-1] == new[start-1] {
+					start--
+					pos--
+				}
+			}
+		}
+
+		if !found {
+			if i+matchBlock < len(new) {
+				h = rollHash(h, new[i], new[i+matchBlock])
+			}
+			i++
+			continue
+		}
+
+		n := matchlen(old[pos:], new[start:])
+		covers = append(covers, cover{oldPos: w.oldBase + int64(pos), newPos: w.newBase + int64(start), length: int64(n)})
+		lastEnd = start + n
+		diag, haveDiag = pos-start, true
+		i = lastEnd
+		if i+matchBlock <= len(new) {
+			h = hashBlock(new[i : i+matchBlock])
+		}
+	}
+	return splitCovers(mergeCovers(covers))
+}
+
+// mergeCovers joins covers separated by a short gap on the same diagonal;
+// the changed bytes in the gap then cost a few bytes of rle data.
+func mergeCovers(covers []cover) []cover {
+	var out []cover
+	for _, c := range covers {
+		if n := len(out); n > 0 {
+			p := &out[n-1]
+			gapNew := c.newPos - (p.newPos + p.length)
+			gapOld := c.oldPos - (p.oldPos + p.length)
+			if gapNew == gapOld && gapNew >= 0 && gapNew <= mergeGap {
+				p.length = c.newPos + c.length - p.newPos
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// splitCovers keeps every cover below stepSize so that steps stay small.
+func splitCovers(covers []cover) []cover {
+	var out []cover
+	for _, c := range covers {
+		for c.length > stepSize {
+			out = append(out, cover{oldPos: c.oldPos, newPos: c.newPos, length: stepSize})
+			c.oldPos += stepSize
+			c.newPos += stepSize
+			c.length -= stepSize
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// stepWriter serializes covers into the uncompressed HDIFFSF20 body as
+// they are found. A step holds covers and their rle data, followed by the
+// new data in front of each cover; that data is copied from new when the
+// step is written, as it may span several windows.
+type stepWriter struct {
+	w   *countingWriter
+	new io.ReaderAt
+
+	covers    int64 // written so far
+	stepMax   int64 // size of the largest step's cover and rle data
+	coverBuf  []byte
+	rle       rleWriter
+	gaps      [][2]int64 // new data in front of the covers of the step
+	cw        coverWriter
+	lastNewAt int64
+}
+
+func (s *stepWriter) add(w window, c cover) error {
+	s.gaps = append(s.gaps, [2]int64{s.lastNewAt, c.newPos})
+	s.coverBuf = s.cw.append(s.coverBuf, c)
+	old := w.old[c.oldPos-w.oldBase:]
+	new := w.new[c.newPos-w.newBase:]
+	for i := int64(0); i < c.length; i++ {
+		s.rle.add(new[i] - old[i])
+	}
+	s.covers++
+	s.lastNewAt = c.newPos + c.length
+	if len(s.coverBuf)+s.rle.size() >= stepSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *stepWriter) flush() error {
+	if len(s.coverBuf) == 0 {
+		return nil
+	}
+	rleBuf := s.rle.finish()
+	head := appendUint(nil, int64(len(s.coverBuf)))
+	head = appendUint(head, int64(len(rleBuf)))
+	for _, b := range [][]byte{head, s.coverBuf, rleBuf} {
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, g := range s.gaps {
+		if err := s.copyNew(g[0], g[1]); err != nil {
+			return err
+		}
+	}
+	s.stepMax = max(s.stepMax, int64(len(s.coverBuf)+len(rleBuf)))
+	s.coverBuf, s.gaps, s.rle = s.coverBuf[:0], s.gaps[:0], rleWriter{}
+	return nil
+}
+
+// finish writes the last step and the new data after the last cover.
+func (s *stepWriter) finish(newSize int64) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	return s.copyNew(s.lastNewAt, newSize)
+}
+
+func (s *stepWriter) copyNew(lo, hi int64) error {
+	if hi <= lo {
+		return nil
+	}
+	_, err := io.Copy(s.w, io.NewSectionReader(s.new, lo, hi-lo))
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rleWriter builds the single stream rle data of one step: alternating
+// zero run lengths and raw byte strings. Short zero runs inside raw data
+// are kept raw.
+type rleWriter struct {
+	out           []byte
+	zeros         int64
+	raw           []byte
+	trailingZeros int64
+}
+
+const rleMinZeroRun = 4
+
+func (w *rleWriter) add(b byte) {
+	switch {
+	case len(w.raw) == 0 && b == 0:
+		w.zeros++
+	case b == 0:
+		w.trailingZeros++
+		if w.trailingZeros >= rleMinZeroRun {
+			w.emit()
+			w.zeros, w.trailingZeros = rleMinZeroRun, 0
+		}
+	default:
+		for ; w.trailingZeros > 0; w.trailingZeros-- {
+			w.raw = append(w.raw, 0)
+		}
+		w.raw = append(w.raw, b)
+	}
+}
+
+func (w *rleWriter) emit() {
+	w.out = appendUint(w.out, w.zeros)
+	w.out = appendUint(w.out, int64(len(w.raw)))
+	w.out = append(w.out, w.raw...)
+	w.zeros, w.raw = 0, w.raw[:0]
+}
+
+func (w *rleWriter) size() int {
+	return len(w.out) + len(w.raw) + 16
+}
+
+func (w *rleWriter) finish() []byte {
+	if len(w.raw) > 0 {
+		w.emit()
+		w.zeros, w.trailingZeros = w.trailingZeros, 0
+	}
+	if w.zeros > 0 {
+		w.out = appendUint(w.out, w.zeros)
+	}
+	return w.out
+}
+
+const hashMul = 0x100000001b3
+
+var hashOutMul = func() uint64 {
+	m := uint64(1)
+	for i := 0; i < matchBlock-1; i++ {
+		m *= hashMul
+	}
+	return m
+}()
+
+func hashBlock(b []byte) uint64 {
+	var h uint64
+	for _, c := range b {
+		h = h*hashMul + uint64(c) + 1
+	}
+	return h
+}
+
+func rollHash(h uint64, out, in byte) uint64 {
+	return (h-(uint64(out)+1)*hashOutMul)*hashMul + uint64(in) + 1
+}
+
+func hashSlot(h uint64, bits uint) uint64 {
+	return (h * 0x9e3779b97f4a7c15) >> (64 - bits)
+}
+
+func matchlen(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
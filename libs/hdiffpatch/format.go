@@ -0,0 +1,214 @@
+// Package hdiffpatch implements the HDiffPatch diff formats in pure Go.
+//
+// Patch applies both the "HDIFF13" compressed format written by `hdiffz -s`
+// and the "HDIFFSF20" single compressed format written by `hdiffz -SD`.
+// Diff writes single compressed (HDIFFSF20) diffs, zstd compressed, which
+// `hpatchz` can apply as well.
+package hdiffpatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	typeCompressed = "HDIFF13"   // hdiffz -s
+	typeSingle     = "HDIFFSF20" // hdiffz -SD
+
+	compressNone = ""
+	compressZstd = "zstd"
+
+	// maxTypeLen bounds the "HDIFF13&zstd\0" type string at the head of a diff.
+	maxTypeLen = 64
+)
+
+var (
+	// ErrCorrupt is returned when the diff data is truncated or inconsistent.
+	ErrCorrupt = errors.New("hdiffpatch: corrupt diff data")
+
+	// ErrOldSizeMismatch is returned when the old data given to Patch is not
+	// the data the diff was created from.
+	ErrOldSizeMismatch = errors.New("hdiffpatch: old data size does not match the diff")
+)
+
+// UnsupportedError is returned for diff types or compressors this package
+// cannot decode.
+type UnsupportedError struct {
+	Type     string
+	Compress string
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Compress != "" {
+		return fmt.Sprintf("hdiffpatch: unsupported compress type %q in %s diff", e.Compress, e.Type)
+	}
+	return fmt.Sprintf("hdiffpatch: unsupported diff type %q", e.Type)
+}
+
+// Options controls Diff and Patch. A nil *Options uses the defaults.
+type Options struct {
+	// Progress, if set, is called periodically with the number of bytes of
+	// new data processed so far and the total size of the new data.
+	Progress func(done, total int64)
+}
+
+func (o *Options) progress(done, total int64) {
+	if o != nil && o.Progress != nil {
+		o.Progress(done, total)
+	}
+}
+
+// IsDiff reports whether head (the first bytes of a file) starts with an
+// HDiffPatch type string this package can patch.
+func IsDiff(head []byte) bool {
+	s := string(head)
+	return strings.HasPrefix(s, typeCompressed+"&") || strings.HasPrefix(s, typeSingle+"&")
+}
+
+// cover maps new[newPos:newPos+length] onto old[oldPos:oldPos+length];
+// the bytes in between are stored as a byte-wise difference.
+type cover struct {
+	oldPos, newPos, length int64
+}
+
+// readType reads the "<type>&<compress>\0" string at the start of a diff.
+func readType(r io.ByteReader) (typ, compress string, err error) {
+	var b []byte
+	for len(b) < maxTypeLen {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", "", unexpected(err)
+		}
+		if c == 0 {
+			typ, compress, ok := strings.Cut(string(b), "&")
+			if !ok {
+				return "", "", &UnsupportedError{Type: string(b)}
+			}
+			return typ, compress, nil
+		}
+		b = append(b, c)
+	}
+	return "", "", &UnsupportedError{Type: string(b)}
+}
+
+// readUint reads an unsigned integer packed as big-endian 7-bit groups,
+// the high bit of each byte flagging that another byte follows.
+func readUint(r io.ByteReader) (int64, error) {
+	v, _, err := readUintWithTag(r, 0)
+	return v, err
+}
+
+// readUintWithTag reads an integer whose first byte carries tagBits of tag
+// in its high bits, followed by the continuation flag.
+func readUintWithTag(r io.ByteReader, tagBits uint) (int64, byte, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, unexpected(err)
+	}
+	tag := c >> (8 - tagBits)
+	contBit := byte(1) << (7 - tagBits)
+	v := uint64(c & (contBit - 1))
+	for c&contBit != 0 {
+		if v>>56 != 0 {
+			return 0, 0, ErrCorrupt
+		}
+		if c, err = r.ReadByte(); err != nil {
+			return 0, 0, unexpected(err)
+		}
+		v = v<<7 | uint64(c&0x7f)
+		contBit = 0x80
+	}
+	if v > 1<<62 {
+		return 0, 0, ErrCorrupt
+	}
+	return int64(v), tag, nil
+}
+
+func appendUint(b []byte, v int64) []byte {
+	return appendUintWithTag(b, v, 0, 0)
+}
+
+func appendUintWithTag(b []byte, v int64, tag byte, tagBits uint) []byte {
+	u := uint64(v)
+	n := 0
+	for x := u >> (7 - tagBits); x != 0; x >>= 7 {
+		n++
+	}
+	contBit := byte(1) << (7 - tagBits)
+	first := tag<<(8-tagBits) | byte(u>>(7*n))&(contBit-1)
+	if n > 0 {
+		first |= contBit
+	}
+	b = append(b, first)
+	for i := n - 1; i >= 0; i-- {
+		c := byte(u>>(7*i)) & 0x7f
+		if i > 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// coverReader decodes the cover list. Positions are stored relative to the
+// end of the previous cover, the old one with a sign tag.
+type coverReader struct {
+	r          io.ByteReader
+	lastOldEnd int64
+	lastNewEnd int64
+}
+
+func (cr *coverReader) next() (cover, error) {
+	d, neg, err := readUintWithTag(cr.r, 1)
+	if err != nil {
+		return cover{}, err
+	}
+	oldPos := cr.lastOldEnd + d
+	if neg != 0 {
+		oldPos = cr.lastOldEnd - d
+	}
+	newStep, err := readUint(cr.r)
+	if err != nil {
+		return cover{}, err
+	}
+	length, err := readUint(cr.r)
+	if err != nil {
+		return cover{}, err
+	}
+	c := cover{oldPos: oldPos, newPos: cr.lastNewEnd + newStep, length: length}
+	if c.oldPos < 0 {
+		return cover{}, ErrCorrupt
+	}
+	cr.lastOldEnd = c.oldPos + c.length
+	cr.lastNewEnd = c.newPos + c.length
+	return c, nil
+}
+
+// coverWriter is the encoding counterpart of coverReader.
+type coverWriter struct {
+	lastOldEnd int64
+	lastNewEnd int64
+}
+
+func (cw *coverWriter) append(b []byte, c cover) []byte {
+	if c.oldPos >= cw.lastOldEnd {
+		b = appendUintWithTag(b, c.oldPos-cw.lastOldEnd, 0, 1)
+	} else {
+		b = appendUintWithTag(b, cw.lastOldEnd-c.oldPos, 1, 1)
+	}
+	b = appendUint(b, c.newPos-cw.lastNewEnd)
+	b = appendUint(b, c.length)
+	cw.lastOldEnd = c.oldPos + c.length
+	cw.lastNewEnd = c.newPos + c.length
+	return b
+}
+
+// unexpected turns a plain EOF in the middle of the diff into ErrCorrupt.
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrCorrupt
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package hdiffpatch
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func patchBytes(old, diff []byte, opt *Options) ([]byte, error) {
+	var out bytes.Buffer
+	err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(diff), int64(len(diff)), &out, opt)
+	return out.Bytes(), err
+}
+
+func diffBytes(t *testing.T, old, new []byte) []byte {
+	t.Helper()
+	var diff bytes.Buffer
+	if err := Diff(bytes.NewReader(old), bytes.NewReader(new), &diff, nil); err != nil {
+		t.Fatal(err)
+	}
+	return diff.Bytes()
+}
+
+// TestPatchHdiffz applies diffs written by hdiffz 4.x
+// (`hdiffz -s -c-zstd` and `hdiffz -SD -c-zstd`).
+func TestPatchHdiffz(t *testing.T) {
+	old := mustReadFile(t, "testdata/sample.old")
+	want := mustReadFile(t, "testdata/sample.new")
+	for _, name := range []string{"testdata/sample.hdiff13", "testdata/sample.sf20"} {
+		diff := mustReadFile(t, name)
+		if !IsDiff(diff) {
+			t.Errorf("%s: IsDiff = false", name)
+		}
+		got, err := patchBytes(old, diff, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: patched output differs from sample.new", name)
+		}
+	}
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+
+	big := random(3 << 20)
+	edited := append([]byte(nil), big...)
+	for i := 0; i < 200; i++ {
+		edited[r.Intn(len(edited))] ^= 0x5a
+	}
+	edited = append(edited[:1<<20], append(random(5000), edited[1<<20+3000:]...)...)
+
+	cases := []struct {
+		name     string
+		old, new []byte
+	}{
+		{"empty", nil, nil},
+		{"empty old", nil, []byte("new data")},
+		{"empty new", []byte("old data"), nil},
+		{"identical", big[:100000], big[:100000]},
+		{"unrelated", random(10000), random(12000)},
+		{"shifted", big[:50000], append([]byte("prefix"), big[:50000]...)},
+		{"edited", big, edited},
+	}
+	for _, c := range cases {
+		diff := diffBytes(t, c.old, c.new)
+		got, err := patchBytes(c.old, diff, nil)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !bytes.Equal(got, c.new) {
+			t.Errorf("%s: round trip output differs", c.name)
+		}
+	}
+
+	if diff := diffBytes(t, big, edited); len(diff) > 20000 {
+		t.Errorf("diff of a lightly edited file is %d bytes", len(diff))
+	}
+}
+
+// TestDiffWindows diffs inputs larger than the windows, read from readers
+// that cannot seek, so that both are spooled and matched window by window
+// and the body is spooled to disk.
+func TestDiffWindows(t *testing.T) {
+	defer func(w, o int64, m int) { diffWindow, oldWindow, spoolMemory = w, o, m }(diffWindow, oldWindow, spoolMemory)
+	diffWindow, oldWindow, spoolMemory = 16<<10, 64<<10, 8<<10
+
+	r := rand.New(rand.NewSource(3))
+	old := make([]byte, 300<<10)
+	r.Read(old)
+	new := append([]byte(nil), old[:50<<10]...)
+	new = append(new, "inserted in the middle"...)
+	new = append(new, old[50<<10:120<<10]...)
+	new = append(new, old[125<<10:]...)
+	for i := 0; i < 100; i++ {
+		new[r.Intn(len(new))]++
+	}
+	unrelated := make([]byte, 40<<10)
+	r.Read(unrelated)
+	new = append(new, unrelated...)
+
+	var diff bytes.Buffer
+	if err := Diff(io.MultiReader(bytes.NewReader(old)), io.MultiReader(bytes.NewReader(new)), &diff, nil); err != nil {
+		t.Fatal(err)
+	}
+	if diff.Len() > len(unrelated)+len(new)/10 {
+		t.Errorf("diff is %d bytes for %d bytes of mostly old data", diff.Len(), len(new))
+	}
+	got, err := patchBytes(old, diff.Bytes(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, new) {
+		t.Fatalf("patched output differs at pos %d", matchlen(got, new))
+	}
+}
+
+// TestHpatchz checks that hpatchz applies the diffs Diff writes, so that
+// backups stay usable with the HDiffPatch tools.
+func TestHpatchz(t *testing.T) {
+	hpatchz, err := exec.LookPath("hpatchz")
+	if err != nil {
+		t.Skip("hpatchz not found")
+	}
+	r := rand.New(rand.NewSource(4))
+	old := make([]byte, 1<<20)
+	r.Read(old)
+	edited := append([]byte(nil), old...)
+	for i := 0; i < 200; i++ {
+		edited[r.Intn(len(edited))] ^= 0x5a
+	}
+	edited = append(edited[:300<<10], append([]byte("inserted"), edited[310<<10:]...)...)
+
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old")
+	diffFile := filepath.Join(dir, "diff")
+	outFile := filepath.Join(dir, "out")
+	if err := os.WriteFile(oldFile, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		new  []byte
+	}{
+		{"edited", edited},
+		{"identical", old},
+		{"empty", nil},
+		{"unrelated", old[:1000]},
+	}
+	for _, c := range cases {
+		if err := os.WriteFile(diffFile, diffBytes(t, old, c.new), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(outFile)
+		cmd := exec.Command(hpatchz, "-f", oldFile, diffFile, outFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: %v: %s", c.name, err, out)
+			continue
+		}
+		if !bytes.Equal(mustReadFile(t, outFile), c.new) {
+			t.Errorf("%s: hpatchz output differs", c.name)
+		}
+	}
+}
+
+func TestPatchProgress(t *testing.T) {
+	old := bytes.Repeat([]byte("0123456789abcdef"), 1<<17)
+	new := append(append([]byte(nil), old...), "tail"...)
+	diff := diffBytes(t, old, new)
+
+	var last, calls int64
+	opt := &Options{Progress: func(done, total int64) {
+		if done < last || total != int64(len(new)) {
+			t.Errorf("progress(%d, %d) after %d", done, total, last)
+		}
+		last = done
+		calls++
+	}}
+	if _, err := patchBytes(old, diff, opt); err != nil {
+		t.Fatal(err)
+	}
+	if calls < 2 || last != int64(len(new)) {
+		t.Errorf("got %d progress calls ending at %d, want the last at %d", calls, last, len(new))
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	old := mustReadFile(t, "testdata/sample.old")
+	diff := mustReadFile(t, "testdata/sample.sf20")
+
+	if _, err := patchBytes(old[:len(old)-1], diff, nil); !errors.Is(err, ErrOldSizeMismatch) {
+		t.Errorf("short old: got %v, want ErrOldSizeMismatch", err)
+	}
+	if _, err := patchBytes(old, diff[:len(diff)-10], nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("truncated diff: got %v, want ErrCorrupt", err)
+	}
+
+	var unsupported *UnsupportedError
+	lzma := append([]byte("HDIFF13&lzma\x00"), diff[15:]...)
+	if _, err := patchBytes(old, lzma, nil); !errors.As(err, &unsupported) {
+		t.Errorf("lzma diff: got %v, want *UnsupportedError", err)
+	}
+	if _, err := patchBytes(old, []byte("BSDIFF40"), nil); err == nil {
+		t.Error("bsdiff data: got nil error")
+	}
+}
+
+func TestUintEncoding(t *testing.T) {
+	values := []int64{0, 1, 0x3f, 0x40, 0x7f, 0x80, 0x3fff, 0x4000, 1<<32 + 5, 1 << 62}
+	for _, tagBits := range []uint{0, 1, 2} {
+		for _, v := range values {
+			tag := byte(1<<tagBits - 1)
+			b := appendUintWithTag(nil, v, tag, tagBits)
+			got, gotTag, err := readUintWithTag(bytes.NewReader(b), tagBits)
+			if err != nil || got != v || gotTag != tag {
+				t.Errorf("tagBits %d: %d encoded as %x decodes to %d, tag %d, %v", tagBits, v, b, got, gotTag, err)
+			}
+		}
+	}
+}
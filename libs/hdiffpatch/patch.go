@@ -0,0 +1,422 @@
+package hdiffpatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// rle control types of the HDIFF13 format (two tag bits per control).
+const (
+	rleZero  = 0 // run of 0x00
+	rle255   = 1 // run of 0xff
+	rleValue = 2 // run of one byte taken from the code stream
+	rleRaw   = 3 // bytes copied from the code stream
+)
+
+const (
+	copyBufSize   = 64 * 1024
+	progressEvery = 1 << 20
+)
+
+// Patch applies diff to old and writes the result to new. Both HDIFF13 and
+// HDIFFSF20 diffs are accepted. old and diff are read with ReadAt, so neither
+// has to fit in memory; oldSize and diffSize are their lengths.
+func Patch(old io.ReaderAt, oldSize int64, diff io.ReaderAt, diffSize int64, new io.Writer, opt *Options) error {
+	hr := &countingReader{r: bufio.NewReader(io.NewSectionReader(diff, 0, diffSize))}
+	typ, compress, err := readType(hr)
+	if err != nil {
+		return err
+	}
+	if compress != compressNone && compress != compressZstd {
+		return &UnsupportedError{Type: typ, Compress: compress}
+	}
+
+	p := &patcher{old: old, oldSize: oldSize, diff: diff, diffSize: diffSize, compress: compress, opt: opt}
+	switch typ {
+	case typeCompressed:
+		return p.patchCompressed(hr, new)
+	case typeSingle:
+		return p.patchSingle(hr, new)
+	}
+	return &UnsupportedError{Type: typ}
+}
+
+type patcher struct {
+	old      io.ReaderAt
+	oldSize  int64
+	diff     io.ReaderAt
+	diffSize int64
+	compress string
+	opt      *Options
+
+	buf     []byte
+	closers []func()
+}
+
+// patchCompressed applies an HDIFF13 diff: covers, rle control, rle code and
+// new data are stored one after another, each optionally compressed.
+func (p *patcher) patchCompressed(hr *countingReader, new io.Writer) error {
+	var head [11]int64
+	for i := range head {
+		v, err := readUint(hr)
+		if err != nil {
+			return err
+		}
+		head[i] = v
+	}
+	newSize, oldSize, coverCount := head[0], head[1], head[2]
+	if oldSize != p.oldSize {
+		return ErrOldSizeMismatch
+	}
+	defer p.close()
+
+	var sections [4]io.Reader
+	off := hr.n
+	for i := range sections {
+		size, compressedSize := head[3+2*i], head[4+2*i]
+		r, err := p.openSection(off, size, compressedSize)
+		if err != nil {
+			return err
+		}
+		sections[i] = r
+		if compressedSize > 0 {
+			off += compressedSize
+		} else {
+			off += size
+		}
+	}
+
+	covers := &coverReader{r: bufio.NewReader(sections[0])}
+	rle := &rleReader{ctrl: bufio.NewReader(sections[1]), code: bufio.NewReader(sections[2])}
+	newData := bufio.NewReader(sections[3])
+	out := p.newOutput(new, newSize)
+
+	for i := int64(0); i < coverCount; i++ {
+		c, err := covers.next()
+		if err != nil {
+			return err
+		}
+		if err := p.checkCover(c, out.done, newSize); err != nil {
+			return err
+		}
+		gap := c.newPos - out.done
+		if err := copyNewData(out, newData, gap); err != nil {
+			return err
+		}
+		if err := rle.skip(gap); err != nil {
+			return err
+		}
+		if err := p.applyCover(out, c, rle.add); err != nil {
+			return err
+		}
+	}
+	if err := copyNewData(out, newData, newSize-out.done); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// patchSingle applies an HDIFFSF20 diff: a single (optionally compressed)
+// stream of steps, each holding covers, their rle data and the new data
+// in front of those covers.
+func (p *patcher) patchSingle(hr *countingReader, new io.Writer) error {
+	var head [6]int64
+	for i := range head {
+		v, err := readUint(hr)
+		if err != nil {
+			return err
+		}
+		head[i] = v
+	}
+	newSize, oldSize, coverCount, stepMemSize := head[0], head[1], head[2], head[3]
+	if oldSize != p.oldSize {
+		return ErrOldSizeMismatch
+	}
+	if stepMemSize > head[4] {
+		// the step buffer is allocated up front; a step never exceeds the body
+		return ErrCorrupt
+	}
+	defer p.close()
+
+	body, err := p.openSection(hr.n, head[4], head[5])
+	if err != nil {
+		return err
+	}
+	stream := bufio.NewReader(body)
+	out := p.newOutput(new, newSize)
+	stepBuf := make([]byte, stepMemSize)
+	covers := &coverReader{}
+
+	for done := int64(0); done < coverCount; {
+		coverLen, err := readUint(stream)
+		if err != nil {
+			return err
+		}
+		rleLen, err := readUint(stream)
+		if err != nil {
+			return err
+		}
+		if coverLen+rleLen > stepMemSize {
+			return ErrCorrupt
+		}
+		step := stepBuf[:coverLen+rleLen]
+		if _, err := io.ReadFull(stream, step); err != nil {
+			return unexpected(err)
+		}
+
+		coverData := bytes.NewReader(step[:coverLen])
+		covers.r = coverData
+		rle := &singleRle{r: bytes.NewReader(step[coverLen:])}
+		for coverData.Len() > 0 {
+			c, err := covers.next()
+			if err != nil {
+				return err
+			}
+			if err := p.checkCover(c, out.done, newSize); err != nil {
+				return err
+			}
+			if err := copyNewData(out, stream, c.newPos-out.done); err != nil {
+				return err
+			}
+			if err := p.applyCover(out, c, rle.add); err != nil {
+				return err
+			}
+			done++
+		}
+	}
+	if err := copyNewData(out, stream, newSize-out.done); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// openSection returns a reader for size bytes of (possibly compressed)
+// data stored at off in the diff.
+func (p *patcher) openSection(off, size, compressedSize int64) (io.Reader, error) {
+	stored := size
+	if compressedSize > 0 {
+		stored = compressedSize
+	}
+	if off < 0 || stored < 0 || off+stored > p.diffSize {
+		return nil, ErrCorrupt
+	}
+	r := io.Reader(io.NewSectionReader(p.diff, off, stored))
+	if compressedSize == 0 {
+		return r, nil
+	}
+	if p.compress != compressZstd {
+		return nil, ErrCorrupt
+	}
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	p.closers = append(p.closers, dec.Close)
+	return io.LimitReader(dec, size), nil
+}
+
+func (p *patcher) close() {
+	for _, c := range p.closers {
+		c()
+	}
+}
+
+func (p *patcher) checkCover(c cover, written, newSize int64) error {
+	if c.newPos < written || c.newPos+c.length > newSize || c.oldPos+c.length > p.oldSize {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+// applyCover writes old[c.oldPos:] plus the decoded byte-wise difference.
+func (p *patcher) applyCover(out *output, c cover, add func([]byte) error) error {
+	if p.buf == nil {
+		p.buf = make([]byte, copyBufSize)
+	}
+	for done := int64(0); done < c.length; {
+		b := p.buf[:min(int64(len(p.buf)), c.length-done)]
+		if _, err := p.old.ReadAt(b, c.oldPos+done); err != nil {
+			if err == io.EOF {
+				return ErrOldSizeMismatch
+			}
+			return err
+		}
+		if err := add(b); err != nil {
+			return err
+		}
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+		done += int64(len(b))
+	}
+	return nil
+}
+
+func copyNewData(out *output, r io.Reader, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if _, err := io.CopyN(out, r, n); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+// output buffers the new data and reports progress as it is written.
+// (bufio.Writer is not embedded so that io.Copy cannot bypass Write.)
+type output struct {
+	w                 *bufio.Writer
+	done, total, next int64
+	opt               *Options
+}
+
+func (p *patcher) newOutput(w io.Writer, total int64) *output {
+	return &output{w: bufio.NewWriterSize(w, copyBufSize), total: total, next: progressEvery, opt: p.opt}
+}
+
+func (o *output) Flush() error { return o.w.Flush() }
+
+func (o *output) Write(b []byte) (int, error) {
+	n, err := o.w.Write(b)
+	o.done += int64(n)
+	if o.done >= o.next || o.done == o.total {
+		o.next = o.done + progressEvery
+		o.opt.progress(o.done, o.total)
+	}
+	return n, err
+}
+
+// rleReader decodes the HDIFF13 byte-wise difference, which spans the whole
+// new data; the parts not under a cover are skipped.
+type rleReader struct {
+	ctrl io.ByteReader
+	code *bufio.Reader
+	typ  byte
+	val  byte
+	left int64
+}
+
+func (r *rleReader) fill() error {
+	v, typ, err := readUintWithTag(r.ctrl, 2)
+	if err != nil {
+		return err
+	}
+	r.typ, r.left = typ, v+1
+	switch typ {
+	case rleZero:
+		r.val = 0
+	case rle255:
+		r.val = 0xff
+	case rleValue:
+		if r.val, err = r.code.ReadByte(); err != nil {
+			return unexpected(err)
+		}
+	}
+	return nil
+}
+
+func (r *rleReader) add(b []byte) error {
+	for len(b) > 0 {
+		if r.left == 0 {
+			if err := r.fill(); err != nil {
+				return err
+			}
+		}
+		n := min(int64(len(b)), r.left)
+		if r.typ == rleRaw {
+			for i := int64(0); i < n; i++ {
+				c, err := r.code.ReadByte()
+				if err != nil {
+					return unexpected(err)
+				}
+				b[i] += c
+			}
+		} else if r.val != 0 {
+			for i := int64(0); i < n; i++ {
+				b[i] += r.val
+			}
+		}
+		b = b[n:]
+		r.left -= n
+	}
+	return nil
+}
+
+func (r *rleReader) skip(n int64) error {
+	for n > 0 {
+		if r.left == 0 {
+			if err := r.fill(); err != nil {
+				return err
+			}
+		}
+		k := min(n, r.left)
+		if r.typ == rleRaw {
+			if _, err := r.code.Discard(int(k)); err != nil {
+				return unexpected(err)
+			}
+		}
+		n -= k
+		r.left -= k
+	}
+	return nil
+}
+
+// singleRle decodes the HDIFFSF20 byte-wise difference of one step: an
+// alternating sequence of zero run lengths and raw byte strings.
+type singleRle struct {
+	r       *bytes.Reader
+	zeros   int64
+	raw     int64
+	wantRaw bool
+}
+
+func (s *singleRle) add(b []byte) error {
+	for len(b) > 0 {
+		switch {
+		case s.zeros > 0:
+			n := min(int64(len(b)), s.zeros)
+			b = b[n:]
+			s.zeros -= n
+		case s.raw > 0:
+			n := min(int64(len(b)), s.raw)
+			for i := int64(0); i < n; i++ {
+				c, err := s.r.ReadByte()
+				if err != nil {
+					return unexpected(err)
+				}
+				b[i] += c
+			}
+			b = b[n:]
+			s.raw -= n
+		default:
+			v, err := readUint(s.r)
+			if err != nil {
+				return err
+			}
+			if s.wantRaw {
+				s.raw = v
+			} else {
+				s.zeros = v
+			}
+			s.wantRaw = !s.wantRaw
+		}
+	}
+	return nil
+}
+
+// countingReader tracks how many header bytes have been consumed.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
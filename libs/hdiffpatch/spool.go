@@ -0,0 +1,93 @@
+package hdiffpatch
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// spoolMemory is how much a spool keeps in memory before moving its data
+// to a temporary file.
+var spoolMemory = 8 << 20
+
+// spool collects written data, in memory while it is small and in a
+// temporary file after that.
+type spool struct {
+	buf  []byte
+	file *os.File
+	size int64
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	if s.file == nil && len(s.buf)+len(p) > spoolMemory {
+		f, err := os.CreateTemp("", "hdiffpatch-")
+		if err != nil {
+			return 0, err
+		}
+		if _, err := f.Write(s.buf); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return 0, err
+		}
+		s.file, s.buf = f, nil
+	}
+	if s.file != nil {
+		n, err := s.file.Write(p)
+		s.size += int64(n)
+		return n, err
+	}
+	s.buf = append(s.buf, p...)
+	s.size += int64(len(p))
+	return len(p), nil
+}
+
+func (s *spool) readerAt() io.ReaderAt {
+	if s.file != nil {
+		return s.file
+	}
+	return bytes.NewReader(s.buf)
+}
+
+func (s *spool) WriteTo(w io.Writer) (int64, error) {
+	return io.Copy(w, io.NewSectionReader(s.readerAt(), 0, s.size))
+}
+
+// Close releases the data, removing the temporary file if there is one.
+func (s *spool) Close() error {
+	s.buf = nil
+	if s.file == nil {
+		return nil
+	}
+	name := s.file.Name()
+	s.file.Close()
+	s.file = nil
+	return os.Remove(name)
+}
+
+type readAtSeeker interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// openReaderAt gives random access to the rest of r and returns its size.
+// Readers that cannot seek are spooled first; close releases the spool.
+func openReaderAt(r io.Reader) (ra io.ReaderAt, size int64, close func() error, err error) {
+	if rs, ok := r.(readAtSeeker); ok {
+		off, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		end, err := rs.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		return io.NewSectionReader(rs, off, end-off), end - off, func() error { return nil }, nil
+	}
+
+	s := new(spool)
+	if _, err := io.Copy(s, r); err != nil {
+		s.Close()
+		return nil, 0, nil, err
+	}
+	return s.readerAt(), s.size, s.Close, nil
+}
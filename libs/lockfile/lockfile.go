@@ -0,0 +1,173 @@
+// Package lockfile provides an advisory lock between processes, held by
+// creating a file with O_EXCL.
+//
+// While a Lock is held its file's modification time is refreshed every
+// quarter of the stale age. A lock file older than the stale age is taken
+// to be left over from a process that exited without unlocking (a crash or
+// a power cut) and is removed by the next process that wants the lock.
+// Processes that find the same stale file take turns removing it through a
+// second lock file, so that a late one does not remove the lock an earlier
+// one has taken in the meantime.
+package lockfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultStaleAge is a stale age for locks held across slow disk work.
+const DefaultStaleAge = 2 * time.Minute
+
+// ErrLocked is returned by TryLock when another holder has the lock.
+var ErrLocked = errors.New("lockfile: locked by another process")
+
+// Lock is a held lock.
+type Lock struct {
+	path  string
+	token string // the file's content, telling this lock from a later one
+	stop  chan struct{}
+	once  sync.Once
+	done  chan struct{}
+}
+
+// TryLock takes the lock at path without waiting. It returns ErrLocked if
+// a lock file younger than staleAge exists.
+func TryLock(path string, staleAge time.Duration) (*Lock, error) {
+	for attempt := 0; ; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			host, _ := os.Hostname()
+			token := fmt.Sprintf("pid %d on %s at %s\n", os.Getpid(), host, time.Now().Format(time.RFC3339Nano))
+			_, err := f.WriteString(token)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, err
+			}
+			l := &Lock{path: path, token: token, stop: make(chan struct{}), done: make(chan struct{})}
+			go l.refresh(staleAge / 4)
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // unlocked in the meantime
+		}
+		if err != nil {
+			return nil, err
+		}
+		if time.Since(info.ModTime()) < staleAge || attempt > 0 {
+			return nil, ErrLocked
+		}
+		// Left over by a holder that is gone: remove it and try once more.
+		if err := breakStale(path, staleAge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// breakStale removes the lock file at path if it is still older than
+// staleAge. It does so holding path+".break", and checks the age again
+// once it has it: another process that found the same stale file may have
+// removed it and taken the lock since, and that lock must stay. If another
+// process is breaking the lock it does nothing.
+func breakStale(path string, staleAge time.Duration) error {
+	brk := path + ".break"
+	f, err := os.OpenFile(brk, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		// Left behind by a process that died while breaking the lock?
+		info, statErr := os.Stat(brk)
+		if statErr != nil || time.Since(info.ModTime()) < staleAge {
+			return nil
+		}
+		if err := os.Remove(brk); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		f, err = os.OpenFile(brk, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(brk)
+
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if time.Since(info.ModTime()) < staleAge {
+		return nil // taken again
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Acquire takes the lock at path, trying again every retry until it is
+// free or ctx is done.
+func Acquire(ctx context.Context, path string, staleAge, retry time.Duration) (*Lock, error) {
+	for {
+		l, err := TryLock(path, staleAge)
+		if !errors.Is(err, ErrLocked) {
+			return l, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ErrLocked, ctx.Err())
+		case <-time.After(retry):
+		}
+	}
+}
+
+// refresh keeps the lock file's modification time current until Unlock.
+func (l *Lock) refresh(every time.Duration) {
+	defer close(l.done)
+	if every <= 0 {
+		<-l.stop
+		return
+	}
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			now := time.Now()
+			os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+// Unlock releases the lock by removing its file. If the file is no longer
+// this lock's (it was not refreshed for staleAge, say while the computer
+// slept, and another process took the lock) it is left alone. Calling
+// Unlock again does nothing.
+func (l *Lock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		data, readErr := os.ReadFile(l.path)
+		if readErr != nil || string(data) != l.token {
+			return
+		}
+		err = os.Remove(l.path)
+	})
+	return err
+}
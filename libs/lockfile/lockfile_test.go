@@ -0,0 +1,166 @@
+package lockfile
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryLock(path, time.Minute); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second TryLock: %v, want ErrLocked", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file left after Unlock: %v", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("second Unlock: %v", err)
+	}
+
+	l, err = TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock after Unlock: %v", err)
+	}
+	l.Unlock()
+}
+
+func TestTryLockRemovesStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	if err := os.WriteFile(path, []byte("pid 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock over a stale lock: %v", err)
+	}
+	l.Unlock()
+}
+
+// A process that found the same stale file as another must not remove the
+// lock the other has taken since.
+func TestBreakStaleKeepsNewLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	if err := os.WriteFile(path, []byte("pid 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	// The late process goes on to break the lock it saw as stale.
+	if err := breakStale(path, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != l.token {
+		t.Fatalf("lock file after breakStale: %q, %v; want %q", data, err, l.token)
+	}
+	if _, err := os.Stat(path + ".break"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("break file left: %v", err)
+	}
+}
+
+// While another process is breaking a stale lock, TryLock reports it locked.
+func TestTryLockWhileBreaking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	if err := os.WriteFile(path, []byte("pid 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	if err := os.WriteFile(path+".break", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryLock(path, time.Minute); !errors.Is(err, ErrLocked) {
+		t.Fatalf("TryLock: %v, want ErrLocked", err)
+	}
+
+	// A break file as old as a stale lock was left by a process that died.
+	os.Chtimes(path+".break", old, old)
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatalf("TryLock past a stale break file: %v", err)
+	}
+	l.Unlock()
+}
+
+// Unlock leaves a lock file that another process has taken over.
+func TestUnlockKeepsOthersLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("pid 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("another process's lock file removed: %v", err)
+	}
+}
+
+// A held lock stays fresh however long it is held.
+func TestRefresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l, err := TryLock(path, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	time.Sleep(300 * time.Millisecond)
+	if _, err := TryLock(path, 100*time.Millisecond); !errors.Is(err, ErrLocked) {
+		t.Fatalf("TryLock on a held lock: %v, want ErrLocked", err)
+	}
+}
+
+func TestAcquireWaits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Unlock()
+	}()
+	l2, err := Acquire(context.Background(), path, time.Minute, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2.Unlock()
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".lock")
+	l, err := TryLock(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = Acquire(ctx, path, time.Minute, 10*time.Millisecond)
+	if !errors.Is(err, ErrLocked) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire: %v, want ErrLocked and DeadlineExceeded", err)
+	}
+}
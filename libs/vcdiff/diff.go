@@ -0,0 +1,387 @@
+package vcdiff
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"io"
+)
+
+const (
+	// blockLen is the length of the source blocks put in the hash table;
+	// any match of at least 2*blockLen-1 bytes is found.
+	blockLen = 16
+
+	// minRun is the shortest run of one byte written as a RUN.
+	minRun = 8
+
+	// targetWindow is the size of the target windows Diff writes, the
+	// xdelta3 default.
+	targetWindow = 8 << 20
+)
+
+// Diff computes the difference between old (the source) and new (the
+// target) and writes it to delta in VCDIFF format. old is read into
+// memory; new is read and encoded window by window.
+func Diff(old, new io.Reader, delta io.Writer) error {
+	src, err := io.ReadAll(old)
+	if err != nil {
+		return err
+	}
+
+	hdr := append(magic[:], 0)
+	if _, err := delta.Write(hdr); err != nil {
+		return err
+	}
+
+	e := &encoder{src: src, srcTable: indexSource(src)}
+	buf := make([]byte, targetWindow)
+	for {
+		n, err := io.ReadFull(new, buf)
+		if n > 0 {
+			if werr := e.window(buf[:n], delta); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// op is one instruction of a window before it is encoded. COPY addresses
+// are positions in the source, or in the target window plus targetBase.
+type op struct {
+	typ  byte
+	size int64
+	pos  int64 // ADD: offset in the window; RUN: the byte; COPY: address
+}
+
+// targetBase marks COPY addresses in the target window; the real address
+// is only known once the source segment is.
+const targetBase = 1 << 62
+
+type encoder struct {
+	src      []byte
+	srcTable *hashTable
+	tgtTable *hashTable
+	ops      []op
+	cache    addrCache
+
+	data, inst, addr []byte
+}
+
+// window encodes w as one target window.
+func (e *encoder) window(w []byte, out io.Writer) error {
+	e.ops = e.findOps(w)
+
+	// The source segment spans the source data the copies use.
+	segLo, segHi := int64(len(e.src)), int64(0)
+	for _, o := range e.ops {
+		if o.typ == instCopy && o.pos < targetBase {
+			if o.pos < segLo {
+				segLo = o.pos
+			}
+			if o.pos+o.size > segHi {
+				segHi = o.pos + o.size
+			}
+		}
+	}
+	if segHi < segLo {
+		segLo, segHi = 0, 0
+	}
+	segLen := segHi - segLo
+
+	e.data, e.inst, e.addr = e.data[:0], e.inst[:0], e.addr[:0]
+	e.cache.reset()
+	here := segLen
+	addrMode := make([]byte, len(e.ops))
+	for i := range e.ops {
+		o := &e.ops[i]
+		if o.typ == instCopy {
+			if o.pos >= targetBase {
+				o.pos = o.pos - targetBase + segLen
+			} else {
+				o.pos -= segLo
+			}
+			addrMode[i] = e.encodeAddr(o.pos, here)
+		}
+		here += o.size
+	}
+	e.encodeInsts(w, addrMode)
+
+	var hdr []byte
+	ind := byte(winAdler32)
+	if segLen > 0 {
+		ind |= winSource
+	}
+	hdr = append(hdr, ind)
+	if segLen > 0 {
+		hdr = appendUint(hdr, segLen)
+		hdr = appendUint(hdr, segLo)
+	}
+	body := appendUint(nil, int64(len(w)))
+	body = append(body, 0) // Delta_Indicator: no secondary compression
+	body = appendUint(body, int64(len(e.data)))
+	body = appendUint(body, int64(len(e.inst)))
+	body = appendUint(body, int64(len(e.addr)))
+	body = binary.BigEndian.AppendUint32(body, adler32.Checksum(w))
+	hdr = appendUint(hdr, int64(len(body)+len(e.data)+len(e.inst)+len(e.addr)))
+
+	for _, b := range [][]byte{hdr, body, e.data, e.inst, e.addr} {
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeAddr writes the address of a COPY at position here, choosing the
+// shortest mode, and returns the mode.
+func (e *encoder) encodeAddr(addr, here int64) byte {
+	defer e.cache.update(addr)
+	if s := addr % (sameSize * 256); e.cache.same[s] == addr {
+		e.addr = append(e.addr, byte(s%256))
+		return modeSame + byte(s/256)
+	}
+	mode, v := byte(modeSelf), addr
+	if d := here - addr; d < v {
+		mode, v = modeHere, d
+	}
+	for i, n := range e.cache.near {
+		if d := addr - n; d >= 0 && d < v {
+			mode, v = modeNear+byte(i), d
+		}
+	}
+	e.addr = appendUint(e.addr, v)
+	return mode
+}
+
+// encodeInsts writes the instructions and their data, pairing them where
+// the default code table has a combined code.
+func (e *encoder) encodeInsts(w []byte, mode []byte) {
+	for i := 0; i < len(e.ops); i++ {
+		o := e.ops[i]
+		var next *op
+		if i+1 < len(e.ops) {
+			next = &e.ops[i+1]
+		}
+		switch o.typ {
+		case instAdd:
+			e.data = append(e.data, w[o.pos:o.pos+o.size]...)
+			if next != nil && next.typ == instCopy && o.size <= 4 {
+				m := mode[i+1]
+				if m < modeSame && next.size >= 4 && next.size <= 6 {
+					e.inst = append(e.inst, byte(163+int64(m)*12+(o.size-1)*3+(next.size-4)))
+					i++
+					continue
+				}
+				if m >= modeSame && next.size == 4 {
+					e.inst = append(e.inst, byte(235+int64(m-modeSame)*4+(o.size-1)))
+					i++
+					continue
+				}
+			}
+			if o.size <= 17 {
+				e.inst = append(e.inst, byte(1+o.size))
+			} else {
+				e.inst = appendUint(append(e.inst, 1), o.size)
+			}
+
+		case instRun:
+			e.data = append(e.data, byte(o.pos))
+			e.inst = appendUint(append(e.inst, 0), o.size)
+
+		case instCopy:
+			m := mode[i]
+			if o.size == 4 && next != nil && next.typ == instAdd && next.size == 1 {
+				e.inst = append(e.inst, 247+m)
+				e.data = append(e.data, w[next.pos])
+				i++
+				continue
+			}
+			if o.size >= 4 && o.size <= 18 {
+				e.inst = append(e.inst, byte(19+int64(m)*16+(o.size-3)))
+			} else {
+				e.inst = appendUint(append(e.inst, 19+m*16), o.size)
+			}
+		}
+	}
+}
+
+// findOps splits w into ADD, RUN and COPY instructions. Matches are looked
+// up by the hash of blockLen bytes, in the source and in the part of the
+// window already encoded, and extended in both directions.
+func (e *encoder) findOps(w []byte) []op {
+	ops := e.ops[:0]
+	if e.tgtTable == nil {
+		e.tgtTable = newHashTable(targetWindow / blockLen)
+	} else {
+		e.tgtTable.reset()
+	}
+	src := e.src
+
+	pending := 0 // start of the bytes not covered yet
+	flush := func(end int) {
+		if end > pending {
+			ops = append(ops, op{typ: instAdd, size: int64(end - pending), pos: int64(pending)})
+		}
+	}
+	indexed := 0 // target blocks before this offset are in tgtTable
+	indexTo := func(end int) {
+		for ; indexed+blockLen <= end; indexed += blockLen {
+			e.tgtTable.put(hashBlock(w[indexed:indexed+blockLen]), indexed)
+		}
+	}
+
+	i := 0
+	var h uint64
+	if len(w) >= blockLen {
+		h = hashBlock(w[:blockLen])
+	}
+	for i+blockLen <= len(w) {
+		// Runs of one byte.
+		if w[i] == w[i+1] {
+			n := 1
+			for i+n < len(w) && w[i+n] == w[i] {
+				n++
+			}
+			if n >= minRun {
+				start := i
+				for start > pending && w[start-1] == w[i] {
+					start--
+				}
+				flush(start)
+				ops = append(ops, op{typ: instRun, size: int64(i + n - start), pos: int64(w[i])})
+				i += n
+				pending = i
+				indexTo(i)
+				if i+blockLen <= len(w) {
+					h = hashBlock(w[i : i+blockLen])
+				}
+				continue
+			}
+		}
+
+		var bestLen, bestBack int
+		var bestAddr int64
+		if p, ok := e.srcTable.get(h); ok && p+blockLen <= len(src) {
+			fwd := matchLen(src[p:], w[i:])
+			if fwd >= blockLen {
+				back := 0
+				for i-back > pending && p-back > 0 && src[p-back-1] == w[i-back-1] {
+					back++
+				}
+				bestLen, bestBack, bestAddr = fwd, back, int64(p)
+			}
+		}
+		if p, ok := e.tgtTable.get(h); ok && p < i {
+			fwd := matchLen(w[p:], w[i:])
+			if fwd >= blockLen {
+				back := 0
+				for i-back > pending && p-back > 0 && w[p-back-1] == w[i-back-1] {
+					back++
+				}
+				if fwd+back > bestLen+bestBack {
+					bestLen, bestBack, bestAddr = fwd, back, targetBase+int64(p)
+				}
+			}
+		}
+
+		if bestLen > 0 {
+			flush(i - bestBack)
+			ops = append(ops, op{typ: instCopy, size: int64(bestBack + bestLen), pos: bestAddr - int64(bestBack)})
+			i += bestLen
+			pending = i
+			indexTo(i)
+			if i+blockLen <= len(w) {
+				h = hashBlock(w[i : i+blockLen])
+			}
+			continue
+		}
+
+		if i%blockLen == 0 {
+			indexTo(i)
+		}
+		if i+blockLen < len(w) {
+			h = rollHash(h, w[i], w[i+blockLen])
+		}
+		i++
+	}
+	flush(len(w))
+	return ops
+}
+
+// Rolling hash of blockLen bytes (polynomial, mod 2^64).
+const hashMul = 0x100000001b3
+
+var hashOutMul = func() uint64 {
+	m := uint64(1)
+	for i := 0; i < blockLen; i++ {
+		m *= hashMul
+	}
+	return m
+}()
+
+func hashBlock(b []byte) uint64 {
+	var h uint64
+	for _, c := range b[:blockLen] {
+		h = h*hashMul + uint64(c)
+	}
+	return h
+}
+
+func rollHash(h uint64, out, in byte) uint64 {
+	return h*hashMul + uint64(in) - uint64(out)*hashOutMul
+}
+
+func matchLen(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// hashTable maps block hashes to the last position put with that hash.
+type hashTable struct {
+	slots []uint32 // position/blockLen + 1, 0 if empty
+	bits  uint
+}
+
+func newHashTable(blocks int) *hashTable {
+	bits := uint(10)
+	for bits < 30 && 1<<bits < 2*blocks {
+		bits++
+	}
+	return &hashTable{slots: make([]uint32, 1<<bits), bits: bits}
+}
+
+func indexSource(src []byte) *hashTable {
+	t := newHashTable(len(src) / blockLen)
+	for p := 0; p+blockLen <= len(src); p += blockLen {
+		t.put(hashBlock(src[p:p+blockLen]), p)
+	}
+	return t
+}
+
+func (t *hashTable) slot(h uint64) uint64 {
+	return (h * 0x9e3779b97f4a7c15) >> (64 - t.bits)
+}
+
+func (t *hashTable) put(h uint64, pos int) {
+	t.slots[t.slot(h)] = uint32(pos/blockLen) + 1
+}
+
+func (t *hashTable) get(h uint64) (int, bool) {
+	v := t.slots[t.slot(h)]
+	return int(v-1) * blockLen, v != 0
+}
+
+func (t *hashTable) reset() {
+	for i := range t.slots {
+		t.slots[i] = 0
+	}
+}
@@ -0,0 +1,204 @@
+// Package vcdiff implements the VCDIFF generic differencing and compression
+// data format (RFC 3284) in pure Go.
+//
+// Patch decodes deltas written by `xdelta3 -e -S none` (xdelta3's Adler-32
+// window checksums and application header are understood; secondary
+// compressors and custom code tables are not). Diff writes deltas with the
+// default code table that `xdelta3 -d` can decode.
+package vcdiff
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Header bytes: 'V', 'C', 'D' with the high bits set, then version 0.
+var magic = [4]byte{0xd6, 0xc3, 0xc4, 0x00}
+
+// Hdr_Indicator bits.
+const (
+	hdrSecondary = 1 << 0 // VCD_DECOMPRESS: a secondary compressor id follows
+	hdrCodeTable = 1 << 1 // VCD_CODETABLE: an application-defined code table follows
+	hdrAppHeader = 1 << 2 // xdelta3: an application header follows
+)
+
+// Win_Indicator bits.
+const (
+	winSource  = 1 << 0 // VCD_SOURCE: the segment is from the source
+	winTarget  = 1 << 1 // VCD_TARGET: the segment is from earlier target data
+	winAdler32 = 1 << 2 // xdelta3: an Adler-32 of the target window follows
+)
+
+// maxWindow bounds the target window size Patch accepts, so a corrupt
+// delta cannot make it allocate huge buffers. xdelta3 never writes windows
+// larger than 16 MiB.
+const maxWindow = 64 << 20
+
+var (
+	// ErrCorrupt is returned when the delta is truncated or inconsistent.
+	ErrCorrupt = errors.New("vcdiff: corrupt delta data")
+
+	// ErrChecksum is returned when a decoded window does not match the
+	// Adler-32 checksum stored with it, usually because the source is not
+	// the file the delta was created from.
+	ErrChecksum = errors.New("vcdiff: target window checksum mismatch")
+)
+
+// UnsupportedError is returned for VCDIFF features this package cannot
+// decode.
+type UnsupportedError struct {
+	Feature string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("vcdiff: unsupported feature: %s", e.Feature)
+}
+
+// IsDelta reports whether head (the first bytes of a file) starts with the
+// VCDIFF magic.
+func IsDelta(head []byte) bool {
+	return len(head) >= 3 && head[0] == magic[0] && head[1] == magic[1] && head[2] == magic[2]
+}
+
+// Instruction types.
+const (
+	instNoop = iota
+	instAdd
+	instRun
+	instCopy
+)
+
+// Address cache sizes of the default code table.
+const (
+	nearSize = 4
+	sameSize = 3
+)
+
+// code is one entry of a code table: up to two instructions, each with a
+// size (0: read from the instruction section) and a COPY address mode.
+type code struct {
+	typ1, size1, mode1 byte
+	typ2, size2, mode2 byte
+}
+
+// defaultCodeTable is the code table of RFC 3284 section 5.6.
+var defaultCodeTable = func() (t [256]code) {
+	i := 0
+	add := func(c code) { t[i] = c; i++ }
+	add(code{typ1: instRun})
+	for size := byte(0); size <= 17; size++ {
+		add(code{typ1: instAdd, size1: size})
+	}
+	for mode := byte(0); mode < 2+nearSize+sameSize; mode++ {
+		add(code{typ1: instCopy, mode1: mode})
+		for size := byte(4); size <= 18; size++ {
+			add(code{typ1: instCopy, size1: size, mode1: mode})
+		}
+	}
+	for mode := byte(0); mode < 2+nearSize; mode++ {
+		for addSize := byte(1); addSize <= 4; addSize++ {
+			for copySize := byte(4); copySize <= 6; copySize++ {
+				add(code{instAdd, addSize, 0, instCopy, copySize, mode})
+			}
+		}
+	}
+	for mode := byte(2 + nearSize); mode < 2+nearSize+sameSize; mode++ {
+		for addSize := byte(1); addSize <= 4; addSize++ {
+			add(code{instAdd, addSize, 0, instCopy, 4, mode})
+		}
+	}
+	for mode := byte(0); mode < 2+nearSize+sameSize; mode++ {
+		add(code{instCopy, 4, mode, instAdd, 1, 0})
+	}
+	return t
+}()
+
+// Address modes.
+const (
+	modeSelf = 0 // the address itself
+	modeHere = 1 // the distance back from the current position
+	modeNear = 2 // the distance from one of the nearSize last addresses
+	modeSame = modeNear + nearSize
+)
+
+// addrCache is the address cache of RFC 3284 section 5.1, shared by the
+// encoder and the decoder and reset at the start of every window.
+type addrCache struct {
+	near     [nearSize]int64
+	nextSlot int
+	same     [sameSize * 256]int64
+}
+
+func (c *addrCache) reset() {
+	*c = addrCache{}
+}
+
+func (c *addrCache) update(addr int64) {
+	c.near[c.nextSlot] = addr
+	c.nextSlot = (c.nextSlot + 1) % nearSize
+	c.same[addr%(sameSize*256)] = addr
+}
+
+// appendUint appends the RFC 3284 variable-length encoding of v: base 128,
+// most significant digit first, with the high bit set on all but the last.
+func appendUint(b []byte, v int64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(v & 0x7f)
+	for v >>= 7; v > 0; v >>= 7 {
+		i--
+		tmp[i] = byte(v&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+func uintLen(v int64) int {
+	n := 1
+	for v >>= 7; v > 0; v >>= 7 {
+		n++
+	}
+	return n
+}
+
+// readUint reads an integer written by appendUint.
+func readUint(r io.ByteReader) (int64, error) {
+	var v int64
+	for i := 0; i < 9; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = ErrCorrupt
+			}
+			return 0, err
+		}
+		v = v<<7 | int64(b&0x7f)
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, ErrCorrupt
+}
+
+// sliceReader reads integers and bytes from one section of a window.
+type sliceReader struct {
+	b []byte
+}
+
+func (r *sliceReader) ReadByte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, ErrCorrupt
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
+
+func (r *sliceReader) next(n int64) ([]byte, error) {
+	if n < 0 || n > int64(len(r.b)) {
+		return nil, ErrCorrupt
+	}
+	p := r.b[:n]
+	r.b = r.b[n:]
+	return p, nil
+}
@@ -0,0 +1,309 @@
+package vcdiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+)
+
+// Patch applies the VCDIFF delta read from delta to old (oldSize bytes,
+// the source) and writes the target to new. Windows are decoded one at a
+// time, so memory use depends on the window sizes rather than the file
+// sizes.
+func Patch(old io.ReaderAt, oldSize int64, delta io.Reader, new io.Writer) error {
+	r := bufio.NewReader(delta)
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	if !IsDelta(hdr[:]) {
+		return ErrCorrupt
+	}
+	if hdr[3] != magic[3] {
+		return &UnsupportedError{fmt.Sprintf("version %d", hdr[3])}
+	}
+	ind := hdr[4]
+	if ind&^(hdrSecondary|hdrCodeTable|hdrAppHeader) != 0 {
+		return ErrCorrupt
+	}
+	if ind&hdrSecondary != 0 {
+		id, err := r.ReadByte()
+		if err != nil {
+			return ErrCorrupt
+		}
+		return &UnsupportedError{fmt.Sprintf("secondary compressor %d (create the delta with xdelta3 -S none)", id)}
+	}
+	if ind&hdrCodeTable != 0 {
+		return &UnsupportedError{"application-defined code table"}
+	}
+	if ind&hdrAppHeader != 0 {
+		n, err := readUint(r)
+		if err != nil {
+			return err
+		}
+		if _, err := io.CopyN(io.Discard, r, n); err != nil {
+			return ErrCorrupt
+		}
+	}
+
+	d := &decoder{old: old, oldSize: oldSize}
+	for {
+		ind, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.window(r, ind, new); err != nil {
+			return err
+		}
+	}
+}
+
+type decoder struct {
+	old     io.ReaderAt
+	oldSize int64
+	cache   addrCache
+
+	// Buffers reused from window to window.
+	delta, seg, tgt []byte
+}
+
+// window decodes one window whose Win_Indicator ind has been read.
+func (d *decoder) window(r *bufio.Reader, ind byte, w io.Writer) error {
+	if ind&winTarget != 0 {
+		return &UnsupportedError{"VCD_TARGET windows"}
+	}
+	if ind&^(winSource|winAdler32) != 0 {
+		return ErrCorrupt
+	}
+
+	var segLen, segPos int64
+	var err error
+	if ind&winSource != 0 {
+		if segLen, err = readUint(r); err != nil {
+			return err
+		}
+		if segPos, err = readUint(r); err != nil {
+			return err
+		}
+		if segPos > d.oldSize || segLen > d.oldSize-segPos {
+			return ErrCorrupt
+		}
+	}
+
+	deltaLen, err := readUint(r)
+	if err != nil {
+		return err
+	}
+	if deltaLen > 3*maxWindow {
+		return ErrCorrupt
+	}
+	d.delta = grow(d.delta, deltaLen)
+	if _, err := io.ReadFull(r, d.delta); err != nil {
+		return ErrCorrupt
+	}
+
+	s := &sliceReader{d.delta}
+	tgtLen, err := readUint(s)
+	if err != nil {
+		return err
+	}
+	if tgtLen > maxWindow {
+		return ErrCorrupt
+	}
+	deltaInd, err := s.ReadByte()
+	if err != nil {
+		return err
+	}
+	if deltaInd != 0 {
+		return &UnsupportedError{"secondary compression of window sections (create the delta with xdelta3 -S none)"}
+	}
+	var lens [3]int64
+	for i := range lens {
+		if lens[i], err = readUint(s); err != nil {
+			return err
+		}
+	}
+	var sum []byte
+	if ind&winAdler32 != 0 {
+		if sum, err = s.next(4); err != nil {
+			return err
+		}
+	}
+	data, err := s.next(lens[0])
+	if err != nil {
+		return err
+	}
+	inst, err := s.next(lens[1])
+	if err != nil {
+		return err
+	}
+	addr, err := s.next(lens[2])
+	if err != nil {
+		return err
+	}
+	if len(s.b) != 0 {
+		return ErrCorrupt
+	}
+
+	// Small source segments are read at once rather than copy by copy.
+	var seg []byte
+	if segLen <= maxWindow {
+		d.seg = grow(d.seg, segLen)
+		if n, err := d.old.ReadAt(d.seg, segPos); n < len(d.seg) {
+			return err
+		}
+		seg = d.seg
+	}
+
+	tgt, err := d.decode(data, inst, addr, seg, segPos, segLen, tgtLen)
+	if err != nil {
+		return err
+	}
+	if sum != nil && adler32.Checksum(tgt) != binary.BigEndian.Uint32(sum) {
+		return ErrChecksum
+	}
+	_, err = w.Write(tgt)
+	return err
+}
+
+// decode runs the instructions of a window. seg is the source segment if
+// it has been read into memory, or nil to read it from d.old.
+func (d *decoder) decode(data, inst, addr, seg []byte, segPos, segLen, tgtLen int64) ([]byte, error) {
+	if int64(cap(d.tgt)) < tgtLen {
+		d.tgt = make([]byte, 0, tgtLen)
+	}
+	tgt := d.tgt[:0]
+	ds, is, as := &sliceReader{data}, &sliceReader{inst}, &sliceReader{addr}
+	d.cache.reset()
+
+	for len(is.b) > 0 {
+		op, _ := is.ReadByte()
+		c := defaultCodeTable[op]
+		for _, in := range [2][3]byte{{c.typ1, c.size1, c.mode1}, {c.typ2, c.size2, c.mode2}} {
+			typ, size, mode := in[0], int64(in[1]), in[2]
+			if typ == instNoop {
+				continue
+			}
+			var err error
+			if size == 0 {
+				if size, err = readUint(is); err != nil {
+					return nil, err
+				}
+			}
+			if size > tgtLen-int64(len(tgt)) {
+				return nil, ErrCorrupt
+			}
+
+			switch typ {
+			case instAdd:
+				p, err := ds.next(size)
+				if err != nil {
+					return nil, err
+				}
+				tgt = append(tgt, p...)
+
+			case instRun:
+				b, err := ds.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				for i := int64(0); i < size; i++ {
+					tgt = append(tgt, b)
+				}
+
+			case instCopy:
+				here := segLen + int64(len(tgt))
+				a, err := d.cache.decode(as, mode, here)
+				if err != nil {
+					return nil, err
+				}
+				if a < segLen {
+					n := size
+					if n > segLen-a {
+						n = segLen - a
+					}
+					if seg != nil {
+						tgt = append(tgt, seg[a:a+n]...)
+					} else {
+						start := len(tgt)
+						tgt = tgt[:start+int(n)]
+						if m, err := d.old.ReadAt(tgt[start:], segPos+a); m < int(n) {
+							return nil, err
+						}
+					}
+					a += n
+					size -= n
+				}
+				// The rest comes from the target window and may overlap
+				// the bytes being written, repeating them.
+				from := int(a - segLen)
+				if size == 0 {
+					break
+				}
+				if from+int(size) <= len(tgt) {
+					tgt = append(tgt, tgt[from:from+int(size)]...)
+				} else {
+					for i := 0; i < int(size); i++ {
+						tgt = append(tgt, tgt[from+i])
+					}
+				}
+			}
+		}
+	}
+	if int64(len(tgt)) != tgtLen || len(ds.b) != 0 || len(as.b) != 0 {
+		return nil, ErrCorrupt
+	}
+	d.tgt = tgt
+	return tgt, nil
+}
+
+// decode reads the address of a COPY at position here in mode.
+func (c *addrCache) decode(r *sliceReader, mode byte, here int64) (int64, error) {
+	var addr int64
+	switch {
+	case mode == modeSelf:
+		v, err := readUint(r)
+		if err != nil {
+			return 0, err
+		}
+		addr = v
+	case mode == modeHere:
+		v, err := readUint(r)
+		if err != nil {
+			return 0, err
+		}
+		addr = here - v
+	case mode < modeSame:
+		v, err := readUint(r)
+		if err != nil {
+			return 0, err
+		}
+		addr = c.near[mode-modeNear] + v
+	default:
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		addr = c.same[int(mode-modeSame)*256+int(b)]
+	}
+	if addr < 0 || addr >= here {
+		return 0, ErrCorrupt
+	}
+	c.update(addr)
+	return addr, nil
+}
+
+func grow(b []byte, n int64) []byte {
+	if int64(cap(b)) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
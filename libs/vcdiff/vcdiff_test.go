@@ -0,0 +1,135 @@
+package vcdiff
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func patchBytes(old, delta []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := Patch(bytes.NewReader(old), int64(len(old)), bytes.NewReader(delta), &out)
+	return out.Bytes(), err
+}
+
+func diffBytes(t *testing.T, old, new []byte) []byte {
+	t.Helper()
+	var delta bytes.Buffer
+	if err := Diff(bytes.NewReader(old), bytes.NewReader(new), &delta); err != nil {
+		t.Fatal(err)
+	}
+	return delta.Bytes()
+}
+
+// rfcExample is the example of RFC 3284 section 3, encoded by hand with
+// the default code table: COPY 4,0; ADD 4,wxyz; COPY 4,4; COPY 12,24;
+// RUN 4,z. The second and third instructions share one code, and the
+// header carries an xdelta3-style application header.
+var rfcExample = []byte{
+	0xd6, 0xc3, 0xc4, 0x00, // magic, version
+	hdrAppHeader, 0x03, 'a', 'p', 'p',
+	winSource, 0x10, 0x00, // source segment: 16 bytes at 0
+	0x12,       // length of the delta encoding
+	0x1c, 0x00, // target window length, Delta_Indicator
+	0x05, 0x05, 0x03, // data, instructions and addresses lengths
+	'w', 'x', 'y', 'z', 'z',
+	20, 172, 28, 0, 4, // COPY 4 mode 0; ADD 4 + COPY 4 mode 0; COPY 12 mode 0; RUN
+	0x00, 0x04, 0x18,
+}
+
+func TestPatchRFCExample(t *testing.T) {
+	got, err := patchBytes([]byte("abcdefghijklmnop"), rfcExample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abcdwxyzefghefghefghefghzzzz"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+
+	old := random(200 << 10)
+	edited := append([]byte(nil), old[:50<<10]...)
+	edited = append(edited, []byte("inserted in the middle")...)
+	edited = append(edited, old[60<<10:]...)
+	edited = append(edited, make([]byte, 5000)...)
+	edited = append(edited, old[:1000]...)
+	for i := 0; i < 100; i++ {
+		edited[r.Intn(len(edited))]++
+	}
+	repeated := bytes.Repeat([]byte("0123456789abcdef-"), 10000)
+
+	for _, tc := range []struct {
+		name     string
+		old, new []byte
+		maxDelta int
+	}{
+		{"empty", nil, nil, 5},
+		{"no old", nil, repeated, 200},
+		{"no new", old, nil, 5},
+		{"same", old, old, 100},
+		{"edited", old, edited, 3000},
+		{"unrelated", random(1000), random(3000), 3100},
+		{"multi-window", old, bytes.Repeat(edited, 50), 1 << 20},
+	} {
+		delta := diffBytes(t, tc.old, tc.new)
+		if len(delta) > tc.maxDelta {
+			t.Errorf("%s: delta is %d bytes, want at most %d", tc.name, len(delta), tc.maxDelta)
+		}
+		got, err := patchBytes(tc.old, delta)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(got, tc.new) {
+			t.Fatalf("%s: round trip produced different output", tc.name)
+		}
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	old := bytes.Repeat([]byte("some old data "), 1000)
+	new := append(append([]byte(nil), old[:5000]...), old[7000:]...)
+	delta := diffBytes(t, old, new)
+
+	if _, err := patchBytes(old, delta[:len(delta)-3]); err != ErrCorrupt {
+		t.Errorf("truncated delta: got %v, want %v", err, ErrCorrupt)
+	}
+	if _, err := patchBytes(old, []byte("BSDIFF40")); err != ErrCorrupt {
+		t.Errorf("not a delta: got %v, want %v", err, ErrCorrupt)
+	}
+	other := bytes.Repeat([]byte("Some old data "), 1000)
+	if _, err := patchBytes(other, delta); err != ErrChecksum {
+		t.Errorf("wrong source: got %v, want %v", err, ErrChecksum)
+	}
+
+	var unsupported *UnsupportedError
+	secondary := []byte{0xd6, 0xc3, 0xc4, 0x00, hdrSecondary, 2}
+	if _, err := patchBytes(old, secondary); !errors.As(err, &unsupported) {
+		t.Errorf("secondary compression: got %v, want an UnsupportedError", err)
+	}
+}
+
+func TestUintEncoding(t *testing.T) {
+	// RFC 3284 section 2: 123456789 is encoded as 58 111 26 21.
+	if got := appendUint(nil, 123456789); !bytes.Equal(got, []byte{0x80 | 58, 0x80 | 111, 0x80 | 26, 21}) {
+		t.Errorf("appendUint(123456789) = %v", got)
+	}
+	for _, v := range []int64{0, 1, 127, 128, 16383, 16384, 1<<62 + 5} {
+		b := appendUint(nil, v)
+		if len(b) != uintLen(v) {
+			t.Errorf("uintLen(%d) = %d, want %d", v, uintLen(v), len(b))
+		}
+		got, err := readUint(&sliceReader{b})
+		if err != nil || got != v {
+			t.Errorf("readUint(appendUint(%d)) = %d, %v", v, got, err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package zstdpatch
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"github.com/klauspost/compress/huff0"
+)
+
+const (
+	// maxBlockSize is the largest block a frame may hold.
+	maxBlockSize = 128 << 10
+
+	minMatch = 3
+
+	// Largest table logs a decoder accepts, and the symbol counts, for
+	// literal lengths, match lengths and offsets.
+	llMaxLog, mlMaxLog, ofMaxLog = 9, 9, 8
+	llSymbols, mlSymbols         = 36, 53
+	ofSymbols                    = 32
+	ofPredefinedMax              = 28
+)
+
+// Predefined distributions (RFC 8878 section 3.1.1.3.2.2).
+var (
+	llDefault = newFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1}, 6)
+	mlDefault = newFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1}, 6)
+	ofDefault = newFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}, 5)
+)
+
+// Baselines and extra bits of the literal length codes 16 to 35 and the
+// match length codes 32 to 52; match lengths are counted from minMatch.
+var (
+	llBase = []uint32{16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	llBits = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	mlBase = []uint32{32, 34, 36, 38, 40, 44, 48, 56, 64, 80, 96, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	mlBits = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// seq is one sequence: litLen literals, then matchLen bytes copied from
+// offset bytes back.
+type seq struct {
+	litLen, matchLen, offset uint32
+}
+
+// coded is a sequence as the bitstream holds it: three codes and the
+// extra bits of each.
+type coded struct {
+	ll, ml, of             byte
+	llExtra, mlExtra, ofEx uint32
+	llBits, mlBits         uint
+}
+
+func lengthCode(v uint32, direct uint32, base []uint32, nb []uint) (code byte, extra uint32, n uint) {
+	if v < direct {
+		return byte(v), 0, 0
+	}
+	i := len(base) - 1
+	for base[i] > v {
+		i--
+	}
+	return byte(int(direct) + i), v - base[i], nb[i]
+}
+
+// blockEncoder writes the blocks of one frame, keeping the repeat offsets
+// that carry over from block to block.
+type blockEncoder struct {
+	reps   [3]uint32
+	litEnc huff0.Scratch
+}
+
+func newBlockEncoder() *blockEncoder {
+	return &blockEncoder{reps: [3]uint32{1, 4, 8}}
+}
+
+// encode appends the block holding raw, described by lits and seqs, to dst.
+func (e *blockEncoder) encode(dst, raw, lits []byte, seqs []seq, last bool) []byte {
+	reps := e.reps
+	body := e.encodeLiterals(nil, lits)
+	body = e.encodeSequences(body, seqs, &reps)
+
+	typ, size := 2, len(body)
+	if len(body) >= len(raw) {
+		// Not worth it; the repeat offsets stay as they were.
+		typ, size, body = 0, len(raw), raw
+	} else {
+		e.reps = reps
+	}
+	hdr := uint32(size)<<3 | uint32(typ)<<1
+	if last {
+		hdr |= 1
+	}
+	dst = append(dst, byte(hdr), byte(hdr>>8), byte(hdr>>16))
+	return append(dst, body...)
+}
+
+// encodeLiterals writes the literals section: Huffman coded with huff0
+// when that pays, raw otherwise.
+func (e *blockEncoder) encodeLiterals(dst, lits []byte) []byte {
+	var out []byte
+	var err error = huff0.ErrIncompressible
+	single := len(lits) < 1024
+	e.litEnc.Reuse = huff0.ReusePolicyNone
+	switch {
+	case len(lits) >= 1024:
+		out, _, err = huff0.Compress4X(lits, &e.litEnc)
+	case len(lits) > 16:
+		out, _, err = huff0.Compress1X(lits, &e.litEnc)
+	}
+
+	switch {
+	case err == huff0.ErrUseRLE:
+		dst = appendLiteralsSize(dst, 1, len(lits))
+		return append(dst, lits[0])
+	case err == nil && len(out)+5 < len(lits):
+		// Compressed: 2 bits type, 2 bits size format, then both sizes.
+		n, c := uint64(len(lits)), uint64(len(out))
+		var h uint64
+		var hlen int
+		switch {
+		case single:
+			h, hlen = 2|n<<4|c<<14, 3
+		case n < 1<<10 && c < 1<<10:
+			h, hlen = 2|1<<2|n<<4|c<<14, 3
+		case n < 1<<14 && c < 1<<14:
+			h, hlen = 2|2<<2|n<<4|c<<18, 4
+		default:
+			h, hlen = 2|3<<2|n<<4|c<<22, 5
+		}
+		for i := 0; i < hlen; i++ {
+			dst = append(dst, byte(h>>(8*i)))
+		}
+		return append(dst, out...)
+	}
+	dst = appendLiteralsSize(dst, 0, len(lits))
+	return append(dst, lits...)
+}
+
+// appendLiteralsSize writes the header of raw (typ 0) and RLE (typ 1)
+// literals sections.
+func appendLiteralsSize(dst []byte, typ byte, n int) []byte {
+	switch {
+	case n < 32:
+		return append(dst, typ|byte(n)<<3)
+	case n < 4096:
+		return append(dst, typ|1<<2|byte(n)<<4, byte(n>>4))
+	}
+	return append(dst, typ|3<<2|byte(n)<<4, byte(n>>4), byte(n>>12))
+}
+
+// encodeSequences writes the sequences section, updating reps as the
+// decoder will.
+func (e *blockEncoder) encodeSequences(dst []byte, seqs []seq, reps *[3]uint32) []byte {
+	n := len(seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8)+0x80, byte(n))
+	default:
+		dst = append(dst, 0xff, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+
+	cs := make([]coded, n)
+	var llCount, mlCount, ofCount [64]int
+	for i, s := range seqs {
+		c := &cs[i]
+		c.ll, c.llExtra, c.llBits = lengthCode(s.litLen, 16, llBase, llBits)
+		c.ml, c.mlExtra, c.mlBits = lengthCode(s.matchLen-minMatch, 32, mlBase, mlBits)
+
+		// A repeat of the last offset costs almost nothing; anything else
+		// is sent as the offset plus 3.
+		var ov uint32
+		if s.offset == reps[0] && s.litLen > 0 {
+			ov = 1
+		} else {
+			ov = s.offset + 3
+			reps[2], reps[1], reps[0] = reps[1], reps[0], s.offset
+		}
+		c.of = byte(bits.Len32(ov) - 1)
+		c.ofEx = ov - 1<<c.of
+		llCount[c.ll]++
+		mlCount[c.ml]++
+		ofCount[c.of]++
+	}
+
+	var modes byte
+	var tables bitWriter
+	llT := chooseTable(&modes, 6, &tables, llCount[:llSymbols], n, llMaxLog, llDefault, llSymbols-1)
+	ofT := chooseTable(&modes, 4, &tables, ofCount[:ofSymbols], n, ofMaxLog, ofDefault, ofPredefinedMax)
+	mlT := chooseTable(&modes, 2, &tables, mlCount[:mlSymbols], n, mlMaxLog, mlDefault, mlSymbols-1)
+	dst = append(dst, modes)
+	dst = append(dst, tables.out...)
+
+	// The bitstream is read backwards, so the last sequence goes first.
+	var w bitWriter
+	ll, ml, of := fseState{t: llT}, fseState{t: mlT}, fseState{t: ofT}
+	last := cs[n-1]
+	ml.init(last.ml)
+	of.init(last.of)
+	ll.init(last.ll)
+	w.add(uint64(last.llExtra), last.llBits)
+	w.add(uint64(last.mlExtra), last.mlBits)
+	w.add(uint64(last.ofEx), uint(last.of))
+	for i := n - 2; i >= 0; i-- {
+		c := cs[i]
+		of.encode(&w, c.of)
+		ml.encode(&w, c.ml)
+		ll.encode(&w, c.ll)
+		w.add(uint64(c.llExtra), c.llBits)
+		w.add(uint64(c.mlExtra), c.mlBits)
+		w.add(uint64(c.ofEx), uint(c.of))
+	}
+	ml.flush(&w)
+	of.flush(&w)
+	ll.flush(&w)
+	w.close()
+	return append(dst, w.out...)
+}
+
+// chooseTable picks the mode of one of the three symbol streams and writes
+// its table description: RLE for a single symbol, the predefined table
+// for a few sequences, and a table fitted to the counts otherwise. The
+// mode goes to bits shift and up of modes; a nil table means RLE.
+func chooseTable(modes *byte, shift uint, w *bitWriter, counts []int, n int, maxLog uint, predefined *fseTable, predefinedMax int) *fseTable {
+	used, top := 0, 0
+	for s, c := range counts {
+		if c > 0 {
+			used++
+			top = s
+		}
+	}
+	if used == 1 {
+		*modes |= 1 << shift
+		w.out = append(w.out, byte(top))
+		return nil
+	}
+	if n < 32 && top <= predefinedMax {
+		return predefined
+	}
+
+	log := uint(bits.Len(uint(n)))
+	if min := uint(bits.Len(uint(used))) + 1; log < min {
+		log = min
+	}
+	if log < 5 {
+		log = 5
+	}
+	if log > maxLog {
+		log = maxLog
+	}
+	norm := normalize(counts[:top+1], n, log)
+	*modes |= 2 << shift
+	writeNCount(w, norm, log)
+	return newFSETable(norm, log)
+}
+
+const frameMagic = 0xfd2fb528
+
+// appendFrameHeader writes a single segment frame header for content of
+// size bytes with a content checksum, as `zstd --patch-from` does.
+func appendFrameHeader(dst []byte, size uint64) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, frameMagic)
+	const singleSegment, checksum = 1 << 5, 1 << 2
+	switch {
+	case size < 256:
+		dst = append(dst, singleSegment|checksum, byte(size))
+	case size < 65536+256:
+		dst = append(dst, 1<<6|singleSegment|checksum)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(size-256))
+	case size < 1<<32:
+		dst = append(dst, 2<<6|singleSegment|checksum)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(size))
+	default:
+		dst = append(dst, 3<<6|singleSegment|checksum)
+		dst = binary.LittleEndian.AppendUint64(dst, size)
+	}
+	return dst
+}
@@ -0,0 +1,227 @@
+package zstdpatch
+
+import "math/bits"
+
+// bitWriter writes a little-endian bitstream, least significant bit
+// first, as FSE table descriptions and sequence bitstreams are written.
+type bitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+// add writes the low n bits of v (n <= 32).
+func (w *bitWriter) add(v uint64, n uint) {
+	w.bits |= (v & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// pad writes the last partial byte.
+func (w *bitWriter) pad() {
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits, w.nbits = 0, 0
+	}
+}
+
+// close ends a bitstream that is read backwards: a 1 bit marks its end.
+func (w *bitWriter) close() {
+	w.add(1, 1)
+	w.pad()
+}
+
+// fseTable is an FSE encoding table built from a normalized distribution,
+// as FSE_buildCTable of the reference implementation does.
+type fseTable struct {
+	log        uint
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// newFSETable builds the table for norm, whose counts add up to 1<<log;
+// -1 stands for a "less than 1" probability.
+func newFSETable(norm []int16, log uint) *fseTable {
+	size := 1 << log
+	mask := size - 1
+	t := &fseTable{log: log, stateTable: make([]uint16, size), symbols: make([]fseSymbol, len(norm))}
+
+	tableSymbol := make([]byte, size)
+	cumul := make([]int, len(norm)+1)
+	high := size - 1
+	for s, n := range norm {
+		if n == -1 {
+			cumul[s+1] = cumul[s] + 1
+			tableSymbol[high] = byte(s)
+			high--
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			tableSymbol[pos] = byte(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	for u := 0; u < size; u++ {
+		s := tableSymbol[u]
+		t.stateTable[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+
+	total := int32(0)
+	for s, n := range norm {
+		switch n {
+		case 0:
+			t.symbols[s].deltaNbBits = uint32((log+1)<<16 - 1<<log)
+		case -1, 1:
+			t.symbols[s] = fseSymbol{uint32(log<<16 - 1<<log), total - 1}
+			total++
+		default:
+			maxBitsOut := log - uint(bits.Len32(uint32(n-1))-1)
+			minStatePlus := uint32(n) << maxBitsOut
+			t.symbols[s] = fseSymbol{uint32(maxBitsOut<<16) - minStatePlus, total - int32(n)}
+			total += int32(n)
+		}
+	}
+	return t
+}
+
+// fseState encodes symbols with a table; a nil table stands for the RLE
+// mode, in which symbols take no bits at all.
+type fseState struct {
+	t     *fseTable
+	state uint32
+}
+
+func (s *fseState) init(sym byte) {
+	if s.t == nil {
+		return
+	}
+	st := s.t.symbols[sym]
+	nbBitsOut := (st.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - st.deltaNbBits
+	s.state = uint32(s.t.stateTable[int32(value>>nbBitsOut)+st.deltaFindState])
+}
+
+func (s *fseState) encode(w *bitWriter, sym byte) {
+	if s.t == nil {
+		return
+	}
+	st := s.t.symbols[sym]
+	nbBitsOut := (s.state + st.deltaNbBits) >> 16
+	w.add(uint64(s.state), uint(nbBitsOut))
+	s.state = uint32(s.t.stateTable[int32(s.state>>nbBitsOut)+st.deltaFindState])
+}
+
+func (s *fseState) flush(w *bitWriter) {
+	if s.t == nil {
+		return
+	}
+	w.add(uint64(s.state), s.t.log)
+}
+
+// normalize scales counts (of total symbols) to a distribution adding up
+// to 1<<log, keeping every present symbol.
+func normalize(counts []int, total int, log uint) []int16 {
+	size := 1 << log
+	norm := make([]int16, len(counts))
+	sum, largest := 0, 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		n := c * size / total
+		if n < 1 {
+			n = 1
+		}
+		norm[s] = int16(n)
+		sum += n
+		if n > int(norm[largest]) {
+			largest = s
+		}
+	}
+	// Give the rounding error to the most probable symbol, or take it
+	// from the symbols that can spare it.
+	if sum < size || int(norm[largest])-(sum-size) >= 1 {
+		norm[largest] += int16(size - sum)
+		return norm
+	}
+	for sum > size {
+		for s := range norm {
+			if norm[s] > 1 && sum > size {
+				norm[s]--
+				sum--
+			}
+		}
+	}
+	return norm
+}
+
+// writeNCount writes the FSE table description of norm (RFC 8878 section
+// 4.1.1), as FSE_writeNCount of the reference implementation does.
+func writeNCount(w *bitWriter, norm []int16, log uint) {
+	size := 1 << log
+	w.add(uint64(log-5), 4)
+	remaining := size + 1
+	threshold := size
+	nbBits := log + 1
+	previousIs0 := false
+	for s := 0; s < len(norm) && remaining > 1; {
+		if previousIs0 {
+			start := s
+			for s < len(norm) && norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				w.add(0xffff, 16)
+			}
+			for s >= start+3 {
+				start += 3
+				w.add(3, 2)
+			}
+			w.add(uint64(s-start), 2)
+		}
+		count := int(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++ // +1 for extra accuracy
+		if count >= threshold {
+			count += max
+		}
+		n := nbBits
+		if count < max {
+			n--
+		}
+		w.add(uint64(count), n)
+		previousIs0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+	w.pad()
+}
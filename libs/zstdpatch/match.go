@@ -0,0 +1,166 @@
+package zstdpatch
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// params are the match finder settings of a compression level.
+type params struct {
+	// stride is the distance between the old positions put in the hash
+	// table; matches shorter than about stride+8 bytes may be missed.
+	stride int
+	// ways is the number of candidates kept per hash bucket.
+	ways int
+}
+
+func levelParams(level int) params {
+	switch {
+	case level <= 2:
+		return params{stride: 32, ways: 1}
+	case level <= 5:
+		return params{stride: 16, ways: 1}
+	case level <= 9:
+		return params{stride: 16, ways: 4}
+	case level <= 15:
+		return params{stride: 8, ways: 4}
+	}
+	return params{stride: 8, ways: 8}
+}
+
+const (
+	// hashLen is the number of bytes hashed, and the shortest match
+	// looked up through the hash table.
+	hashLen = 8
+	// maxHashLog bounds the hash table at 1<<maxHashLog buckets.
+	maxHashLog = 22
+	// maxOffset is the largest offset an offset code can carry.
+	maxOffset = 1<<31 - 4
+)
+
+// matcher finds matches for new in the history old || new: positions
+// below len(old) are in old, the others are in new. A match never runs
+// from old into new.
+type matcher struct {
+	old, new []byte
+	p        params
+	shift    uint
+	table    []uint32 // position+1, ways entries per bucket, newest first
+	rep      int      // offset of the last match
+}
+
+func newMatcher(old, new []byte, p params) *matcher {
+	n := (len(old)+len(new))/p.stride + 1
+	log := uint(bits.Len(uint(n)))
+	if log > maxHashLog {
+		log = maxHashLog
+	}
+	if log < 8 {
+		log = 8
+	}
+	m := &matcher{old: old, new: new, p: p, shift: 64 - log, table: make([]uint32, (1<<log)*p.ways), rep: 1}
+	for i := 0; i+hashLen <= len(old); i += p.stride {
+		m.insert(old[i:], i)
+	}
+	return m
+}
+
+func (m *matcher) hash(b []byte) int {
+	const prime = 0xcf1bbcdcb7a56463
+	return int((binary.LittleEndian.Uint64(b)*prime)>>m.shift) * m.p.ways
+}
+
+func (m *matcher) insert(b []byte, pos int) {
+	bucket := m.table[m.hash(b):][:m.p.ways]
+	copy(bucket[1:], bucket)
+	bucket[0] = uint32(pos + 1)
+}
+
+// matchLen returns how many bytes from history position c equal new[p:end].
+func (m *matcher) matchLen(c, p, end int) int {
+	var src []byte
+	if c < len(m.old) {
+		src = m.old[c:]
+	} else {
+		src = m.new[c-len(m.old):]
+	}
+	dst := m.new[p:end]
+	if len(src) > len(dst) {
+		src = src[:len(dst)]
+	}
+	n := 0
+	for n+8 <= len(src) {
+		if x := binary.LittleEndian.Uint64(src[n:]) ^ binary.LittleEndian.Uint64(dst[n:]); x != 0 {
+			return n + bits.TrailingZeros64(x)/8
+		}
+		n += 8
+	}
+	for n < len(src) && src[n] == dst[n] {
+		n++
+	}
+	return n
+}
+
+// block splits new[start:end] into sequences and the literals between
+// them.
+func (m *matcher) block(start, end int) (lits []byte, seqs []seq) {
+	no := len(m.old)
+	lit := start
+	for p := start; p+hashLen <= end; {
+		pos := no + p
+		bestLen, bestPos := 0, 0
+		if c := pos - m.rep; c >= 0 {
+			if n := m.matchLen(c, p, end); n >= minMatch+1 {
+				bestLen, bestPos = n, c
+			}
+		}
+		bucket := m.table[m.hash(m.new[p:]):][:m.p.ways]
+		for _, e := range bucket {
+			if e == 0 {
+				break
+			}
+			c := int(e - 1)
+			if c >= pos || pos-c > maxOffset {
+				continue
+			}
+			if n := m.matchLen(c, p, end); n >= hashLen && n > bestLen {
+				bestLen, bestPos = n, c
+			}
+		}
+		if pos%m.p.stride == 0 {
+			m.insert(m.new[p:], pos)
+		}
+		if bestLen == 0 {
+			// Look further apart in long runs of literals.
+			p += 1 + (p-lit)>>8
+			continue
+		}
+
+		// Take in the literals before the match that match as well.
+		for p > lit && bestPos > 0 && bestPos != no && m.history(bestPos-1) == m.new[p-1] {
+			p--
+			bestPos--
+			bestLen++
+		}
+		seqs = append(seqs, seq{litLen: uint32(p - lit), matchLen: uint32(bestLen), offset: uint32(no + p - bestPos)})
+		lits = append(lits, m.new[lit:p]...)
+		m.rep = no + p - bestPos
+
+		next := p + bestLen
+		for q := p + 1; q < next && q+hashLen <= len(m.new); q++ {
+			if (no+q)%m.p.stride == 0 {
+				m.insert(m.new[q:], no+q)
+			}
+		}
+		p, lit = next, next
+	}
+	lits = append(lits, m.new[lit:end]...)
+	return lits, seqs
+}
+
+func (m *matcher) history(c int) byte {
+	if c < len(m.old) {
+		return m.old[c]
+	}
+	return m.new[c-len(m.old)]
+}
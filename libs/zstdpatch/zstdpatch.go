@@ -0,0 +1,168 @@
+// Package zstdpatch creates and applies zstd "patch-from" patches: zstd
+// frames compressed with the old file as a raw dictionary, as written by
+// `zstd --patch-from=old new` and read by `zstd -d --patch-from=old`.
+//
+// The whole of both files is kept in memory, and the two together must be
+// smaller than 2 GB, the furthest an offset may reach.
+//
+// Patches are decoded with github.com/klauspost/compress/zstd, but encoded
+// here. That encoder accepts a raw dictionary, yet at the fast and default
+// levels it only finds matches in the last few hundred KB of it, and at
+// its best level it loses the dictionary once the file passes a few tens
+// of MB, so a patch against a large base comes out about as large as the
+// file itself. This package only needs the part of zstd that finds long
+// matches against the whole old file: its own match finder feeds blocks
+// of sequences to a minimal block encoder, with huff0 for the literals.
+// TestSizeAgainstCommand compares both with `zstd --patch-from`.
+package zstdpatch
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultLevel is the level used when a level of 0 is given, as with the
+// zstd command.
+const DefaultLevel = 3
+
+// MaxLevel is the highest level; higher levels search harder for matches.
+const MaxLevel = 19
+
+// ErrTooLarge is returned by Diff when old and new are too large to be
+// referenced by one frame.
+var ErrTooLarge = errors.New("zstdpatch: files too large")
+
+// IsPatch reports whether head, the start of a file, is a zstd frame.
+func IsPatch(head []byte) bool {
+	return len(head) >= 4 && binary.LittleEndian.Uint32(head) == frameMagic
+}
+
+// Diff writes a patch turning old into new, compressed at level (1 to
+// MaxLevel, 0 for DefaultLevel).
+func Diff(old, new io.Reader, patch io.Writer, level int) error {
+	if level <= 0 {
+		level = DefaultLevel
+	}
+	obuf, err := ioutil.ReadAll(old)
+	if err != nil {
+		return err
+	}
+	nbuf, err := ioutil.ReadAll(new)
+	if err != nil {
+		return err
+	}
+	if len(obuf)+len(nbuf) > maxOffset {
+		return ErrTooLarge
+	}
+
+	out := appendFrameHeader(nil, uint64(len(nbuf)))
+	if len(nbuf) == 0 {
+		out = append(out, 1, 0, 0) // a last, empty raw block
+	}
+	m := newMatcher(obuf, nbuf, levelParams(level))
+	e := newBlockEncoder()
+	for start := 0; start < len(nbuf); start += maxBlockSize {
+		end := start + maxBlockSize
+		if end > len(nbuf) {
+			end = len(nbuf)
+		}
+		lits, seqs := m.block(start, end)
+		out = e.encode(out, nbuf[start:end], lits, seqs, end == len(nbuf))
+		if _, err := patch.Write(out); err != nil {
+			return err
+		}
+		out = out[:0]
+	}
+	out = binary.LittleEndian.AppendUint32(out, uint32(xxh64(nbuf)))
+	_, err = patch.Write(out)
+	return err
+}
+
+// Patch applies patch to old and writes the result to new. Patches made
+// by the zstd command are accepted as well as those made by Diff.
+func Patch(old io.Reader, patch io.Reader, new io.Writer) error {
+	dict, err := ioutil.ReadAll(old)
+	if err != nil {
+		return err
+	}
+	opts := []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(1 << 40),
+	}
+	if len(dict) > 0 {
+		opts = append(opts, zstd.WithDecoderDictRaw(0, dict))
+	}
+	d, err := zstd.NewReader(patch, opts...)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	_, err = io.Copy(new, d)
+	return err
+}
+
+// xxh64 returns the XXH64 hash of b with seed 0, of which zstd keeps the
+// low 32 bits as the content checksum.
+func xxh64(b []byte) uint64 {
+	const (
+		p1 = 11400714785074694791
+		p2 = 14029467366897019727
+		p3 = 1609587929392839161
+		p4 = 9650029242287828579
+		p5 = 2870177450012600261
+	)
+	round := func(acc, v uint64) uint64 {
+		acc += v * p2
+		acc = acc<<31 | acc>>33
+		return acc * p1
+	}
+	merge := func(acc, v uint64) uint64 {
+		acc ^= round(0, v)
+		return acc*p1 + p4
+	}
+
+	n := uint64(len(b))
+	var h uint64
+	if len(b) >= 32 {
+		prime1, prime2 := uint64(p1), uint64(p2)
+		v1, v2, v3, v4 := prime1+prime2, prime2, uint64(0), -prime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = round(v1, binary.LittleEndian.Uint64(b))
+			v2 = round(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = round(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = round(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = (v1<<1 | v1>>63) + (v2<<7 | v2>>57) + (v3<<12 | v3>>52) + (v4<<18 | v4>>46)
+		h = merge(h, v1)
+		h = merge(h, v2)
+		h = merge(h, v3)
+		h = merge(h, v4)
+	} else {
+		h = p5
+	}
+	h += n
+
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= round(0, binary.LittleEndian.Uint64(b))
+		h = (h<<27|h>>37)*p1 + p4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * p1
+		h = (h<<23|h>>41)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * p5
+		h = (h<<11 | h>>53) * p1
+	}
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}
@@ -0,0 +1,257 @@
+package zstdpatch
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func diffBytes(t *testing.T, old, new []byte, level int) []byte {
+	t.Helper()
+	var patch bytes.Buffer
+	if err := Diff(bytes.NewReader(old), bytes.NewReader(new), &patch, level); err != nil {
+		t.Fatal(err)
+	}
+	return patch.Bytes()
+}
+
+func patchBytes(old, patch []byte) ([]byte, error) {
+	var out bytes.Buffer
+	err := Patch(bytes.NewReader(old), bytes.NewReader(patch), &out)
+	return out.Bytes(), err
+}
+
+// edit returns a copy of b with n random insertions, deletions and
+// changed bytes.
+func edit(r *rand.Rand, b []byte, n int) []byte {
+	b = append([]byte(nil), b...)
+	for i := 0; i < n; i++ {
+		p := r.Intn(len(b) + 1)
+		switch r.Intn(3) {
+		case 0:
+			ins := make([]byte, r.Intn(50))
+			r.Read(ins)
+			b = append(b[:p], append(ins, b[p:]...)...)
+		case 1:
+			q := p + r.Intn(100)
+			if q > len(b) {
+				q = len(b)
+			}
+			b = append(b[:p], b[q:]...)
+		default:
+			if p < len(b) {
+				b[p]++
+			}
+		}
+	}
+	return b
+}
+
+func text(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte('a' + r.Intn(r.Intn(26)+1))
+	}
+	return b
+}
+
+func TestDiffRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	old := text(r, 600<<10) // several blocks
+	cases := []struct{ old, new []byte }{
+		{nil, nil},
+		{old, nil},
+		{nil, []byte("abc")},
+		{nil, bytes.Repeat([]byte("abcdefghijklmnop"), 100)},
+		{old, old},
+		{old, edit(r, old, 10)},
+		{old, edit(r, old, 3000)},
+		{old, append(edit(r, old, 100), old...)},
+		{old[:1000], text(r, 300<<10)},
+		{mustReadFile(t, "testdata/sample.old"), mustReadFile(t, "testdata/sample.new")},
+	}
+	for i, c := range cases {
+		for _, level := range []int{0, 1, 7, 12, MaxLevel} {
+			patch := diffBytes(t, c.old, c.new, level)
+			if !IsPatch(patch) {
+				t.Fatalf("%d/%d: IsPatch = false", i, level)
+			}
+			got, err := patchBytes(c.old, patch)
+			if err != nil {
+				t.Fatalf("%d/%d: %v", i, level, err)
+			}
+			if !bytes.Equal(got, c.new) {
+				t.Fatalf("%d/%d: patched output differs", i, level)
+			}
+		}
+	}
+}
+
+// sample.zst was written by `zstd -19 --patch-from=sample.old sample.new`
+// (zstd 1.5.6).
+func TestPatchReference(t *testing.T) {
+	got, err := patchBytes(mustReadFile(t, "testdata/sample.old"), mustReadFile(t, "testdata/sample.zst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, mustReadFile(t, "testdata/sample.new")) {
+		t.Fatal("patched output differs from sample.new")
+	}
+}
+
+func TestPatchCorrupt(t *testing.T) {
+	old := mustReadFile(t, "testdata/sample.old")
+	patch := diffBytes(t, old, mustReadFile(t, "testdata/sample.new"), 0)
+	bad := append([]byte(nil), patch...)
+	bad[len(bad)-1]++ // checksum
+	if _, err := patchBytes(old, bad); err == nil {
+		t.Error("bad checksum: no error")
+	}
+	if _, err := patchBytes(old, patch[:len(patch)/2]); err == nil {
+		t.Error("truncated patch: no error")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd not found")
+	}
+	r := rand.New(rand.NewSource(2))
+	old := text(r, 300<<10)
+	new := edit(r, old, 1000)
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old")
+	patchFile := filepath.Join(dir, "patch")
+	outFile := filepath.Join(dir, "out")
+	if err := os.WriteFile(oldFile, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []int{1, DefaultLevel, MaxLevel} {
+		if err := os.WriteFile(patchFile, diffBytes(t, old, new, level), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(zstd, "-q", "-d", "-f", "--patch-from="+oldFile, patchFile, "-o", outFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("level %d: %v: %s", level, err, out)
+		}
+		if !bytes.Equal(mustReadFile(t, outFile), new) {
+			t.Fatalf("level %d: zstd output differs", level)
+		}
+	}
+}
+
+// TestSizeAgainstCommand checks that patches against a base of several MB
+// stay close to those of `zstd --patch-from`, and that klauspost's encoder
+// with the base as a raw dictionary, which Diff does not use, is far from
+// it. If the second check fails, that encoder has caught up and could
+// replace this one.
+func TestSizeAgainstCommand(t *testing.T) {
+	zstdCmd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd not found")
+	}
+	if testing.Short() {
+		t.Skip("large files")
+	}
+	r := rand.New(rand.NewSource(4))
+	old := make([]byte, 4<<20)
+	r.Read(old)
+	new := edit(r, old, 50)
+	dir := t.TempDir()
+	oldFile := filepath.Join(dir, "old")
+	newFile := filepath.Join(dir, "new")
+	refFile := filepath.Join(dir, "ref")
+	if err := os.WriteFile(oldFile, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(newFile, new, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []int{1, DefaultLevel} {
+		cmd := exec.Command(zstdCmd, "-q", "-f", "-"+strconv.Itoa(level), "--patch-from="+oldFile, newFile, "-o", refFile)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("level %d: %v: %s", level, err, out)
+		}
+		ref := len(mustReadFile(t, refFile))
+		ours := len(diffBytes(t, old, new, level))
+
+		enc, err := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderDictRaw(0, old),
+			zstd.WithWindowSize(16<<20),
+			zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		theirs := len(enc.EncodeAll(new, nil))
+		enc.Close()
+
+		t.Logf("level %d: zstd %d, Diff %d, klauspost %d bytes", level, ref, ours, theirs)
+		if ours > 2*ref+1024 {
+			t.Errorf("level %d: patch is %d bytes, zstd --patch-from makes %d", level, ours, ref)
+		}
+		if theirs < 10*ours {
+			t.Errorf("level %d: klauspost's encoder makes %d bytes, Diff %d", level, theirs, ours)
+		}
+	}
+}
+
+func TestXXH64(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if got := xxh64([]byte(c.s)); got != c.want {
+			t.Errorf("xxh64(%q) = %#x, want %#x", c.s, got, c.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	for i := 0; i < 200; i++ {
+		counts := make([]int, 1+r.Intn(52))
+		total := 0
+		for s := range counts {
+			if r.Intn(3) > 0 {
+				counts[s] = r.Intn(1 << uint(r.Intn(16)))
+				total += counts[s]
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		log := uint(6 + r.Intn(4))
+		sum := 0
+		for s, n := range normalize(counts, total, log) {
+			if (n > 0) != (counts[s] > 0) {
+				t.Fatalf("symbol %d: count %d, normalized %d", s, counts[s], n)
+			}
+			sum += int(n)
+		}
+		if sum != 1<<log {
+			t.Fatalf("normalized counts add up to %d, want %d", sum, 1<<log)
+		}
+	}
+}
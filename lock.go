@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cg-file-backup/libs/lockfile"
+)
+
+// ----------------- バックアップルートの排他 -----------------
+//
+// 同じバックアップルートで世代の作成・差分の確定・checksum.json の更新が同時に行われると、
+// 同じ番号の世代を 2 つ作ったり、.base や記録を上書きし合ったりします (複数のタブ・保存の監視・スケジュール・
+// ボタンの連打、別に起動したアプリ)。ルートを書き換える処理は lockRoot で、このプロセス内の排他 (rootLocks) と
+// ルート直下のロックファイル (.cgb-lock) によるプロセス間の排他を取ってから行います。
+
+const (
+	rootLockFileName = ".cgb-lock"
+	rootLockWait     = 5 * time.Minute // 別のアプリの処理をこれだけ待っても終わらなければあきらめる
+	rootLockRetry    = 200 * time.Millisecond
+)
+
+// lockRoot はバックアップルート root の排他を取り、解放する関数を返します (root が無ければ作ります)。
+// 使用中なら待ち、ctx が終わればそのエラーを、別のアプリが rootLockWait を過ぎても放さなければ ErrRootLocked を返します
+func (a *App) lockRoot(ctx context.Context, root string) (func(), error) {
+	root = filepath.Clean(root)
+	v, _ := a.rootLocks.LoadOrStore(root, make(chan struct{}, 1))
+	sem := v.(chan struct{})
+	select {
+	case sem <- struct{}{}:
+	default:
+		// このアプリの別の処理 (タブ・監視・スケジュール) が使用中
+		setJobPhase(ctx, phaseWait, 0)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	l, err := acquireRootLockFile(ctx, root)
+	if err != nil {
+		<-sem
+		return nil, err
+	}
+	return func() {
+		l.Unlock()
+		<-sem
+	}, nil
+}
+
+// tryLockRoot は lockRoot と同じ排他を待たずに取ります。root が無いか使用中なら false を返します (root は作りません)
+func (a *App) tryLockRoot(root string) (func(), bool) {
+	root = filepath.Clean(root)
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, false
+	}
+	v, _ := a.rootLocks.LoadOrStore(root, make(chan struct{}, 1))
+	sem := v.(chan struct{})
+	select {
+	case sem <- struct{}{}:
+	default:
+		return nil, false
+	}
+	l, err := lockfile.TryLock(filepath.Join(root, rootLockFileName), lockfile.DefaultStaleAge)
+	if err != nil {
+		<-sem
+		return nil, false
+	}
+	return func() {
+		l.Unlock()
+		<-sem
+	}, true
+}
+
+// acquireRootLockFile は root のロックファイルを取ります。別のアプリが持っていれば放すまで待ちます
+func acquireRootLockFile(ctx context.Context, root string) (*lockfile.Lock, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(root, rootLockFileName)
+	l, err := lockfile.TryLock(path, lockfile.DefaultStaleAge)
+	if errors.Is(err, lockfile.ErrLocked) {
+		setJobPhase(ctx, phaseWait, 0)
+		waitCtx, cancel := context.WithTimeout(ctx, rootLockWait)
+		l, err = lockfile.Acquire(waitCtx, path, lockfile.DefaultStaleAge, rootLockRetry)
+		cancel()
+	}
+	switch {
+	case err == nil:
+		return l, nil
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	case errors.Is(err, lockfile.ErrLocked):
+		return nil, newAppError(ErrRootLocked, root, nil)
+	}
+	return nil, err
+}
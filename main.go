@@ -3,8 +3,6 @@ package main
 import (
 	"embed"
 	"os"
-	"path/filepath"
-	"strings"
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
@@ -16,8 +14,11 @@ import (
 var assets embed.FS
 
 func main() {
-	// --- 外部バイナリパスのセットアップ ---
-	setupExternalBinPaths()
+	// --- サブコマンド付きで起動された場合は GUI を出さずに CLI として実行 ---
+	if len(os.Args) > 1 && isCLICommand(os.Args[1]) {
+		attachParentConsole()
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
 
 	// アプリケーションインスタンスの作成
 	app := NewApp()
@@ -41,6 +42,8 @@ func main() {
 		},
 		BackgroundColour: &options.RGBA{R: 27, G: 38, B: 54, A: 1},
 		OnStartup:        app.startup,
+		// AppError はコードと翻訳キー付きのオブジェクトとしてフロントエンドへ渡す
+		ErrorFormatter:   formatError,
 		Menu:             menu,
 		Bind: []interface{}{
 			app,
@@ -54,48 +57,4 @@ func main() {
 	if err != nil {
 		println("Error:", err.Error())
 	}
-}
-
-// setupExternalBinPaths は開発時(wails dev)とビルド後両方でバイナリが見つかるようにPATHを設定します
-func setupExternalBinPaths() {
-	// 1. 実行バイナリの場所を取得
-	ex, err := os.Executable()
-	if err != nil {
-		return
-	}
-	exDir := filepath.Dir(ex)
-
-	// 2. 現在の作業ディレクトリ(wails dev実行時のプロジェクトルート)を取得
-	cwd, err := os.Getwd()
-	if err != nil {
-		return
-	}
-
-	// 探索対象のフォルダリスト (ビルド後パス + 開発時パス)
-	targets := []string{
-		filepath.Join(exDir, "bzip2-bin"),
-		filepath.Join(exDir, "hdiff-bin"),
-		filepath.Join(cwd, "bzip2-bin"),
-		filepath.Join(cwd, "hdiff-bin"),
-	}
-
-	currentPath := os.Getenv("PATH")
-	var validPaths []string
-
-	for _, path := range targets {
-		// ディレクトリの実体が存在するか確認
-		if info, err := os.Stat(path); err == nil && info.IsDir() {
-			// OSが認識しやすいよう絶対パスに変換
-			if absPath, err := filepath.Abs(path); err == nil {
-				validPaths = append(validPaths, absPath)
-			}
-		}
-	}
-
-	// 新しいパスを追加（既存のPATHより優先されるよう先頭に追加）
-	if len(validPaths) > 0 {
-		newPathValue := strings.Join(validPaths, string(os.PathListSeparator)) +
-			string(os.PathListSeparator) + currentPath
-		os.Setenv("PATH", newPathValue)
-	}
 }
@@ -1,9 +1,9 @@
 package main
 import (
+	"context"
 	"strings"
 	"os"
 	"path/filepath"
-	"fmt"
 	"archive/tar"
 	"archive/zip"
 	"compress/gzip"
@@ -73,38 +73,85 @@ func (a *App) RestoreArchive(archivePath, workFile string) error {
 			return err
 		}
 	}
-	return fmt.Errorf("unsupported archive format")
+	return newAppError(ErrUnsupportedArchive, filepath.Base(archivePath), nil)
 }
 
 // RestoreBackup はファイル形式を自動判別して復元を実行します
 func (a *App) RestoreBackup(path, workFile string) error {
+	// ★ 復元先のパスを「別名」として生成する
+	return a.restoreBackupTo(context.Background(), path, workFile, autoOutputPath(workFile))
+}
+
+// restoreBackupTo は RestoreBackup の本体です。復元先 restoredPath は呼び出し側が決めます。
+// 同じフォルダの一時ファイルに書き出し、checksum.json に記録された元ファイルのハッシュと照合してから
+// restoredPath に置き換えます。失敗・中止したときは一時ファイルを削除し、restoredPath には触れません
+func (a *App) restoreBackupTo(ctx context.Context, path, workFile, restoredPath string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(restoredPath), atomicTempPrefix+filepath.Base(restoredPath)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	err = a.extractBackupTo(ctx, path, workFile, tmpPath)
+	if err == nil {
+		err = a.verifyRestored(ctx, path, tmpPath)
+	}
+	if err == nil {
+		// CreateTemp は 0600 で作るので、os.Create と同じ権限にそろえる
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, restoredPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(restoredPath))
+	return nil
+}
+
+// extractBackupTo は形式に応じてバックアップを restoredPath に書き出します (照合はしません)
+func (a *App) extractBackupTo(ctx context.Context, path, workFile, restoredPath string) error {
 	ext := strings.ToLower(filepath.Ext(path))
 
 	// 1. 差分パッチ (.diff)
-	// ※ ApplyMultiDiff 内部で既に autoOutputPath が使われているのでそのままでOK
 	if ext == ".diff" {
-		return a.ApplyMultiDiff(workFile, []string{path}, "")
+		setJobPhase(ctx, phaseRestore, 0)
+		if err := a.applyDiffTo(workFile, path, restoredPath); err != nil {
+			return err
+		}
+		// 差分の適用そのものは途中で止められないので、終わってから確かめる
+		return ctx.Err()
 	}
 
-	// ★ 復元先のパスを「別名」として生成する
-	restoredPath := autoOutputPath(workFile)
+	// 重複排除バックアップのマニフェスト (.manifest)
+	if ext == dedupManifestExt {
+		return a.restoreManifestTo(ctx, path, restoredPath)
+	}
+	setJobPhase(ctx, phaseRestore, fileSize(path))
+
 
 	// 2. ZIPアーカイブ (.zip)
 	if ext == ".zip" {
 		r, err := zip.OpenReader(path)
 		if err != nil {
-			return err
+			return newAppError(ErrUnsupportedArchive, filepath.Base(path), err)
 		}
 		defer r.Close()
 		for _, f := range r.File {
+			// 暗号化など、展開できない形式もここで分かる
 			rc, err := f.Open()
 			if err != nil {
-				return err
+				return newAppError(ErrUnsupportedArchive, filepath.Base(path), err)
 			}
 			defer rc.Close()
 			// workFile ではなく restoredPath に保存
-			return a.saveToWorkFile(rc, restoredPath)
+			setJobPhase(ctx, phaseRestore, int64(f.UncompressedSize64))
+			return a.saveToWorkFile(newProgressReader(ctx, rc), restoredPath)
 		}
+		return newAppError(ErrUnsupportedArchive, filepath.Base(path), nil)
 	}
 
 	// 3. TARアーカイブ (.tar.gz)
@@ -114,19 +161,21 @@ func (a *App) RestoreBackup(path, workFile string) error {
 			return err
 		}
 		defer f.Close()
-		gzr, err := gzip.NewReader(f)
+		// 進捗は圧縮されたアーカイブを読んだ量で数える
+		gzr, err := gzip.NewReader(newProgressReader(ctx, f))
 		if err != nil {
-			return err
+			return newAppError(ErrUnsupportedArchive, filepath.Base(path), err)
 		}
 		defer gzr.Close()
 		tr := tar.NewReader(gzr)
-		if _, err := tr.Next(); err == nil {
-			// workFile ではなく restoredPath に保存
-			return a.saveToWorkFile(tr, restoredPath)
+		if _, err := tr.Next(); err != nil {
+			return newAppError(ErrUnsupportedArchive, filepath.Base(path), err)
 		}
+		// workFile ではなく restoredPath に保存
+		return a.saveToWorkFile(tr, restoredPath)
 	}
 
 	// 4. フルコピー (.clip / .psd 等)
 	// workFile ではなく restoredPath にコピー
-	return CopyFile(path, restoredPath)
+	return copyFileContext(ctx, path, restoredPath)
 }
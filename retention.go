@@ -0,0 +1,556 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----------------- 保持ルールと古いバックアップの整理 (prune) -----------------
+//
+// 作業ファイルのバックアップ (コピー・アーカイブ・差分・重複排除マニフェスト) を 1 つずつスナップショットとみなし、
+// RetentionPolicy で残すものを選びます。PreviewPrune は削除予定を返すだけで、ApplyPrune が実際に削除します。
+// 残す差分が参照する .base と最新世代の .base は消さず、.base ごと不要になった世代はフォルダごと削除します。
+// どのマニフェストからも参照されなくなったチャンクも削除します。
+
+// 残す理由 (PruneItem.Reason)
+const (
+	pruneReasonNewest  = "newest"            // 最新のバックアップは常に残す
+	pruneReasonLast    = "last"              // KeepLast
+	pruneReasonDaily   = "daily"             // KeepDaily
+	pruneReasonWeekly  = "weekly"            // KeepWeekly
+	pruneReasonMonthly = "monthly"           // KeepMonthly
+	pruneReasonAll     = "all"               // 件数のルールが無い (MaxTotalSize のみ)
+	pruneReasonBase    = "base"              // 残す差分が参照している .base
+	pruneReasonLatest  = "latest-generation" // 次の差分が使う最新世代の .base
+)
+
+// pruneKindChunks は参照されなくなったチャンクをまとめた PruneItem の Kind です
+const pruneKindChunks = "chunks"
+
+// PruneItem は整理の対象になるファイル 1 つ分です (チャンクはまとめて 1 つ)
+type PruneItem struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"` // copy / archive / diff / dedup / base / chunks
+	Size   int64  `json:"size"`
+	Time   string `json:"time,omitempty"`
+	Reason string `json:"reason,omitempty"` // 残す理由 (削除するものは空)
+	Count  int    `json:"count,omitempty"`  // chunks のときのチャンク数
+}
+
+// PrunePlan は整理の計画です。ApplyPrune が返したものは Applied が true になります
+type PrunePlan struct {
+	Root       string      `json:"root"`
+	Applied    bool        `json:"applied"`
+	Keep       []PruneItem `json:"keep"`
+	Delete     []PruneItem `json:"delete"`
+	RemoveDirs []string    `json:"removeDirs,omitempty"` // 空になるので削除する世代フォルダ
+	KeepBytes  int64       `json:"keepBytes"`
+	FreeBytes  int64       `json:"freeBytes"`
+}
+
+// backupTimePattern はバックアップ名のうちファイル名に続くタイムスタンプです
+var backupTimePattern = regexp.MustCompile(`^(\d{8}_\d{6})`)
+
+// pruneSnapshot は保持ルールを当てはめるバックアップ 1 つ分です
+type pruneSnapshot struct {
+	item   PruneItem
+	time   time.Time
+	base   string   // 差分が参照する .base (フルパス)
+	chunks []string // マニフェストが参照するチャンクのハッシュ
+}
+
+// GetRetentionPolicy は作業ファイルの保持ルールを返します (未設定ならすべて 0)
+func (a *App) GetRetentionPolicy(workFile string) RetentionPolicy {
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	return a.cfg.RetentionPolicies[workFile]
+}
+
+// SetRetentionPolicy は作業ファイルの保持ルールを保存します
+func (a *App) SetRetentionPolicy(workFile string, policy RetentionPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	a.cfgMu.Lock()
+	defer a.cfgMu.Unlock()
+	if a.cfg.RetentionPolicies == nil {
+		a.cfg.RetentionPolicies = map[string]RetentionPolicy{}
+	}
+	if policy == (RetentionPolicy{}) {
+		delete(a.cfg.RetentionPolicies, workFile)
+	} else {
+		a.cfg.RetentionPolicies[workFile] = policy
+	}
+	data, err := json.MarshalIndent(a.cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.configPath, data, 0644)
+}
+
+func (p RetentionPolicy) validate() error {
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.MaxTotalSize < 0 {
+		return newAppError(ErrInvalidPolicy, "", nil)
+	}
+	return nil
+}
+
+// PreviewPrune は policy で整理したときに残るもの・削除されるものを返します (何も削除しません)
+func (a *App) PreviewPrune(workFile, backupDir string, policy RetentionPolicy) (*PrunePlan, error) {
+	if err := a.prepareBackupRootIfIdle(backupRootOf(workFile, backupDir)); err != nil {
+		return nil, err
+	}
+	return a.planPrune(workFile, backupDir, policy)
+}
+
+// ApplyPrune は policy で古いバックアップを削除し、実行した計画を返します
+func (a *App) ApplyPrune(workFile, backupDir string, policy RetentionPolicy) (*PrunePlan, error) {
+	unlock, err := a.lockRoot(context.Background(), backupRootOf(workFile, backupDir))
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if err := a.prepareBackupRoot(backupRootOf(workFile, backupDir)); err != nil {
+		return nil, err
+	}
+	plan, err := a.planPrune(workFile, backupDir, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	// 差分・マニフェストなどを先に、.base を最後に消す (途中で失敗しても残った差分の .base は残る)
+	byDir := map[string][]string{}
+	for _, item := range plan.Delete {
+		if item.Kind != pruneKindChunks {
+			byDir[filepath.Dir(item.Path)] = append(byDir[filepath.Dir(item.Path)], filepath.Base(item.Path))
+		}
+	}
+	for dir, names := range byDir {
+		if err := removeBackupFiles(dir, names); err != nil {
+			return plan, err
+		}
+	}
+
+	// チャンクはマニフェストを消した後のディスクの状態で数え直す
+	chunks, _, err := unreferencedChunks(plan.Root, nil)
+	if err != nil {
+		return plan, err
+	}
+	for _, path := range chunks {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return plan, newAppError(ErrPruneFailed, path, err)
+		}
+		os.Remove(filepath.Dir(path)) // 空になったサブフォルダ (chunks/ab) を片付ける
+	}
+
+	for _, dir := range plan.RemoveDirs {
+		if hasBackupArtifacts(dir) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return plan, newAppError(ErrPruneFailed, dir, err)
+		}
+	}
+	plan.Applied = true
+	return plan, nil
+}
+
+// planPrune は保持ルールを当てはめて PrunePlan を作ります
+func (a *App) planPrune(workFile, backupDir string, policy RetentionPolicy) (*PrunePlan, error) {
+	if err := policy.validate(); err != nil {
+		return nil, err
+	}
+	if policy == (RetentionPolicy{}) {
+		return nil, newAppError(ErrPolicyNotSet, "", nil)
+	}
+	root := backupRootOf(workFile, backupDir)
+
+	gm := a.generationManager(root)
+	snaps, err := collectPruneSnapshots(gm, workFile)
+	if err != nil {
+		return nil, err
+	}
+	selectRetained(snaps, policy)
+
+	// この作業ファイルの .base (世代ごと + 差分が参照するもの) と、常に残す最新世代の .base
+	bases := map[string]bool{}
+	latestBase := ""
+	gens, err := gm.ListGenerations()
+	if err != nil {
+		return nil, err
+	}
+	for _, gen := range gens {
+		bases[gm.BasePath(gen.DirPath, workFile)] = true
+	}
+	if len(gens) > 0 {
+		latestBase = gm.BasePath(gens[len(gens)-1].DirPath, workFile)
+	}
+	for _, s := range snaps {
+		if s.base != "" {
+			bases[s.base] = true
+		}
+	}
+
+	sizes := map[string]int64{}
+	sizeOf := func(path string) int64 {
+		if size, ok := sizes[path]; ok {
+			return size
+		}
+		var size int64 = -1 // 存在しない
+		if info, err := os.Stat(path); err == nil {
+			size = info.Size()
+		}
+		sizes[path] = size
+		return size
+	}
+	// usage は残すスナップショットと、それが必要とする .base・チャンクの合計サイズです
+	usage := func() int64 {
+		var total int64
+		seen := map[string]bool{}
+		add := func(path string) {
+			if !seen[path] {
+				seen[path] = true
+				if size := sizeOf(path); size > 0 {
+					total += size
+				}
+			}
+		}
+		if latestBase != "" {
+			add(latestBase)
+		}
+		for _, s := range snaps {
+			if s.item.Reason == "" {
+				continue
+			}
+			total += s.item.Size
+			if s.base != "" {
+				add(s.base)
+			}
+			for _, hash := range s.chunks {
+				add(chunkPath(root, hash))
+			}
+		}
+		return total
+	}
+	// 合計サイズの上限を超える間は、残す中で最も古いものから外す (最新は外さない)
+	if policy.MaxTotalSize > 0 {
+		for i := len(snaps) - 1; i > 0 && usage() > policy.MaxTotalSize; i-- {
+			snaps[i].item.Reason = ""
+		}
+	}
+
+	plan := &PrunePlan{Root: root, Keep: []PruneItem{}, Delete: []PruneItem{}}
+	deleted := map[string]bool{}
+	neededBases := map[string]bool{}
+	skipManifests := map[string]bool{}
+	for _, s := range snaps {
+		if s.item.Reason != "" {
+			plan.Keep = append(plan.Keep, s.item)
+			if s.base != "" {
+				neededBases[s.base] = true
+			}
+			continue
+		}
+		plan.Delete = append(plan.Delete, s.item)
+		deleted[s.item.Path] = true
+		if s.item.Kind == checksumKindDedup {
+			skipManifests[s.item.Path] = true
+		}
+	}
+
+	basePaths := make([]string, 0, len(bases))
+	for path := range bases {
+		basePaths = append(basePaths, path)
+	}
+	sort.Strings(basePaths)
+	for _, path := range basePaths {
+		size := sizeOf(path)
+		if size < 0 {
+			continue
+		}
+		item := PruneItem{Path: path, Kind: checksumKindBase, Size: size}
+		switch {
+		case path == latestBase:
+			item.Reason = pruneReasonLatest
+		case neededBases[path]:
+			item.Reason = pruneReasonBase
+		}
+		if item.Reason != "" {
+			plan.Keep = append(plan.Keep, item)
+		} else {
+			plan.Delete = append(plan.Delete, item)
+			deleted[path] = true
+		}
+	}
+
+	chunks, chunkBytes, err := unreferencedChunks(root, skipManifests)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) > 0 {
+		plan.Delete = append(plan.Delete, PruneItem{Path: filepath.Join(root, dedupChunkDir), Kind: pruneKindChunks,
+			Size: chunkBytes, Count: len(chunks)})
+	}
+
+	// バックアップが 1 つも残らない世代フォルダはフォルダごと削除する
+	for _, gen := range gens {
+		entries, err := os.ReadDir(gen.DirPath)
+		if err != nil {
+			return nil, err
+		}
+		empty := true
+		for _, e := range entries {
+			if !e.IsDir() && isBackupArtifactName(e.Name()) && !deleted[filepath.Join(gen.DirPath, e.Name())] {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			plan.RemoveDirs = append(plan.RemoveDirs, gen.DirPath)
+		}
+	}
+
+	for _, item := range plan.Keep {
+		plan.KeepBytes += item.Size
+	}
+	for _, item := range plan.Delete {
+		plan.FreeBytes += item.Size
+	}
+	return plan, nil
+}
+
+// collectPruneSnapshots はバックアップルートと世代フォルダから workFile のバックアップを新しい順に集めます
+func collectPruneSnapshots(gm *GenerationManager, workFile string) ([]*pruneSnapshot, error) {
+	gens, err := gm.ListGenerations()
+	if err != nil {
+		return nil, err
+	}
+	dirs := []string{gm.BackupRoot}
+	for _, gen := range gens {
+		dirs = append(dirs, gen.DirPath)
+	}
+
+	var snaps []*pruneSnapshot
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		sums, err := loadChecksums(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || !isBackupArtifactName(name) || filepath.Ext(name) == ".base" {
+				continue
+			}
+			stamp, ok := backupTimestamp(name, workFile)
+			if !ok {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			path := filepath.Join(dir, name)
+			s := &pruneSnapshot{time: stamp, item: PruneItem{Path: path, Size: info.Size(), Time: stamp.Format("2006-01-02 15:04:05")}}
+			entry, recorded := sums.Files[name]
+			s.item.Kind = entry.Kind
+			if !recorded {
+				s.item.Kind = guessBackupKind(name)
+			}
+			switch s.item.Kind {
+			case checksumKindDiff:
+				if entry.Base != "" {
+					s.base = filepath.Join(dir, entry.Base)
+				} else {
+					s.base = gm.BasePath(dir, workFile)
+				}
+			case checksumKindDedup:
+				m, err := readManifest(path)
+				if err != nil {
+					return nil, fmt.Errorf("マニフェストを読めないため整理を中止しました (%s): %w", name, err)
+				}
+				for _, c := range m.Chunks {
+					s.chunks = append(s.chunks, c.Hash)
+				}
+			}
+			snaps = append(snaps, s)
+		}
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].time.After(snaps[j].time) })
+	return snaps, nil
+}
+
+// selectRetained は新しい順に並んだ snaps のうち、残すものの Reason を設定します
+func selectRetained(snaps []*pruneSnapshot, p RetentionPolicy) {
+	countRules := p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0
+	buckets := []struct {
+		reason string
+		n      int
+		key    func(time.Time) string
+		last   string
+	}{
+		{reason: pruneReasonDaily, n: p.KeepDaily, key: func(t time.Time) string { return t.Format("2006-01-02") }},
+		{reason: pruneReasonWeekly, n: p.KeepWeekly, key: func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{reason: pruneReasonMonthly, n: p.KeepMonthly, key: func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for i, s := range snaps {
+		switch {
+		case !countRules:
+			s.item.Reason = pruneReasonAll
+		case i == 0:
+			s.item.Reason = pruneReasonNewest
+		case i < p.KeepLast:
+			s.item.Reason = pruneReasonLast
+		}
+		// 各期間 (日・週・月) で最も新しいものを、期間の数だけ残す
+		for b := range buckets {
+			bucket := &buckets[b]
+			if bucket.n <= 0 {
+				continue
+			}
+			if key := bucket.key(s.time); key != bucket.last {
+				bucket.last = key
+				bucket.n--
+				if s.item.Reason == "" {
+					s.item.Reason = bucket.reason
+				}
+			}
+		}
+	}
+}
+
+// backupTimestamp は name が workFile のバックアップ (file.<ts>.* / name_<ts>.*) ならその作成日時を返します
+func backupTimestamp(name, workFile string) (time.Time, bool) {
+	fileName := filepath.Base(workFile)
+	stem := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	for _, prefix := range []string{fileName + ".", stem + "_"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if m := backupTimePattern.FindStringSubmatch(name[len(prefix):]); m != nil {
+			if t, err := time.ParseInLocation("20060102_150405", m[1], time.Local); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// guessBackupKind は checksum.json に記録の無いバックアップの種類を拡張子から推測します
+func guessBackupKind(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".diff"):
+		return checksumKindDiff
+	case strings.HasSuffix(name, dedupManifestExt):
+		return checksumKindDedup
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tar"):
+		return checksumKindArchive
+	}
+	return checksumKindCopy
+}
+
+// unreferencedChunks はルート直下のどのマニフェスト (skip を除く) からも参照されないチャンクを返します。
+// チャンクストアは同じルートを使う全ファイルで共有なので、他のファイルのマニフェストも数えます
+func unreferencedChunks(root string, skip map[string]bool) ([]string, int64, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	referenced := map[string]bool{}
+	for _, e := range entries {
+		path := filepath.Join(root, e.Name())
+		if e.IsDir() || !strings.HasSuffix(e.Name(), dedupManifestExt) || !isBackupArtifactName(e.Name()) || skip[path] {
+			continue
+		}
+		m, err := readManifest(path)
+		if err != nil {
+			return nil, 0, fmt.Errorf("マニフェストを読めないためチャンクを整理できません (%s): %w", e.Name(), err)
+		}
+		for _, c := range m.Chunks {
+			referenced[c.Hash] = true
+		}
+	}
+
+	var paths []string
+	var total int64
+	err = filepath.WalkDir(filepath.Join(root, dedupChunkDir), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		// 書き込み途中の一時ファイル (先頭が ".") には触れない
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || referenced[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		total += info.Size()
+		return nil
+	})
+	return paths, total, err
+}
+
+// removeBackupFiles は dir 内の names (と添付のメモ) を削除し、checksum.json からも外します
+func removeBackupFiles(dir string, names []string) error {
+	m, err := loadChecksums(dir)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return filepath.Ext(names[i]) != ".base" && filepath.Ext(names[j]) == ".base"
+	})
+	var removeErr error
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			removeErr = newAppError(ErrPruneFailed, path, err)
+			break
+		}
+		os.Remove(path + ".note")
+		delete(m.Files, name)
+	}
+	if len(m.Files) == 0 {
+		os.Remove(filepath.Join(dir, checksumFileName))
+	} else if err := saveChecksums(dir, m); err != nil {
+		return err
+	}
+	return removeErr
+}
+
+// hasBackupArtifacts は dir にバックアップ本体が残っているかを返します
+func hasBackupArtifacts(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return !os.IsNotExist(err) // 読めないフォルダは消さない
+	}
+	for _, e := range entries {
+		if !e.IsDir() && isBackupArtifactName(e.Name()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pruneFixture は整理のテスト用に、作成日時を名前で指定したバックアップをルートに並べます
+type pruneFixture struct {
+	t    *testing.T
+	a    *App
+	work string // 作業ファイル
+	root string // バックアップルート
+}
+
+func newPruneFixture(t *testing.T) *pruneFixture {
+	a := newTestApp(t)
+	work := filepath.Join(t.TempDir(), "work.bin")
+	writeTestFile(t, work, []byte("work"))
+	return &pruneFixture{t: t, a: a, work: work, root: DefaultBackupDir(work)}
+}
+
+// gen は世代フォルダ (baseN_<stamp>) のパスを返します
+func (f *pruneFixture) gen(idx int, stamp string) string {
+	return filepath.Join(f.root, fmt.Sprintf("base%d_%s", idx, stamp))
+}
+
+// add は dir に name のバックアップを書き、checksum.json に種類 kind (差分なら参照する .base) で記録します
+func (f *pruneFixture) add(dir, name, kind, base string) string {
+	f.t.Helper()
+	path := filepath.Join(dir, name)
+	writeTestFile(f.t, path, []byte(name))
+	m, err := loadChecksums(dir)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	entry := ChecksumEntry{Kind: kind, Size: int64(len(name)), Created: "2024-01-01T00:00:00Z"}
+	if kind != checksumKindBase {
+		entry.Source = f.work
+	}
+	if base != "" {
+		entry.Base = filepath.Base(base)
+	}
+	m.Files[name] = entry
+	if err := saveChecksums(dir, m); err != nil {
+		f.t.Fatal(err)
+	}
+	return path
+}
+
+// addBase は世代フォルダ dir に作業ファイルの .base を書きます
+func (f *pruneFixture) addBase(dir string) string {
+	return f.add(dir, filepath.Base(f.work)+".base", checksumKindBase, "")
+}
+
+// addDiff は世代フォルダ dir に stamp 時点の差分を書きます
+func (f *pruneFixture) addDiff(dir, stamp string) string {
+	return f.add(dir, filepath.Base(f.work)+"."+stamp+".hdiff.diff", checksumKindDiff, filepath.Base(f.work)+".base")
+}
+
+// addManifest は root に workFile の stamp 時点のマニフェストを、chunks の内容のチャンクと一緒に書きます
+func (f *pruneFixture) addManifest(workFile, stamp string, chunks ...string) string {
+	f.t.Helper()
+	m := DedupManifest{Format: dedupManifestFormat, FileName: filepath.Base(workFile)}
+	for _, c := range chunks {
+		sum := sha256.Sum256([]byte(c))
+		hash := hex.EncodeToString(sum[:])
+		if _, err := storeChunk(f.root, hash, []byte(c)); err != nil {
+			f.t.Fatal(err)
+		}
+		m.Chunks = append(m.Chunks, DedupChunk{Hash: hash, Size: int64(len(c))})
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	name := filepath.Base(workFile) + "." + stamp + dedupManifestExt
+	path := filepath.Join(f.root, name)
+	writeTestFile(f.t, path, data)
+	sums, err := loadChecksums(f.root)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	sums.Files[name] = ChecksumEntry{Kind: checksumKindDedup, Source: workFile, Created: "2024-01-01T00:00:00Z"}
+	if err := saveChecksums(f.root, sums); err != nil {
+		f.t.Fatal(err)
+	}
+	return path
+}
+
+func (f *pruneFixture) apply(policy RetentionPolicy) *PrunePlan {
+	f.t.Helper()
+	plan, err := f.a.ApplyPrune(f.work, "", policy)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return plan
+}
+
+func assertExists(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s was removed: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func assertRemoved(t *testing.T, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// 残す差分が参照する .base は、その世代の他の差分を消しても残す
+func TestPruneKeepsBaseOfKeptDiff(t *testing.T) {
+	f := newPruneFixture(t)
+	gen1 := f.gen(1, "20240101_000000")
+	base1 := f.addBase(gen1)
+	d1 := f.addDiff(gen1, "20240101_100000")
+	d2 := f.addDiff(gen1, "20240102_100000")
+	gen2 := f.gen(2, "20240103_000000")
+	base2 := f.addBase(gen2)
+	d3 := f.addDiff(gen2, "20240103_100000")
+
+	preview, err := f.a.PreviewPrune(f.work, "", RetentionPolicy{KeepLast: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Delete) != 1 || preview.Delete[0].Path != d1 {
+		t.Errorf("preview deletes %+v, want only %s", preview.Delete, filepath.Base(d1))
+	}
+	assertExists(t, d1) // プレビューは何も消さない
+
+	f.apply(RetentionPolicy{KeepLast: 2})
+	assertRemoved(t, d1)
+	assertExists(t, base1, d2, base2, d3)
+
+	// 世代 1 の差分がすべて消えれば、その .base と世代フォルダも消す
+	f.apply(RetentionPolicy{KeepLast: 1})
+	assertRemoved(t, d2, base1, gen1)
+	assertExists(t, base2, d3)
+}
+
+// 最新世代の .base は、それを参照する差分が残らなくても消さない (次の差分が使う)
+func TestPruneKeepsLatestBase(t *testing.T) {
+	f := newPruneFixture(t)
+	gen1 := f.gen(1, "20240101_000000")
+	f.addBase(gen1)
+	d1 := f.addDiff(gen1, "20240101_100000")
+	gen2 := f.gen(2, "20240102_000000")
+	base2 := f.addBase(gen2)
+	d2 := f.addDiff(gen2, "20240102_100000")
+	// 最新のバックアップは差分ではなくルートのコピー
+	cp := f.add(f.root, "work_20240103_100000.bin", checksumKindCopy, "")
+
+	plan := f.apply(RetentionPolicy{KeepLast: 1})
+	assertRemoved(t, d1, d2, gen1)
+	assertExists(t, cp, base2, gen2)
+	for _, dir := range plan.RemoveDirs {
+		if dir == gen2 {
+			t.Errorf("latest generation %s planned for removal", filepath.Base(gen2))
+		}
+	}
+}
+
+// 他のマニフェスト (別の作業ファイルのものも含む) が参照するチャンクは消さない
+func TestPruneChunksReferencedElsewhere(t *testing.T) {
+	f := newPruneFixture(t)
+	other := filepath.Join(filepath.Dir(f.work), "other.bin")
+	old := f.addManifest(f.work, "20240101_100000", "shared", "only-old")
+	latest := f.addManifest(f.work, "20240102_100000", "only-latest")
+	f.addManifest(other, "20240101_100000", "shared", "only-other")
+
+	chunk := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return chunkPath(f.root, hex.EncodeToString(sum[:]))
+	}
+	plan := f.apply(RetentionPolicy{KeepLast: 1})
+	assertRemoved(t, old, chunk("only-old"))
+	assertExists(t, latest, chunk("shared"), chunk("only-latest"), chunk("only-other"))
+
+	var chunkItems int
+	for _, item := range plan.Delete {
+		if item.Kind == pruneKindChunks {
+			chunkItems += item.Count
+		}
+	}
+	if chunkItems != 1 {
+		t.Errorf("plan deletes %d chunks, want 1", chunkItems)
+	}
+}
+
+// 消したバックアップのメモと checksum.json の記録も消す
+func TestPruneRemovesNotesAndChecksums(t *testing.T) {
+	f := newPruneFixture(t)
+	old := f.add(f.root, "work_20240101_100000.bin", checksumKindCopy, "")
+	writeTestFile(t, old+".note", []byte("note"))
+	latest := f.add(f.root, "work_20240102_100000.bin", checksumKindCopy, "")
+	writeTestFile(t, latest+".note", []byte("note"))
+
+	f.apply(RetentionPolicy{KeepLast: 1})
+	assertRemoved(t, old, old+".note")
+	assertExists(t, latest, latest+".note")
+	m, err := loadChecksums(f.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.Files[filepath.Base(old)]; ok {
+		t.Error("checksum entry of the removed backup is left")
+	}
+	if _, ok := m.Files[filepath.Base(latest)]; !ok {
+		t.Error("checksum entry of the kept backup was removed")
+	}
+}
+
+// 世代フォルダは、中のバックアップがすべて消えるときだけ消す
+func TestPruneRemoveDirsOnlyEmpty(t *testing.T) {
+	f := newPruneFixture(t)
+	gen1 := f.gen(1, "20240101_000000")
+	base1 := f.addBase(gen1)
+	d1 := f.addDiff(gen1, "20240101_100000")
+	// 同じ世代フォルダにある別の作業ファイルのバックアップ
+	otherDiff := f.add(gen1, "other.bin.20240101_110000.hdiff.diff", checksumKindDiff, "other.bin.base")
+	gen2 := f.gen(2, "20240102_000000")
+	f.addBase(gen2)
+	f.addDiff(gen2, "20240102_100000")
+	gen3 := f.gen(3, "20240103_000000")
+	f.addBase(gen3)
+	d3 := f.addDiff(gen3, "20240103_100000")
+
+	// 世代 2 は空になる。世代 1 には他の作業ファイルの差分が残る
+	preview, err := f.a.PreviewPrune(f.work, "", RetentionPolicy{KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.RemoveDirs) != 1 || preview.RemoveDirs[0] != gen2 {
+		t.Errorf("RemoveDirs = %v, want [%s]", preview.RemoveDirs, gen2)
+	}
+
+	f.apply(RetentionPolicy{KeepLast: 1})
+	assertRemoved(t, gen2, base1, d1)
+	assertExists(t, gen1, otherDiff, gen3, d3)
+
+	// 残る世代フォルダの checksum.json からは、消したものの記録だけを外す
+	m, err := loadChecksums(gen1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 {
+		t.Errorf("checksum entries left in %s: %v, want only %s", filepath.Base(gen1), m.Files, filepath.Base(otherDiff))
+	}
+	if _, ok := m.Files[filepath.Base(otherDiff)]; !ok {
+		t.Errorf("checksum entry of %s was removed", filepath.Base(otherDiff))
+	}
+}
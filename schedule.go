@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"cg-file-backup/libs/cron"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// ----------------- 時刻によるバックアップ (スケジュール) -----------------
+//
+// 作業ファイルごとに「N 分ごと」か cron 式でバックアップする時刻を決めておくと、起動中は runScheduler が
+// scheduleCheckInterval ごとに時刻の来たものを実行します。最新のバックアップから内容 (SHA-256) が
+// 変わっていなければ、バックアップ処理 (checkUnchanged) が何も作りません。設定と前回の実行は
+// ユーザー設定フォルダの schedules.json に保存するので、再起動しても続きから動きます
+// (止まっている間に過ぎた時刻の分は、起動後にまとめて 1 回だけ実行します)。
+
+const (
+	scheduleFileName      = "schedules.json"
+	scheduleCheckInterval = 30 * time.Second
+)
+
+// スケジュールの実行結果 (BackupSchedule.LastResult)
+const (
+	scheduleResultBackup    = "backup"    // バックアップした
+	scheduleResultUnchanged = "unchanged" // 前回から変わっていないので何もしなかった
+	scheduleResultError     = "error"     // 失敗した (LastError に内容)
+)
+
+// BackupSchedule は作業ファイル 1 つ分のスケジュールです (IntervalMinutes と Cron はどちらか一方)
+type BackupSchedule struct {
+	WorkFile        string `json:"workFile"`
+	BackupDir       string `json:"backupDir"`       // 空なら cg_backup_<name>
+	Mode            string `json:"mode"`            // backupModes のいずれか (空なら diff)
+	Algo            string `json:"algo"`            // diff のときのアルゴリズム (空なら既定)
+	IntervalMinutes int    `json:"intervalMinutes"` // N 分ごと
+	Cron            string `json:"cron"`            // cron 式 (分 時 日 月 曜日、@daily など)
+	LastRun         string `json:"lastRun"`         // 最後に時刻が来た (または設定した) 時刻 (RFC3339)
+	LastResult      string `json:"lastResult"`      // 最後の実行結果 (scheduleResult*)
+	LastError       string `json:"lastError"`
+}
+
+// ScheduleEvent はスケジュールによるバックアップ 1 回分の結果です ("schedule-backup" イベント)
+type ScheduleEvent struct {
+	WorkFile string `json:"workFile"`
+	Result   string `json:"result"`
+	Error    any    `json:"error,omitempty"` // formatError の形
+	Time     string `json:"time"`
+}
+
+// GetSchedules は保存されているスケジュールの一覧を返します
+func (a *App) GetSchedules() ([]BackupSchedule, error) {
+	a.scheduleMu.Lock()
+	defer a.scheduleMu.Unlock()
+	if err := a.loadSchedules(); err != nil {
+		return nil, err
+	}
+	list := []BackupSchedule{}
+	for _, s := range a.schedules {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].WorkFile < list[j].WorkFile })
+	return list, nil
+}
+
+// GetSchedule は作業ファイルのスケジュールを返します (無ければ空)
+func (a *App) GetSchedule(workFile string) (BackupSchedule, error) {
+	a.scheduleMu.Lock()
+	defer a.scheduleMu.Unlock()
+	if err := a.loadSchedules(); err != nil {
+		return BackupSchedule{}, err
+	}
+	return a.schedules[workFile], nil
+}
+
+// SetSchedule はスケジュールを保存します。間隔も cron 式も空なら作業ファイルのスケジュールを削除します。
+// 時刻の決め方を変えたときは、今から数え直します
+func (a *App) SetSchedule(s BackupSchedule) error {
+	if s.WorkFile == "" {
+		return newAppError(ErrInvalidPath, "", errors.New("path is empty"))
+	}
+	if s.Mode == "" {
+		s.Mode = "diff"
+	}
+	if err := validateSchedule(s); err != nil {
+		return err
+	}
+	if s.Mode == "diff" {
+		if _, err := a.diffAlgorithm(s.Algo); err != nil {
+			return err
+		}
+	}
+
+	a.scheduleMu.Lock()
+	defer a.scheduleMu.Unlock()
+	if err := a.loadSchedules(); err != nil {
+		return err
+	}
+	if s.IntervalMinutes == 0 && s.Cron == "" {
+		delete(a.schedules, s.WorkFile)
+		return a.saveSchedules()
+	}
+	old, ok := a.schedules[s.WorkFile]
+	s.LastResult, s.LastError = old.LastResult, old.LastError
+	s.LastRun = old.LastRun
+	if !ok || old.IntervalMinutes != s.IntervalMinutes || old.Cron != s.Cron {
+		s.LastRun = time.Now().Format(time.RFC3339)
+	}
+	a.schedules[s.WorkFile] = s
+	return a.saveSchedules()
+}
+
+// validateSchedule はスケジュールの値を確かめます
+func validateSchedule(s BackupSchedule) error {
+	if !slices.Contains(backupModes, s.Mode) {
+		return newAppError(ErrInvalidSchedule, s.Mode, errors.New("unknown backup mode"))
+	}
+	if s.IntervalMinutes < 0 {
+		return newAppError(ErrInvalidSchedule, "", errors.New("interval is negative"))
+	}
+	if s.IntervalMinutes > 0 && s.Cron != "" {
+		return newAppError(ErrInvalidSchedule, "", errors.New("set either an interval or a cron expression"))
+	}
+	if s.Cron != "" {
+		if _, err := cron.Parse(s.Cron); err != nil {
+			return newAppError(ErrInvalidSchedule, s.Cron, err)
+		}
+	}
+	return nil
+}
+
+// nextRun はスケジュールの次の時刻を返します (決められなければゼロ)
+func (s BackupSchedule) nextRun() time.Time {
+	last, err := time.Parse(time.RFC3339, s.LastRun)
+	if err != nil {
+		// 記録が壊れていれば今すぐ
+		return time.Now()
+	}
+	if s.Cron != "" {
+		sched, err := cron.Parse(s.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return sched.Next(last.Local())
+	}
+	if s.IntervalMinutes > 0 {
+		return last.Add(time.Duration(s.IntervalMinutes) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// runScheduler は時刻の来たスケジュールを実行し続けます (startup から起動)
+func (a *App) runScheduler() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		a.runDueSchedules(time.Now())
+		<-ticker.C
+	}
+}
+
+// runDueSchedules は now までに時刻の来たスケジュールを順に実行します
+func (a *App) runDueSchedules(now time.Time) {
+	a.scheduleMu.Lock()
+	if err := a.loadSchedules(); err != nil {
+		a.scheduleMu.Unlock()
+		return
+	}
+	var due []BackupSchedule
+	for _, s := range a.schedules {
+		if next := s.nextRun(); !next.IsZero() && !next.After(now) {
+			due = append(due, s)
+		}
+	}
+	a.scheduleMu.Unlock()
+
+	for _, s := range due {
+		ev := a.runSchedule(&s, now)
+
+		a.scheduleMu.Lock()
+		// 実行中に設定が変えられたり消されたりしていれば、結果だけを残す
+		if cur, ok := a.schedules[s.WorkFile]; ok {
+			if cur.IntervalMinutes == s.IntervalMinutes && cur.Cron == s.Cron {
+				cur.LastRun = s.LastRun
+			}
+			cur.LastResult, cur.LastError = s.LastResult, s.LastError
+			a.schedules[s.WorkFile] = cur
+			a.saveSchedules()
+		}
+		a.scheduleMu.Unlock()
+
+		if a.ctx != nil && ev.Result != scheduleResultUnchanged {
+			runtime.EventsEmit(a.ctx, "schedule-backup", ev)
+		}
+	}
+}
+
+// runSchedule は作業ファイルが最後のバックアップから変わっていればバックアップし、s に結果を記録します
+func (a *App) runSchedule(s *BackupSchedule, now time.Time) ScheduleEvent {
+	s.LastRun = now.Format(time.RFC3339)
+	ev := ScheduleEvent{WorkFile: s.WorkFile, Time: now.Format("2006-01-02 15:04:05")}
+
+	res, err := a.runBackup(context.Background(), s.WorkFile, s.BackupDir, s.Mode, s.Algo, "")
+	switch {
+	case err != nil:
+		s.LastResult, s.LastError = scheduleResultError, err.Error()
+		ev.Error = formatError(err)
+	case res.Unchanged:
+		s.LastResult, s.LastError = scheduleResultUnchanged, ""
+	default:
+		s.LastResult, s.LastError = scheduleResultBackup, ""
+	}
+	ev.Result = s.LastResult
+	return ev
+}
+
+// loadSchedules は schedules.json を読み込みます (読み込み済みなら何もしません。scheduleMu を持って呼ぶこと)
+func (a *App) loadSchedules() error {
+	if a.schedules != nil {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(a.GetConfigDir(), scheduleFileName))
+	if os.IsNotExist(err) {
+		a.schedules = map[string]BackupSchedule{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []BackupSchedule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	a.schedules = map[string]BackupSchedule{}
+	for _, s := range list {
+		a.schedules[s.WorkFile] = s
+	}
+	return nil
+}
+
+// saveSchedules は schedules.json を書き出します (scheduleMu を持って呼ぶこと)
+func (a *App) saveSchedules() error {
+	list := make([]BackupSchedule, 0, len(a.schedules))
+	for _, s := range a.schedules {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].WorkFile < list[j].WorkFile })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileViaTemp(filepath.Join(a.GetConfigDir(), scheduleFileName), data)
+}
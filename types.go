@@ -14,6 +14,13 @@ type BackupItem struct {
 	Timestamp string `json:"timestamp"`
 	FileSize  int64  `json:"FileSize"`
 	Generation   int    `json:"generation"`   // 世代番号
+}
+
+// BackupResult はバックアップ 1 回分の結果です
+type BackupResult struct {
+	Unchanged bool   `json:"unchanged"` // 最新のバックアップから内容が変わっていないので、何も書かなかった
+	Path      string `json:"path"`      // 作成したファイル (Unchanged なら空)
+	SHA256    string `json:"sha256"`    // 作業ファイルの SHA-256
 }
 
 
@@ -21,6 +28,25 @@ type BackupItem struct {
 type GenerationManager struct {
 	BackupRoot string  // cg_backup_元ファイル名/ のパス
 	Threshold  float64 // ベース更新の閾値 (例: 0.8 = 80%)
+	Policy     RotationPolicy // 作業ファイルごとの世代交代ルール
+}
+
+// RotationPolicy 世代交代のルール (作業ファイルごとに設定。0 のものは使わない)
+type RotationPolicy struct {
+	SizeRatio       float64 `json:"sizeRatio"`       // 1回の差分が作業ファイルの何倍を超えたら交代するか (0 = 設定の autoBaseGenerationThreshold)
+	MinWorkSize     int64   `json:"minWorkSize"`     // これ以下のファイルは SizeRatio で交代しない (0 = 100KB)
+	MaxDiffs        int     `json:"maxDiffs"`        // 1世代に置く差分の最大数
+	MaxAgeDays      int     `json:"maxAgeDays"`      // 世代の最大日数
+	CumulativeRatio float64 `json:"cumulativeRatio"` // 世代内の差分の合計が .base の何倍を超えたら交代するか
+}
+
+// RetentionPolicy 古いバックアップをどこまで残すか (0 はそのルールを使わない)
+type RetentionPolicy struct {
+	KeepLast     int   `json:"keepLast"`     // 新しい順に残す数
+	KeepDaily    int   `json:"keepDaily"`    // 直近 N 日 (バックアップのある日) について各日の最新を残す
+	KeepWeekly   int   `json:"keepWeekly"`   // 直近 N 週について各週の最新を残す
+	KeepMonthly  int   `json:"keepMonthly"`  // 直近 N か月について各月の最新を残す
+	MaxTotalSize int64 `json:"maxTotalSize"` // 残すバックアップ (参照される .base・チャンクを含む) の合計の上限 (バイト)
 }
 
 // BackupGenInfo 現在の世代情報
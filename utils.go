@@ -83,6 +83,19 @@ func DefaultBackupDir(workFile string) string {
 	return filepath.Join(dir, "cg_backup_"+name)
 }
 
+// backupRootOf は作業ファイルのバックアップルートを返します。
+// backupDir が空なら既定のフォルダ、世代フォルダ (baseN_...) ならその親です
+func backupRootOf(workFile, backupDir string) string {
+	root := backupDir
+	if root == "" {
+		root = DefaultBackupDir(workFile)
+	}
+	if _, ok := ParseGenerationDir(filepath.Base(root)); ok {
+		root = filepath.Dir(root)
+	}
+	return root
+}
+
 func TimestampedName(original string) string {
 	ext := filepath.Ext(original)
 	name := strings.TrimSuffix(filepath.Base(original), ext)
@@ -97,18 +110,31 @@ func (a *App) SelectBackupFolder() (string, error) {
 	return runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{Title: "Folder Select"})
 }
 
-func (a *App) OpenDirectory(path string) {
-	target := filepath.Dir(path)
-	if goruntime.GOOS == "windows" {
-		exec.Command("explorer", filepath.Clean(target)).Run()
-	} else {
-		exec.Command("open", target).Run()
+// OpenDirectory は path のあるフォルダをファイルマネージャーで開きます
+func (a *App) OpenDirectory(path string) error {
+	target := filepath.Clean(filepath.Dir(path))
+	tool := "xdg-open"
+	switch goruntime.GOOS {
+	case "windows":
+		tool = "explorer"
+	case "darwin":
+		tool = "open"
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return newAppError(ErrExternalToolMissing, tool, err)
+	}
+	// explorer は開けても終了コード 1 を返すので、終了は待たない
+	cmd := exec.Command(tool, target)
+	if err := cmd.Start(); err != nil {
+		return newAppError(ErrExternalToolMissing, tool, err)
 	}
+	go cmd.Wait()
+	return nil
 }
 
 func (a *App) GetFileSize(path string) (int64, error) {
 	if path == "" {
-		return 0, fmt.Errorf("path is empty")
+		return 0, newAppError(ErrInvalidPath, "", fmt.Errorf("path is empty"))
 	}
 
 	info, err := os.Stat(path)
@@ -117,7 +143,7 @@ func (a *App) GetFileSize(path string) (int64, error) {
 	}
 
 	if info.IsDir() {
-		return 0, fmt.Errorf("path is a directory")
+		return 0, newAppError(ErrInvalidPath, path, fmt.Errorf("path is a directory"))
 	}
 
 	return info.Size(), nil
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cg-file-backup/libs/vcdiff"
+)
+
+// ----------------- VCDIFF (RFC 3284 / xdelta3 互換) -----------------
+
+// CreateVcdiff は OldFile から NewFile への差分を VCDIFF 形式で DiffFile に書き出します
+// (xdelta3 -d -s OldFile DiffFile で復元できます)
+func (a *App) CreateVcdiff(OldFile, NewFile, DiffFile string) error {
+	oldF, err := os.Open(OldFile)
+	if err != nil {
+		return err
+	}
+	defer oldF.Close()
+	newF, err := os.Open(NewFile)
+	if err != nil {
+		return err
+	}
+	defer newF.Close()
+
+	err = writeFileAtomic(DiffFile, func(out *os.File) error {
+		return vcdiff.Diff(oldF, newF, out)
+	})
+	if err != nil {
+		return fmt.Errorf("vcdiff 作成に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// ApplyVcdiff は baseFull に diffFile を適用して outPath に書き出します
+// (xdelta3 -e -S none で作成された差分もそのまま適用できます)
+func (a *App) ApplyVcdiff(baseFull, diffFile, outPath string) error {
+	base, err := os.Open(baseFull)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+	baseInfo, err := base.Stat()
+	if err != nil {
+		return err
+	}
+
+	diff, err := os.Open(diffFile)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	err = vcdiff.Patch(base, baseInfo.Size(), diff, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// 途中まで書かれた復元ファイルは残さない
+		os.Remove(outPath)
+		return newAppError(ErrPatchCorrupt, filepath.Base(diffFile), fmt.Errorf("vcdiff: %w", err))
+	}
+	return nil
+}
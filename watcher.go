@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// ----------------- 保存の監視 (自動バックアップ) -----------------
+//
+// 監視中の作業ファイルは watchPollInterval ごとにサイズと更新日時を調べます。
+// ペイントソフトは保存時に何度も書き込むので、変化が watchDebounce の間止まり、
+// 他のプロセスが開いたままでなくなってから BackupOrDiff を実行し、結果を "watch-backup" イベントで知らせます。
+// (ネットワーク共有でも動くよう、OS の変更通知ではなくポーリングを使います)
+
+const (
+	watchPollInterval = time.Second
+	watchDebounce     = 2 * time.Second // 最後の変化からこれだけ経てば保存が終わったとみなす
+)
+
+// fileWatcher は作業ファイル 1 つ分の監視です
+type fileWatcher struct {
+	workFile  string
+	backupDir string
+	algo      string
+	stop      chan struct{}
+}
+
+// fileSignature は変化の判定に使うサイズと更新日時です
+type fileSignature struct {
+	size    int64
+	modTime time.Time
+}
+
+// WatchEvent は自動バックアップ 1 回分の結果です ("watch-backup" イベント)
+type WatchEvent struct {
+	WorkFile string `json:"workFile"`
+	OK       bool   `json:"ok"`
+	Error    any    `json:"error,omitempty"` // formatError の形 (GUI で翻訳できる)
+	Time     string `json:"time"`
+}
+
+// StartWatch は workFile の監視を始め、保存されるたびに差分バックアップを取ります。
+// 同じ設定で監視中なら何もしません (設定が違えば監視し直します)
+func (a *App) StartWatch(workFile, backupDir, algo string) error {
+	info, err := os.Stat(workFile)
+	if err != nil {
+		return newAppError(ErrInvalidPath, workFile, err)
+	}
+	if info.IsDir() {
+		return newAppError(ErrInvalidPath, workFile, errors.New("path is a directory"))
+	}
+	if _, err := a.diffAlgorithm(algo); err != nil {
+		return err
+	}
+
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if w, ok := a.watchers[workFile]; ok {
+		if w.backupDir == backupDir && w.algo == algo {
+			return nil
+		}
+		close(w.stop)
+	}
+	if a.watchers == nil {
+		a.watchers = map[string]*fileWatcher{}
+	}
+	w := &fileWatcher{workFile: workFile, backupDir: backupDir, algo: algo, stop: make(chan struct{})}
+	a.watchers[workFile] = w
+	go a.watchLoop(w)
+	return nil
+}
+
+// StopWatch は workFile の監視をやめます
+func (a *App) StopWatch(workFile string) {
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	if w, ok := a.watchers[workFile]; ok {
+		close(w.stop)
+		delete(a.watchers, workFile)
+	}
+}
+
+// GetWatchedFiles は監視中の作業ファイルの一覧を返します
+func (a *App) GetWatchedFiles() []string {
+	a.watchMu.Lock()
+	defer a.watchMu.Unlock()
+	files := []string{}
+	for f := range a.watchers {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// watchLoop は stop が閉じられるまで w.workFile を監視します
+func (a *App) watchLoop(w *fileWatcher) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	last, _ := readFileSignature(w.workFile)
+	var changedAt time.Time // まだバックアップしていない変化を見つけた時刻 (無ければゼロ)
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+		sig, err := readFileSignature(w.workFile)
+		if err != nil {
+			// 一時ファイルからの置き換えで保存するソフトでは、一瞬ファイルが無いことがある
+			continue
+		}
+		if sig != last {
+			last, changedAt = sig, time.Now()
+			continue
+		}
+		if changedAt.IsZero() || time.Since(changedAt) < watchDebounce || !fileUnlocked(w.workFile) {
+			continue
+		}
+		changedAt = time.Time{}
+		a.runWatchBackup(w)
+	}
+}
+
+// runWatchBackup は差分バックアップを取り、結果をフロントエンドへ送ります
+// (内容が変わらない保存では何も作らないので知らせません)
+func (a *App) runWatchBackup(w *fileWatcher) {
+	res, err := a.BackupOrDiff(w.workFile, w.backupDir, w.algo)
+	if err == nil && res.Unchanged {
+		return
+	}
+	ev := WatchEvent{WorkFile: w.workFile, OK: err == nil, Time: time.Now().Format("2006-01-02 15:04:05")}
+	if err != nil {
+		ev.Error = formatError(err)
+	}
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "watch-backup", ev)
+	}
+}
+
+func readFileSignature(path string) (fileSignature, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileSignature{}, err
+	}
+	return fileSignature{size: info.Size(), modTime: info.ModTime()}, nil
+}
+
+// fileUnlocked は他のプロセスが書き込みのために開いたまま (Windows の共有違反) でないかを確かめます
+// (読み取り専用のファイルは読み込みで開ければよい)
+func fileUnlocked(path string) bool {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrPermission) {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"cg-file-backup/libs/zstdpatch"
+)
+
+// ----------------- zstd --patch-from 互換 -----------------
+
+// CreateZstdPatch は OldFile を辞書にして NewFile を zstd で圧縮し、DiffFile に書き出します
+// (zstd -d --patch-from=OldFile DiffFile で復元できます)
+// 圧縮レベルは設定の zstdPatchLevel (0 なら既定の 3) です
+func (a *App) CreateZstdPatch(OldFile, NewFile, DiffFile string) error {
+	oldF, err := os.Open(OldFile)
+	if err != nil {
+		return err
+	}
+	defer oldF.Close()
+	newF, err := os.Open(NewFile)
+	if err != nil {
+		return err
+	}
+	defer newF.Close()
+
+	err = writeFileAtomic(DiffFile, func(out *os.File) error {
+		return zstdpatch.Diff(oldF, newF, out, a.GetZstdPatchLevel())
+	})
+	if err != nil {
+		return fmt.Errorf("zstd 差分の作成に失敗しました: %w", err)
+	}
+	return nil
+}
+
+// ApplyZstdPatch は baseFull を辞書にして diffFile を展開し、outPath に書き出します
+// (zstd --patch-from で作成された差分もそのまま適用できます)
+func (a *App) ApplyZstdPatch(baseFull, diffFile, outPath string) error {
+	base, err := os.Open(baseFull)
+	if err != nil {
+		return err
+	}
+	defer base.Close()
+
+	diff, err := os.Open(diffFile)
+	if err != nil {
+		return err
+	}
+	defer diff.Close()
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	err = zstdpatch.Patch(base, diff, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// 途中まで書かれた復元ファイルは残さない
+		os.Remove(outPath)
+		return newAppError(ErrPatchCorrupt, filepath.Base(diffFile), fmt.Errorf("zstd: %w", err))
+	}
+	return nil
+}